@@ -1,14 +1,19 @@
 package awsecs
 
 import (
+	"context"
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"time"
-
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	tagging_types "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
 	"github.com/pkg/errors"
 	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/spf13/cobra"
@@ -19,102 +24,132 @@ const (
 	serviceVersionTagKey = "pvn:version"
 )
 
-type tagPair struct {
-	Key   string `json:"key"`
-	Value string `json:"value"`
+func describeTaskDefinition(ctx context.Context, client ecsClient, definition string) (*ecs.DescribeTaskDefinitionOutput, error) {
+	return client.DescribeTaskDefinition(ctx, definition)
 }
 
-type describeTaskDefinitionOutput struct {
-	Tags []tagPair `json:"tags"`
+func tagsToMap(tags []types.Tag) map[string]string {
+	tagMap := make(map[string]string)
+	for _, tag := range tags {
+		tagMap[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return tagMap
 }
 
-func describeTaskDefinition(definition string) (*describeTaskDefinitionOutput, error) {
-	describeCmd := exec.Command(
-		awsPath,
-		"ecs",
-		"describe-task-definition",
-		"--include=TAGS",
-		"--task-definition",
-		definition,
-	)
-	output, err := cmdutil.RunCmdOutput(describeCmd)
+func getValidTaskDefinitionArns(ctx context.Context, client ecsClient, pvnServiceId, pvnServiceVersion string) ([]string, error) {
+	resources, err := client.GetResources(ctx, "ecs:task-definition", []tagging_types.TagFilter{
+		{Key: aws.String(serviceIdTagKey), Values: []string{pvnServiceId}},
+		{Key: aws.String(serviceVersionTagKey), Values: []string{pvnServiceVersion}},
+	})
 	if err != nil {
 		return nil, err
 	}
-	var describeOutput describeTaskDefinitionOutput
-	if err := json.Unmarshal(output, &describeOutput); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarsal describe-task-definition output")
+	var validArns []string
+	for _, resource := range resources {
+		validArns = append(validArns, aws.ToString(resource.ResourceARN))
 	}
-	return &describeOutput, nil
+	return validArns, nil
 }
 
-func tagsToMap(tags []tagPair) map[string]string {
-	tagMap := make(map[string]string)
-	for _, tag := range tags {
-		tagMap[tag.Key] = tag.Value
-	}
-	return tagMap
+// taskDefinitionRevision is a task definition registered for a Prodvana Service, as found by its tags.
+type taskDefinitionRevision struct {
+	arn      string
+	family   string
+	revision int
+	// pvn:version tag
+	version string
 }
 
-type getResourcesOutput struct {
-	ResourceTagMappingList []struct {
-		ResourceARN string `json:"ResourceARN"`
-		// this endpoint uses capital CamelCase so it cannot use the same struct as the other endpoints
-		Tags []struct {
-			Key   string `json:"Key"`
-			Value string `json:"Value"`
-		} `json:"Tags"`
-	} `json:"ResourceTagMappingList"`
+// parseTaskDefinitionArn returns the family and revision of a task definition ARN,
+// arn:aws:ecs:<region>:<account>:task-definition/<family>:<revision>.
+func parseTaskDefinitionArn(arn string) (string, int, error) {
+	familyRevision := arn[strings.LastIndex(arn, "/")+1:]
+	sep := strings.LastIndex(familyRevision, ":")
+	if sep < 0 {
+		return "", 0, errors.Errorf("task definition ARN %s has no revision", arn)
+	}
+	revision, err := strconv.Atoi(familyRevision[sep+1:])
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "task definition ARN %s has an invalid revision", arn)
+	}
+	return familyRevision[:sep], revision, nil
 }
 
-func getValidTaskDefinitionArns(pvnServiceId, pvnServiceVersion string) ([]string, error) {
-	output, err := cmdutil.RunCmdOutput(exec.Command(
-		awsPath,
-		"resourcegroupstaggingapi",
-		"get-resources",
-		"--resource-type-filters", "ecs:task-definition",
-		"--tag-filters",
-		fmt.Sprintf("Key=%s,Values=%s", serviceIdTagKey, pvnServiceId),
-		fmt.Sprintf("Key=%s,Values=%s", serviceVersionTagKey, pvnServiceVersion),
-	))
+// listTaskDefinitionRevisions returns every task definition tagged with the Prodvana Service ID,
+// newest first within each family.
+func listTaskDefinitionRevisions(ctx context.Context, client ecsClient, pvnServiceId string) ([]taskDefinitionRevision, error) {
+	resources, err := client.GetResources(ctx, "ecs:task-definition", []tagging_types.TagFilter{
+		{Key: aws.String(serviceIdTagKey), Values: []string{pvnServiceId}},
+	})
 	if err != nil {
 		return nil, err
 	}
-	var outputParsed getResourcesOutput
-	if err := json.Unmarshal(output, &outputParsed); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal get-resources output")
-	}
-	var validArns []string
-	for _, resource := range outputParsed.ResourceTagMappingList {
-		validArns = append(validArns, resource.ResourceARN)
+	var revisions []taskDefinitionRevision
+	for _, resource := range resources {
+		arn := aws.ToString(resource.ResourceARN)
+		family, revision, err := parseTaskDefinitionArn(arn)
+		if err != nil {
+			return nil, err
+		}
+		rev := taskDefinitionRevision{
+			arn:      arn,
+			family:   family,
+			revision: revision,
+		}
+		for _, tag := range resource.Tags {
+			if aws.ToString(tag.Key) == serviceVersionTagKey {
+				rev.version = aws.ToString(tag.Value)
+			}
+		}
+		revisions = append(revisions, rev)
 	}
-	return validArns, nil
-}
-
-type registerTaskDefinitionOutput struct {
-	TaskDefinition struct {
-		TaskDefinitionArn string `json:"taskDefinitionArn"`
-	} `json:"taskDefinition"`
+	sort.Slice(revisions, func(i, j int) bool {
+		if revisions[i].family != revisions[j].family {
+			return revisions[i].family < revisions[j].family
+		}
+		return revisions[i].revision > revisions[j].revision
+	})
+	return revisions, nil
 }
 
-func registerTaskDefinitionIfNeeded(taskDefPath, pvnServiceId, pvnServiceVersion string, serviceOutput *describeServicesOutput) (string, error) {
-	validArns, err := getValidTaskDefinitionArns(pvnServiceId, pvnServiceVersion)
+// findTaskDefinition returns the active task definition already registered for the Prodvana Service version, or an empty
+// string if there is none. Deregistered task definitions are skipped, since services cannot be updated to them.
+func findTaskDefinition(ctx context.Context, client ecsClient, pvnServiceId, pvnServiceVersion string, serviceOutput *ecs.DescribeServicesOutput) (string, error) {
+	validArns, err := getValidTaskDefinitionArns(ctx, client, pvnServiceId, pvnServiceVersion)
 	if err != nil {
 		return "", err
 	}
+	var currentArn string
 	if len(serviceOutput.Services) > 0 {
-		service := serviceOutput.Services[0]
-		for _, arn := range validArns {
-			if arn == service.TaskDefinition {
-				// prioritize returning the service's existing task arn
-				log.Printf("Using existing task definition %s already present in service definition", arn)
-				return arn, nil
-			}
+		currentArn = aws.ToString(serviceOutput.Services[0].TaskDefinition)
+		// prioritize returning the service's existing task arn
+		sort.SliceStable(validArns, func(i, j int) bool {
+			return validArns[i] == currentArn && validArns[j] != currentArn
+		})
+	}
+	for _, arn := range validArns {
+		def, err := describeTaskDefinition(ctx, client, arn)
+		if err != nil {
+			return "", err
 		}
+		if def.TaskDefinition == nil || def.TaskDefinition.Status != types.TaskDefinitionStatusActive {
+			slog.Info("Skipping task definition that is not active", "task_definition", arn)
+			continue
+		}
+		if arn == currentArn {
+			slog.Info("Using existing task definition already present in service definition", "task_definition", arn)
+		} else {
+			slog.Info("Using existing task definition", "task_definition", arn)
+		}
+		return arn, nil
 	}
-	if len(validArns) > 0 {
-		log.Printf("Using existing task definition %s", validArns[0])
-		return validArns[0], nil
+	return "", nil
+}
+
+func registerTaskDefinitionIfNeeded(ctx context.Context, client ecsClient, taskDefPath, pvnServiceId, pvnServiceVersion string, serviceOutput *ecs.DescribeServicesOutput) (string, error) {
+	existingArn, err := findTaskDefinition(ctx, client, pvnServiceId, pvnServiceVersion, serviceOutput)
+	if err != nil || existingArn != "" {
+		return existingArn, err
 	}
 
 	taskDefPath, err = filepath.Abs(taskDefPath)
@@ -127,91 +162,38 @@ func registerTaskDefinitionIfNeeded(taskDefPath, pvnServiceId, pvnServiceVersion
 		return "", errors.Wrap(err, "failed to read task definition file")
 	}
 	// Printing task definition contents to help with debugging.
-	log.Printf("Task definition:\n%s", taskDefContents)
-	log.Printf("Registering new task definition for %s:%s", pvnServiceId, pvnServiceVersion)
-
-	registerCmd := exec.Command(
-		awsPath,
-		"ecs",
-		"register-task-definition",
-		"--cli-input-json",
-		fmt.Sprintf("file://%s", taskDefPath),
-	)
-	output, err := cmdutil.RunCmdOutput(registerCmd)
+	slog.Info("Task definition", "task_definition", string(cmdutil.RedactJSON(taskDefContents)))
+	slog.Info("Registering new task definition")
+
+	registerOutput, err := client.RegisterTaskDefinition(ctx, taskDefPath)
 	if err != nil {
 		return "", err
 	}
-	var registerOutput registerTaskDefinitionOutput
-	if err := json.Unmarshal(output, &registerOutput); err != nil {
-		return "", errors.Wrap(err, "failed to unmarshal register-task-definition output")
+	var taskArn string
+	if registerOutput.TaskDefinition != nil {
+		taskArn = aws.ToString(registerOutput.TaskDefinition.TaskDefinitionArn)
 	}
-	taskArn := registerOutput.TaskDefinition.TaskDefinitionArn
 	if taskArn == "" {
-		return "", errors.Errorf("got empty task definition arn. Register output: %s", string(output))
+		return "", errors.Errorf("got empty task definition arn from register-task-definition")
 	}
 	return taskArn, nil
 }
 
-type networkConfiguration struct {
-	AwsvpcConfiguration *struct {
-		Subnets        []string `json:"subnets"`
-		SecurityGroups []string `json:"securityGroups"`
-		AssignPublicIp string   `json:"assignPublicIp"`
-	} `json:"awsvpcConfiguration"`
-}
-
-type describeServicesOutput struct {
-	Services []struct {
-		Status         string `json:"status"`
-		TaskDefinition string `json:"taskDefinition"`
-		Deployments    []struct {
-			Status               string               `json:"status"`
-			TaskDefinition       string               `json:"taskDefinition"`
-			DesiredCount         int                  `json:"desiredCount"`
-			PendingCount         int                  `json:"pendingCount"`
-			RunningCount         int                  `json:"runningCount"`
-			FailedTasks          int                  `json:"failedTasks"`
-			Id                   string               `json:"id"`
-			CreatedAt            time.Time            `json:"createdAt"`
-			UpdatedAt            time.Time            `json:"updatedAt"`
-			NetworkConfiguration networkConfiguration `json:"networkConfiguration"`
-			RolloutState         string               `json:"rolloutState"`
-			RolloutStateReason   string               `json:"rolloutStateReason"`
-		} `json:"deployments"`
-	} `json:"services"`
-	Failures []struct {
-		Reason string `json:"reason"`
-	} `json:"failures"`
-}
-
-func describeService(clusterName, serviceName string) (*describeServicesOutput, error) {
-	describeCmd := exec.Command(
-		awsPath,
-		"ecs",
-		"describe-services",
-		"--cluster",
-		clusterName,
-		"--services",
-		serviceName,
-	)
-	output, err := cmdutil.RunCmdOutput(describeCmd)
+func describeService(ctx context.Context, client ecsClient, clusterName, serviceName string) (*ecs.DescribeServicesOutput, error) {
+	describeOutput, err := client.DescribeServices(ctx, clusterName, serviceName)
 	if err != nil {
 		return nil, err
 	}
-	var describeOutput describeServicesOutput
-	if err := json.Unmarshal(output, &describeOutput); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarsal describe-services output")
-	}
 	if len(describeOutput.Failures) > 0 {
-		if describeOutput.Failures[0].Reason != "MISSING" {
-			return nil, errors.Errorf("unexpected failure reason: %s", describeOutput.Failures[0].Reason)
+		if aws.ToString(describeOutput.Failures[0].Reason) != "MISSING" {
+			return nil, errors.Errorf("unexpected failure reason: %s", aws.ToString(describeOutput.Failures[0].Reason))
 		}
 	} else {
 		if len(describeOutput.Services) != 1 {
 			return nil, errors.Errorf("unexpected number of services: %d", len(describeOutput.Services))
 		}
 	}
-	return &describeOutput, nil
+	return describeOutput, nil
 }
 
 func patchTaskDefinition(taskDefPath, pvnServiceId, pvnServiceVersion string) (string, error) {
@@ -261,7 +243,12 @@ func patchTaskDefinition(taskDefPath, pvnServiceId, pvnServiceVersion string) (s
 	return tempFile.Name(), nil
 }
 
-func patchServiceSpec(serviceSpecPath string, ecsServiceName, ecsCluster, taskArn string, forUpdate bool) (string, error) {
+// serviceIdTags returns the tags identifying the ECS services of the Prodvana Service, which share its task definitions.
+func serviceIdTags(pvnServiceId string) []types.Tag {
+	return []types.Tag{{Key: aws.String(serviceIdTagKey), Value: aws.String(pvnServiceId)}}
+}
+
+func patchServiceSpec(serviceSpecPath string, ecsServiceName, ecsCluster, taskArn, pvnServiceId string, forUpdate bool) (string, error) {
 	serviceSpec, err := os.ReadFile(serviceSpecPath)
 	if err != nil {
 		return "", errors.Wrap(err, "failed to read service spec file")
@@ -301,6 +288,30 @@ func patchServiceSpec(serviceSpecPath string, ecsServiceName, ecsCluster, taskAr
 
 	if forUpdate {
 		delete(untypedDef, "launchType")
+	} else {
+		// tags cannot be passed on update, existing services are tagged by tagService instead
+		var tagsList []interface{}
+		tags, hasTags := untypedDef["tags"]
+		if hasTags {
+			var ok bool
+			tagsList, ok = tags.([]interface{})
+			if !ok {
+				return "", errors.Errorf("unexpected type for tags: %T", tags)
+			}
+		}
+		// a spec that already has the tag keeps it, ECS rejects duplicate keys
+		hasServiceIdTag := false
+		for _, tag := range tagsList {
+			if tagMap, ok := tag.(map[string]interface{}); ok && tagMap["key"] == serviceIdTagKey {
+				hasServiceIdTag = true
+			}
+		}
+		if !hasServiceIdTag {
+			untypedDef["tags"] = append(tagsList, map[string]string{
+				"key":   serviceIdTagKey,
+				"value": pvnServiceId,
+			})
+		}
 	}
 
 	updatedTaskDef, err := json.Marshal(untypedDef)
@@ -323,94 +334,122 @@ func patchServiceSpec(serviceSpecPath string, ecsServiceName, ecsCluster, taskAr
 	return tempFile.Name(), nil
 }
 
-func serviceMissing(output *describeServicesOutput) bool {
+// tagService tags an existing service with the Prodvana Service ID, for services created before they were tagged on
+// creation. The service was already updated at this point, so failures are only logged.
+func tagService(ctx context.Context, client ecsClient, svc types.Service, pvnServiceId string) {
+	serviceArn := aws.ToString(svc.ServiceArn)
+	if serviceArn == "" {
+		return
+	}
+	if err := client.TagResource(ctx, serviceArn, serviceIdTags(pvnServiceId)); err != nil {
+		slog.Warn("Failed to tag service with the Prodvana Service ID", "service", serviceArn, "error", err)
+	}
+}
+
+func serviceMissing(output *ecs.DescribeServicesOutput) bool {
 	if len(output.Failures) > 0 {
-		return output.Failures[0].Reason == "MISSING"
+		return aws.ToString(output.Failures[0].Reason) == "MISSING"
 	}
-	return output.Services[0].Status == "INACTIVE"
+	return aws.ToString(output.Services[0].Status) == "INACTIVE"
 }
 
+// runApply creates or updates the service and returns the exit code pvn-wrapper should exit with.
+func runApply(ctx context.Context, client ecsClient) (int, error) {
+	newTaskDefPath, err := patchTaskDefinition(commonFlags.taskDefinitionFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = os.Remove(newTaskDefPath) }()
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+	if err != nil {
+		return 0, err
+	}
+	taskArn, err := registerTaskDefinitionIfNeeded(ctx, client, newTaskDefPath, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion, serviceOutput)
+	if err != nil {
+		return 0, err
+	}
+	// if set, the service spec to create or update the service with, otherwise only the task definition is updated
+	var newServiceSpecPath string
+	if !commonFlags.updateTaskDefinitionOnly {
+		newServiceSpecPath, err = patchServiceSpec(
+			commonFlags.serviceSpecFile,
+			commonFlags.ecsServiceName,
+			commonFlags.ecsClusterName,
+			taskArn,
+			commonFlags.pvnServiceId,
+			!serviceMissing(serviceOutput),
+		)
+		if err != nil {
+			return 0, err
+		}
+		defer func() { _ = os.Remove(newServiceSpecPath) }()
+
+		serviceDefContents, err := os.ReadFile(newServiceSpecPath)
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to read service definition file")
+		}
+		// Printing service definition contents to help with debugging.
+		slog.Info("Service definition", "service_definition", string(cmdutil.RedactJSON(serviceDefContents)))
+	}
+	if serviceMissing(serviceOutput) {
+		if commonFlags.updateTaskDefinitionOnly {
+			return 0, errors.Errorf("cannot update task definition only when ECS service does not exist. ECS service: %s", commonFlags.ecsServiceName)
+		}
+		slog.Info("Creating service", "task_definition", taskArn)
+		err = client.CreateService(ctx, commonFlags.ecsClusterName, commonFlags.ecsServiceName, newServiceSpecPath)
+	} else {
+		slog.Info("Updating service", "task_definition", taskArn)
+		err = client.UpdateService(ctx, commonFlags.ecsClusterName, commonFlags.ecsServiceName, taskArn, newServiceSpecPath)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !serviceMissing(serviceOutput) {
+		tagService(ctx, client, serviceOutput.Services[0], commonFlags.pvnServiceId)
+	}
+	exitCode := 0
+	if applyFlags.wait {
+		exitCode, err = waitForRollout(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName, taskArn, applyFlags.waitOptions)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if applyFlags.gcKeep > 0 && exitCode == 0 {
+		// the service was updated, so only log failures to clean up
+		if err := runGc(ctx, client, applyFlags.gcKeep); err != nil {
+			slog.Warn("Failed to clean up old task definitions", "error", err)
+		}
+	}
+	return exitCode, nil
+}
+
+var applyFlags = struct {
+	wait   bool
+	gcKeep int
+	waitOptions
+}{}
+
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Create or update an ECS service",
-	Args:  cobra.NoArgs,
+	Long: `Create or update an ECS service.
+
+With --wait, pvn-wrapper waits for the new deployment to finish rolling out, logging its progress, and exits with
+2 if the rollout failed, or 3 if it did not finish within --wait-timeout.
+Rollouts are only marked as failed by ECS if the service has the deployment circuit breaker enabled.
+`,
+	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		newTaskDefPath, err := patchTaskDefinition(commonFlags.taskDefinitionFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
-		if err != nil {
-			return err
-		}
-		defer func() { _ = os.Remove(newTaskDefPath) }()
-		serviceOutput, err := describeService(commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+		client, err := newClient(cmd.Context())
 		if err != nil {
 			return err
 		}
-		taskArn, err := registerTaskDefinitionIfNeeded(newTaskDefPath, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion, serviceOutput)
+		exitCode, err := runApply(cmd.Context(), client)
 		if err != nil {
 			return err
 		}
-		commonArgs := []string{
-			"--propagate-tags=TASK_DEFINITION",
-			"--cluster", // must be set regardless of serviceSpec, in case updateTaskDefinitionOnly is set
-			commonFlags.ecsClusterName,
-		}
-		if commonFlags.updateTaskDefinitionOnly {
-			commonArgs = append(commonArgs,
-				"--task-definition",
-				taskArn,
-			)
-		} else {
-			newServiceSpecPath, err := patchServiceSpec(
-				commonFlags.serviceSpecFile,
-				commonFlags.ecsServiceName,
-				commonFlags.ecsClusterName,
-				taskArn,
-				!serviceMissing(serviceOutput),
-			)
-			if err != nil {
-				return err
-			}
-			defer func() { _ = os.Remove(newServiceSpecPath) }()
-			commonArgs = append(commonArgs,
-				"--cli-input-json",
-				fmt.Sprintf("file://%s", newServiceSpecPath),
-			)
-
-			serviceDefContents, err := os.ReadFile(newServiceSpecPath)
-			if err != nil {
-				return errors.Wrap(err, "failed to read service definition file")
-			}
-			// Printing service definition contents to help with debugging.
-			log.Printf("Service definition:\n%s", serviceDefContents)
-		}
-		if serviceMissing(serviceOutput) {
-			if commonFlags.updateTaskDefinitionOnly {
-				return errors.Errorf("cannot update task definition only when ECS service does not exist. ECS service: %s", commonFlags.ecsServiceName)
-			}
-			log.Printf("Creating service %s on cluster %s with task ARN %s\n", commonFlags.ecsServiceName, commonFlags.ecsClusterName, taskArn)
-			// create service
-			createCmd := exec.Command(awsPath, append([]string{
-				"ecs",
-				"create-service",
-				"--service-name",
-				commonFlags.ecsServiceName,
-			}, commonArgs...)...)
-			err := cmdutil.RunCmd(createCmd)
-			if err != nil {
-				return err
-			}
-		} else {
-			log.Printf("Updating service %s on cluster %s with task ARN %s\n", commonFlags.ecsServiceName, commonFlags.ecsClusterName, taskArn)
-			// update service
-			updateCmd := exec.Command(awsPath, append([]string{
-				"ecs",
-				"update-service",
-				"--service",
-				commonFlags.ecsServiceName, // must be set regardless of serviceSpec, in case updateTaskDefinitionOnly is set
-			}, commonArgs...)...)
-			err := cmdutil.RunCmd(updateCmd)
-			if err != nil {
-				return err
-			}
+		if exitCode != 0 {
+			cmdutil.Exit(exitCode)
 		}
 		return nil
 	},
@@ -420,4 +459,7 @@ func init() {
 	RootCmd.AddCommand(applyCmd)
 
 	registerCommonFlags(applyCmd)
+	applyCmd.Flags().BoolVar(&applyFlags.wait, "wait", false, "Wait for the deployment to complete or fail")
+	registerWaitFlags(applyCmd, &applyFlags.waitOptions, "the rollout")
+	applyCmd.Flags().IntVar(&applyFlags.gcKeep, "gc-keep", 0, "After a successful apply, clean up the task definitions of all but this many most recent versions, like aws-ecs gc --keep. 0 disables clean up.")
 }
@@ -0,0 +1,190 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCommonFlags points commonFlags at a minimal task definition and service spec for the duration of a test.
+func setupCommonFlags(t *testing.T, updateTaskDefinitionOnly bool) {
+	dir := t.TempDir()
+	taskDefPath := filepath.Join(dir, "task-definition.json")
+	require.NoError(t, os.WriteFile(taskDefPath, []byte(`{"family": "my-family"}`), 0o600))
+	serviceSpecPath := filepath.Join(dir, "service-spec.json")
+	require.NoError(t, os.WriteFile(serviceSpecPath, []byte(`{"desiredCount": 2}`), 0o600))
+	prevFlags, prevAwsPath := commonFlags, awsPath
+	t.Cleanup(func() {
+		commonFlags, awsPath = prevFlags, prevAwsPath
+	})
+	awsPath = "aws"
+	commonFlags.taskDefinitionFile = taskDefPath
+	commonFlags.serviceSpecFile = serviceSpecPath
+	commonFlags.ecsClusterName = "my-cluster"
+	commonFlags.ecsServiceName = "my-service"
+	commonFlags.pvnServiceId = "svc-id"
+	commonFlags.pvnServiceVersion = "svc-v1"
+	commonFlags.updateTaskDefinitionOnly = updateTaskDefinitionOnly
+}
+
+var (
+	describeServicesArgs = []string{"ecs", "describe-services", "--cluster", "my-cluster", "--services", "my-service"}
+	getResourcesArgs     = []string{
+		"resourcegroupstaggingapi", "get-resources",
+		"--resource-type-filters", "ecs:task-definition",
+		"--tag-filters", "Key=pvn:id,Values=svc-id", "Key=pvn:version,Values=svc-v1",
+	}
+	registerArgs = []string{"ecs", "register-task-definition", "--cli-input-json", "file://*"}
+)
+
+const (
+	serviceMissingOutput = `{"services": [], "failures": [{"reason": "MISSING"}]}`
+	serviceActiveOutput  = `{"services": [{"status": "ACTIVE", "taskDefinition": "arn:task/existing:1"}], "failures": []}`
+)
+
+func TestRunApply(t *testing.T) {
+	for _, tc := range []struct {
+		name                     string
+		updateTaskDefinitionOnly bool
+		calls                    []cmdutil.FakeCall
+		expectedErr              string
+	}{
+		{
+			name: "create service",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": []}`},
+				{Args: registerArgs, Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/new:1"}}`},
+				{Args: []string{
+					"ecs", "create-service", "--service-name", "my-service",
+					"--propagate-tags=TASK_DEFINITION", "--cluster", "my-cluster", "--cli-input-json", "file://*",
+				}},
+			},
+		},
+		{
+			name: "update service reusing its task definition",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceActiveOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/other:1"}, {"ResourceARN": "arn:task/existing:1"}]}`},
+				describeTaskDefinitionCall("arn:task/existing:1", "svc-id", "svc-v1"),
+				{Args: []string{
+					"ecs", "update-service", "--service", "my-service",
+					"--propagate-tags=TASK_DEFINITION", "--cluster", "my-cluster", "--cli-input-json", "file://*",
+				}},
+			},
+		},
+		{
+			name:                     "update task definition only",
+			updateTaskDefinitionOnly: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceActiveOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": []}`},
+				{Args: registerArgs, Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/new:2"}}`},
+				{Args: []string{
+					"ecs", "update-service", "--service", "my-service",
+					"--propagate-tags=TASK_DEFINITION", "--cluster", "my-cluster", "--task-definition", "arn:task/new:2",
+				}},
+			},
+		},
+		{
+			name: "update service registering a new revision of a deregistered task definition",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"serviceArn": "arn:aws:ecs:us-west-2:123456789012:service/my-cluster/my-service", "status": "ACTIVE", "taskDefinition": "arn:task/existing:1"}]}`},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/old:1"}, {"ResourceARN": "arn:task/old:2"}]}`},
+				{
+					Args:   []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/old:1"},
+					Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/old:1", "status": "INACTIVE"}}`,
+				},
+				{
+					Args:   []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/old:2"},
+					Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/old:2", "status": "DELETE_IN_PROGRESS"}}`,
+				},
+				{Args: registerArgs, Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/old:3"}}`},
+				{Args: []string{
+					"ecs", "update-service", "--service", "my-service",
+					"--propagate-tags=TASK_DEFINITION", "--cluster", "my-cluster", "--cli-input-json", "file://*",
+				}},
+				{Args: []string{
+					"ecs", "tag-resource", "--resource-arn", "arn:aws:ecs:us-west-2:123456789012:service/my-cluster/my-service",
+					"--tags", "key=pvn:id,value=svc-id",
+				}},
+			},
+		},
+		{
+			name:                     "update task definition only without a service",
+			updateTaskDefinitionOnly: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/new:1"}]}`},
+				describeTaskDefinitionCall("arn:task/new:1", "svc-id", "svc-v1"),
+			},
+			expectedErr: "cannot update task definition only when ECS service does not exist",
+		},
+		{
+			name: "register fails",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": []}`},
+				{Args: registerArgs, Stderr: "AccessDeniedException", ExitCode: 254},
+			},
+			expectedErr: "AccessDeniedException",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, tc.updateTaskDefinitionOnly)
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			_, err := runApply(context.Background(), newCliClient(runner))
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
+
+func TestPatchServiceSpecTags(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		spec     string
+		expected []any
+	}{
+		{
+			name:     "no tags",
+			spec:     `{"desiredCount": 2}`,
+			expected: []any{map[string]any{"key": "pvn:id", "value": "svc-id"}},
+		},
+		{
+			name: "other tags",
+			spec: `{"tags": [{"key": "team", "value": "infra"}]}`,
+			expected: []any{
+				map[string]any{"key": "team", "value": "infra"},
+				map[string]any{"key": "pvn:id", "value": "svc-id"},
+			},
+		},
+		{
+			name:     "already tagged",
+			spec:     `{"tags": [{"key": "pvn:id", "value": "svc-id"}]}`,
+			expected: []any{map[string]any{"key": "pvn:id", "value": "svc-id"}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			specPath := filepath.Join(t.TempDir(), "service-spec.json")
+			require.NoError(t, os.WriteFile(specPath, []byte(tc.spec), 0o600))
+			patchedPath, err := patchServiceSpec(specPath, "my-service", "my-cluster", "arn:task/a:1", "svc-id", false)
+			require.NoError(t, err)
+			defer func() { _ = os.Remove(patchedPath) }()
+			content, err := os.ReadFile(patchedPath)
+			require.NoError(t, err)
+			var patched map[string]any
+			require.NoError(t, json.Unmarshal(content, &patched))
+			require.Equal(t, tc.expected, patched["tags"])
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package awsecs
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	tagging_types "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+)
+
+const (
+	awsClientSdk = "sdk"
+	awsClientCli = "cli"
+)
+
+// ecsClient is the subset of the ECS and resource tagging APIs that aws-ecs commands use.
+// It is implemented with the AWS SDK and, as a fallback, with the aws CLI. Both return SDK types.
+type ecsClient interface {
+	// DescribeServices describes up to 10 services of a cluster.
+	DescribeServices(ctx context.Context, cluster string, services ...string) (*ecs.DescribeServicesOutput, error)
+	// ListClusters returns the ARNs of every cluster in the region, across all pages.
+	ListClusters(ctx context.Context) ([]string, error)
+	// ListServices returns the ARNs of every service of a cluster, across all pages.
+	ListServices(ctx context.Context, cluster string) ([]string, error)
+	// ListTasks returns the ARNs of up to maxResults tasks started by startedBy, e.g. a service deployment ID,
+	// with the given desired status. Only the first page is read.
+	ListTasks(ctx context.Context, cluster, startedBy string, desiredStatus types.DesiredStatus, maxResults int32) ([]string, error)
+	// DescribeTasks describes up to 100 tasks.
+	DescribeTasks(ctx context.Context, cluster string, tasks []string) (*ecs.DescribeTasksOutput, error)
+	// DescribeTaskDefinition describes a task definition, including its tags.
+	DescribeTaskDefinition(ctx context.Context, taskDefinition string) (*ecs.DescribeTaskDefinitionOutput, error)
+	// GetResources returns every resource of resourceType matching all tag filters, across all pages.
+	GetResources(ctx context.Context, resourceType string, tagFilters []tagging_types.TagFilter) ([]tagging_types.ResourceTagMapping, error)
+	// RegisterTaskDefinition registers the task definition in the file at taskDefPath,
+	// in the format accepted by aws ecs register-task-definition --cli-input-json.
+	RegisterTaskDefinition(ctx context.Context, taskDefPath string) (*ecs.RegisterTaskDefinitionOutput, error)
+	// CreateService creates a service from the spec in the file at specPath,
+	// in the format accepted by aws ecs create-service --cli-input-json.
+	CreateService(ctx context.Context, cluster, service, specPath string) error
+	// UpdateService updates a service from the spec in the file at specPath, or if specPath is empty,
+	// only changes its task definition to taskDefinition.
+	UpdateService(ctx context.Context, cluster, service, taskDefinition, specPath string) error
+	// RunTask runs tasks from the spec in the file at specPath,
+	// in the format accepted by aws ecs run-task --cli-input-json.
+	RunTask(ctx context.Context, specPath string) (*ecs.RunTaskOutput, error)
+	// StopTask stops a running task, recording reason as its stopped reason.
+	StopTask(ctx context.Context, cluster, task, reason string) error
+	// ScaleService only changes the service's desired count.
+	ScaleService(ctx context.Context, cluster, service string, desiredCount int32) error
+	// DeleteService deletes a service. Without force, its desired count must be zero.
+	DeleteService(ctx context.Context, cluster, service string, force bool) error
+	DeregisterTaskDefinition(ctx context.Context, taskDefinition string) error
+	// TagResource adds tags to an ECS resource, such as a service.
+	TagResource(ctx context.Context, resourceArn string, tags []types.Tag) error
+	// DeleteTaskDefinitions deletes up to 10 INACTIVE task definitions.
+	DeleteTaskDefinitions(ctx context.Context, taskDefinitions []string) (*ecs.DeleteTaskDefinitionsOutput, error)
+}
+
+// newClient returns the ecsClient selected by --aws-client.
+// The aws CLI is always used when recording or replaying calls, since only commands can be recorded.
+func newClient(ctx context.Context) (ecsClient, error) {
+	switch awsClient {
+	case awsClientSdk:
+		if os.Getenv(cmdutil.RecordEnvVar) != "" || os.Getenv(cmdutil.ReplayEnvVar) != "" {
+			slog.Info("Using aws CLI to record or replay calls")
+			return newCliClient(newRunner()), nil
+		}
+		return loadSdkClient(ctx)
+	case awsClientCli:
+		return newCliClient(newRunner()), nil
+	default:
+		return nil, errors.Errorf("unknown --aws-client %q, must be one of %s, %s", awsClient, awsClientSdk, awsClientCli)
+	}
+}
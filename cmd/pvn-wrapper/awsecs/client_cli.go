@@ -0,0 +1,216 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	tagging_types "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+)
+
+// cliClient implements ecsClient by running the aws CLI.
+// The CLI prints the same fields as the API in camelCase, which unmarshal into the SDK types as is.
+type cliClient struct {
+	runner cmdutil.Runner
+}
+
+func newCliClient(runner cmdutil.Runner) *cliClient {
+	return &cliClient{runner: runner}
+}
+
+func (c *cliClient) output(ctx context.Context, out interface{}, args ...string) error {
+	output, err := c.runner.Output(ctx, exec.Command(awsPath, args...))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %s output", strings.Join(args[:2], " "))
+	}
+	return nil
+}
+
+func (c *cliClient) DescribeServices(ctx context.Context, cluster string, services ...string) (*ecs.DescribeServicesOutput, error) {
+	var output ecs.DescribeServicesOutput
+	if err := c.output(ctx, &output, append([]string{"ecs", "describe-services", "--cluster", cluster, "--services"}, services...)...); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (c *cliClient) ListClusters(ctx context.Context) ([]string, error) {
+	// the CLI follows pagination tokens itself
+	var output ecs.ListClustersOutput
+	if err := c.output(ctx, &output, "ecs", "list-clusters"); err != nil {
+		return nil, err
+	}
+	return output.ClusterArns, nil
+}
+
+func (c *cliClient) ListServices(ctx context.Context, cluster string) ([]string, error) {
+	// the CLI follows pagination tokens itself
+	var output ecs.ListServicesOutput
+	if err := c.output(ctx, &output, "ecs", "list-services", "--cluster", cluster); err != nil {
+		return nil, err
+	}
+	return output.ServiceArns, nil
+}
+
+func (c *cliClient) ListTasks(ctx context.Context, cluster, startedBy string, desiredStatus types.DesiredStatus, maxResults int32) ([]string, error) {
+	// the CLI follows pagination tokens itself, until it has --max-items
+	var output ecs.ListTasksOutput
+	if err := c.output(
+		ctx,
+		&output,
+		"ecs",
+		"list-tasks",
+		"--cluster",
+		cluster,
+		"--started-by",
+		startedBy,
+		"--desired-status",
+		string(desiredStatus),
+		"--max-items",
+		strconv.Itoa(int(maxResults)),
+	); err != nil {
+		return nil, err
+	}
+	return output.TaskArns, nil
+}
+
+func (c *cliClient) DescribeTasks(ctx context.Context, cluster string, tasks []string) (*ecs.DescribeTasksOutput, error) {
+	var output ecs.DescribeTasksOutput
+	if err := c.output(ctx, &output, append([]string{"ecs", "describe-tasks", "--cluster", cluster, "--tasks"}, tasks...)...); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (c *cliClient) DescribeTaskDefinition(ctx context.Context, taskDefinition string) (*ecs.DescribeTaskDefinitionOutput, error) {
+	var output ecs.DescribeTaskDefinitionOutput
+	if err := c.output(ctx, &output, "ecs", "describe-task-definition", "--include=TAGS", "--task-definition", taskDefinition); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (c *cliClient) GetResources(ctx context.Context, resourceType string, tagFilters []tagging_types.TagFilter) ([]tagging_types.ResourceTagMapping, error) {
+	args := []string{
+		"resourcegroupstaggingapi",
+		"get-resources",
+		"--resource-type-filters", resourceType,
+		"--tag-filters",
+	}
+	for _, filter := range tagFilters {
+		args = append(args, fmt.Sprintf("Key=%s,Values=%s", aws.ToString(filter.Key), strings.Join(filter.Values, ",")))
+	}
+	// the CLI follows pagination tokens itself
+	var output resourcegroupstaggingapi.GetResourcesOutput
+	if err := c.output(ctx, &output, args...); err != nil {
+		return nil, err
+	}
+	return output.ResourceTagMappingList, nil
+}
+
+func (c *cliClient) RegisterTaskDefinition(ctx context.Context, taskDefPath string) (*ecs.RegisterTaskDefinitionOutput, error) {
+	var output ecs.RegisterTaskDefinitionOutput
+	if err := c.output(ctx, &output, "ecs", "register-task-definition", "--cli-input-json", fmt.Sprintf("file://%s", taskDefPath)); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (c *cliClient) CreateService(ctx context.Context, cluster, service, specPath string) error {
+	return c.runner.Run(ctx, exec.Command(
+		awsPath,
+		"ecs",
+		"create-service",
+		"--service-name",
+		service,
+		"--propagate-tags=TASK_DEFINITION",
+		"--cluster",
+		cluster,
+		"--cli-input-json",
+		fmt.Sprintf("file://%s", specPath),
+	))
+}
+
+func (c *cliClient) UpdateService(ctx context.Context, cluster, service, taskDefinition, specPath string) error {
+	args := []string{
+		"ecs",
+		"update-service",
+		"--service",
+		service,
+		"--propagate-tags=TASK_DEFINITION",
+		"--cluster",
+		cluster,
+	}
+	if specPath != "" {
+		args = append(args, "--cli-input-json", fmt.Sprintf("file://%s", specPath))
+	} else {
+		args = append(args, "--task-definition", taskDefinition)
+	}
+	return c.runner.Run(ctx, exec.Command(awsPath, args...))
+}
+
+func (c *cliClient) RunTask(ctx context.Context, specPath string) (*ecs.RunTaskOutput, error) {
+	var output ecs.RunTaskOutput
+	if err := c.output(ctx, &output, "ecs", "run-task", "--cli-input-json", fmt.Sprintf("file://%s", specPath)); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (c *cliClient) StopTask(ctx context.Context, cluster, task, reason string) error {
+	return c.runner.Run(ctx, exec.Command(awsPath, "ecs", "stop-task", "--cluster", cluster, "--task", task, "--reason", reason))
+}
+
+func (c *cliClient) ScaleService(ctx context.Context, cluster, service string, desiredCount int32) error {
+	return c.runner.Run(ctx, exec.Command(
+		awsPath,
+		"ecs",
+		"update-service",
+		"--service",
+		service,
+		"--cluster",
+		cluster,
+		"--desired-count",
+		strconv.Itoa(int(desiredCount)),
+	))
+}
+
+func (c *cliClient) DeleteService(ctx context.Context, cluster, service string, force bool) error {
+	args := []string{"ecs", "delete-service", "--service", service, "--cluster", cluster}
+	if force {
+		args = append(args, "--force")
+	}
+	return c.runner.Run(ctx, exec.Command(awsPath, args...))
+}
+
+func (c *cliClient) DeregisterTaskDefinition(ctx context.Context, taskDefinition string) error {
+	return c.runner.Run(ctx, exec.Command(awsPath, "ecs", "deregister-task-definition", "--task-definition", taskDefinition))
+}
+
+func (c *cliClient) TagResource(ctx context.Context, resourceArn string, tags []types.Tag) error {
+	args := []string{"ecs", "tag-resource", "--resource-arn", resourceArn, "--tags"}
+	for _, tag := range tags {
+		args = append(args, fmt.Sprintf("key=%s,value=%s", aws.ToString(tag.Key), aws.ToString(tag.Value)))
+	}
+	return c.runner.Run(ctx, exec.Command(awsPath, args...))
+}
+
+func (c *cliClient) DeleteTaskDefinitions(ctx context.Context, taskDefinitions []string) (*ecs.DeleteTaskDefinitionsOutput, error) {
+	var output ecs.DeleteTaskDefinitionsOutput
+	if err := c.output(ctx, &output, append([]string{"ecs", "delete-task-definitions", "--task-definitions"}, taskDefinitions...)...); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
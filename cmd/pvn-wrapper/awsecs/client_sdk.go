@@ -0,0 +1,287 @@
+package awsecs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi"
+	tagging_types "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/prodvana/pvn-wrapper/tracing"
+)
+
+// sdkClient implements ecsClient with the AWS SDK.
+type sdkClient struct {
+	ecs     *ecs.Client
+	tagging *resourcegroupstaggingapi.Client
+}
+
+func newSdkClient(cfg aws.Config) *sdkClient {
+	return &sdkClient{
+		ecs:     ecs.NewFromConfig(cfg),
+		tagging: resourcegroupstaggingapi.NewFromConfig(cfg),
+	}
+}
+
+// loadSdkClient configures the SDK the same way as the aws CLI, from the environment and shared config files.
+func loadSdkClient(ctx context.Context) (*sdkClient, error) {
+	opts := []func(*config.LoadOptions) error{
+		// the SDK retries throttling, server, and connection errors itself
+		config.WithRetryer(func() aws.Retryer {
+			return retry.NewStandard(func(o *retry.StandardOptions) {
+				o.MaxAttempts = cmdutil.DefaultRetryPolicy.MaxAttempts
+				o.MaxBackoff = cmdutil.DefaultRetryPolicy.MaxBackoff
+			})
+		}),
+	}
+	// the SDK does not read AWS_DEFAULT_REGION, which is what the CLI and Prodvana set
+	if os.Getenv("AWS_REGION") == "" && os.Getenv("AWS_DEFAULT_REGION") != "" {
+		opts = append(opts, config.WithRegion(os.Getenv("AWS_DEFAULT_REGION")))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS config")
+	}
+	return newSdkClient(cfg), nil
+}
+
+// call runs an API call with the same timeout, tracing, and logging as a command run by a cmdutil.Runner.
+func call[T any](ctx context.Context, operation string, f func(context.Context) (T, error)) (_ T, err error) {
+	ctx, cancel := cmdutil.WithTimeout(ctx, cmdutil.CommandTimeout)
+	defer cancel()
+	ctx, span := tracing.Tracer().Start(ctx, operation)
+	defer func() { tracing.EndSpan(span, err) }()
+	slog.Info("Calling AWS API", "operation", operation)
+	out, err := f(ctx)
+	if err != nil {
+		return out, errors.Wrapf(err, "%s failed", operation)
+	}
+	return out, nil
+}
+
+// readInput reads a --cli-input-json file into an SDK input struct. Like the CLI, unknown fields are rejected.
+func readInput(path string, input interface{}) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(input); err != nil {
+		return errors.Wrapf(err, "failed to unmarshal %s", path)
+	}
+	return nil
+}
+
+func (c *sdkClient) DescribeServices(ctx context.Context, cluster string, services ...string) (*ecs.DescribeServicesOutput, error) {
+	return call(ctx, "ecs.DescribeServices", func(ctx context.Context) (*ecs.DescribeServicesOutput, error) {
+		return c.ecs.DescribeServices(ctx, &ecs.DescribeServicesInput{
+			Cluster:  aws.String(cluster),
+			Services: services,
+		})
+	})
+}
+
+func (c *sdkClient) ListClusters(ctx context.Context) ([]string, error) {
+	return call(ctx, "ecs.ListClusters", func(ctx context.Context) ([]string, error) {
+		paginator := ecs.NewListClustersPaginator(c.ecs, &ecs.ListClustersInput{})
+		var clusters []string
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			clusters = append(clusters, page.ClusterArns...)
+		}
+		return clusters, nil
+	})
+}
+
+func (c *sdkClient) ListServices(ctx context.Context, cluster string) ([]string, error) {
+	return call(ctx, "ecs.ListServices", func(ctx context.Context) ([]string, error) {
+		paginator := ecs.NewListServicesPaginator(c.ecs, &ecs.ListServicesInput{
+			Cluster: aws.String(cluster),
+		})
+		var services []string
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			services = append(services, page.ServiceArns...)
+		}
+		return services, nil
+	})
+}
+
+func (c *sdkClient) ListTasks(ctx context.Context, cluster, startedBy string, desiredStatus types.DesiredStatus, maxResults int32) ([]string, error) {
+	output, err := call(ctx, "ecs.ListTasks", func(ctx context.Context) (*ecs.ListTasksOutput, error) {
+		return c.ecs.ListTasks(ctx, &ecs.ListTasksInput{
+			Cluster:       aws.String(cluster),
+			StartedBy:     aws.String(startedBy),
+			DesiredStatus: desiredStatus,
+			MaxResults:    aws.Int32(maxResults),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.TaskArns, nil
+}
+
+func (c *sdkClient) DescribeTasks(ctx context.Context, cluster string, tasks []string) (*ecs.DescribeTasksOutput, error) {
+	return call(ctx, "ecs.DescribeTasks", func(ctx context.Context) (*ecs.DescribeTasksOutput, error) {
+		return c.ecs.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   tasks,
+		})
+	})
+}
+
+func (c *sdkClient) DescribeTaskDefinition(ctx context.Context, taskDefinition string) (*ecs.DescribeTaskDefinitionOutput, error) {
+	return call(ctx, "ecs.DescribeTaskDefinition", func(ctx context.Context) (*ecs.DescribeTaskDefinitionOutput, error) {
+		return c.ecs.DescribeTaskDefinition(ctx, &ecs.DescribeTaskDefinitionInput{
+			TaskDefinition: aws.String(taskDefinition),
+			Include:        []types.TaskDefinitionField{types.TaskDefinitionFieldTags},
+		})
+	})
+}
+
+func (c *sdkClient) GetResources(ctx context.Context, resourceType string, tagFilters []tagging_types.TagFilter) ([]tagging_types.ResourceTagMapping, error) {
+	return call(ctx, "tagging.GetResources", func(ctx context.Context) ([]tagging_types.ResourceTagMapping, error) {
+		paginator := resourcegroupstaggingapi.NewGetResourcesPaginator(c.tagging, &resourcegroupstaggingapi.GetResourcesInput{
+			ResourceTypeFilters: []string{resourceType},
+			TagFilters:          tagFilters,
+		})
+		var resources []tagging_types.ResourceTagMapping
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, err
+			}
+			resources = append(resources, page.ResourceTagMappingList...)
+		}
+		return resources, nil
+	})
+}
+
+func (c *sdkClient) RegisterTaskDefinition(ctx context.Context, taskDefPath string) (*ecs.RegisterTaskDefinitionOutput, error) {
+	var input ecs.RegisterTaskDefinitionInput
+	if err := readInput(taskDefPath, &input); err != nil {
+		return nil, err
+	}
+	return call(ctx, "ecs.RegisterTaskDefinition", func(ctx context.Context) (*ecs.RegisterTaskDefinitionOutput, error) {
+		return c.ecs.RegisterTaskDefinition(ctx, &input)
+	})
+}
+
+func (c *sdkClient) CreateService(ctx context.Context, cluster, service, specPath string) error {
+	var input ecs.CreateServiceInput
+	if err := readInput(specPath, &input); err != nil {
+		return err
+	}
+	input.ServiceName = aws.String(service)
+	input.Cluster = aws.String(cluster)
+	input.PropagateTags = types.PropagateTagsTaskDefinition
+	_, err := call(ctx, "ecs.CreateService", func(ctx context.Context) (*ecs.CreateServiceOutput, error) {
+		return c.ecs.CreateService(ctx, &input)
+	})
+	return err
+}
+
+func (c *sdkClient) UpdateService(ctx context.Context, cluster, service, taskDefinition, specPath string) error {
+	var input ecs.UpdateServiceInput
+	if specPath != "" {
+		if err := readInput(specPath, &input); err != nil {
+			return err
+		}
+	} else {
+		input.TaskDefinition = aws.String(taskDefinition)
+	}
+	input.Service = aws.String(service)
+	input.Cluster = aws.String(cluster)
+	input.PropagateTags = types.PropagateTagsTaskDefinition
+	_, err := call(ctx, "ecs.UpdateService", func(ctx context.Context) (*ecs.UpdateServiceOutput, error) {
+		return c.ecs.UpdateService(ctx, &input)
+	})
+	return err
+}
+
+func (c *sdkClient) RunTask(ctx context.Context, specPath string) (*ecs.RunTaskOutput, error) {
+	var input ecs.RunTaskInput
+	if err := readInput(specPath, &input); err != nil {
+		return nil, err
+	}
+	return call(ctx, "ecs.RunTask", func(ctx context.Context) (*ecs.RunTaskOutput, error) {
+		return c.ecs.RunTask(ctx, &input)
+	})
+}
+
+func (c *sdkClient) StopTask(ctx context.Context, cluster, task, reason string) error {
+	_, err := call(ctx, "ecs.StopTask", func(ctx context.Context) (*ecs.StopTaskOutput, error) {
+		return c.ecs.StopTask(ctx, &ecs.StopTaskInput{
+			Cluster: aws.String(cluster),
+			Task:    aws.String(task),
+			Reason:  aws.String(reason),
+		})
+	})
+	return err
+}
+
+func (c *sdkClient) ScaleService(ctx context.Context, cluster, service string, desiredCount int32) error {
+	_, err := call(ctx, "ecs.UpdateService", func(ctx context.Context) (*ecs.UpdateServiceOutput, error) {
+		return c.ecs.UpdateService(ctx, &ecs.UpdateServiceInput{
+			Service:      aws.String(service),
+			Cluster:      aws.String(cluster),
+			DesiredCount: aws.Int32(desiredCount),
+		})
+	})
+	return err
+}
+
+func (c *sdkClient) DeleteService(ctx context.Context, cluster, service string, force bool) error {
+	_, err := call(ctx, "ecs.DeleteService", func(ctx context.Context) (*ecs.DeleteServiceOutput, error) {
+		return c.ecs.DeleteService(ctx, &ecs.DeleteServiceInput{
+			Service: aws.String(service),
+			Cluster: aws.String(cluster),
+			Force:   aws.Bool(force),
+		})
+	})
+	return err
+}
+
+func (c *sdkClient) DeregisterTaskDefinition(ctx context.Context, taskDefinition string) error {
+	_, err := call(ctx, "ecs.DeregisterTaskDefinition", func(ctx context.Context) (*ecs.DeregisterTaskDefinitionOutput, error) {
+		return c.ecs.DeregisterTaskDefinition(ctx, &ecs.DeregisterTaskDefinitionInput{
+			TaskDefinition: aws.String(taskDefinition),
+		})
+	})
+	return err
+}
+
+func (c *sdkClient) TagResource(ctx context.Context, resourceArn string, tags []types.Tag) error {
+	_, err := call(ctx, "ecs.TagResource", func(ctx context.Context) (*ecs.TagResourceOutput, error) {
+		return c.ecs.TagResource(ctx, &ecs.TagResourceInput{
+			ResourceArn: aws.String(resourceArn),
+			Tags:        tags,
+		})
+	})
+	return err
+}
+
+func (c *sdkClient) DeleteTaskDefinitions(ctx context.Context, taskDefinitions []string) (*ecs.DeleteTaskDefinitionsOutput, error) {
+	return call(ctx, "ecs.DeleteTaskDefinitions", func(ctx context.Context) (*ecs.DeleteTaskDefinitionsOutput, error) {
+		return c.ecs.DeleteTaskDefinitions(ctx, &ecs.DeleteTaskDefinitionsInput{
+			TaskDefinitions: taskDefinitions,
+		})
+	})
+}
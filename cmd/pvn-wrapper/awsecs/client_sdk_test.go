@@ -0,0 +1,187 @@
+package awsecs
+
+import (
+	"context"
+	go_errors "errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	tagging_types "github.com/aws/aws-sdk-go-v2/service/resourcegroupstaggingapi/types"
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/stretchr/testify/require"
+)
+
+func fetchedVersions(t *testing.T, output *extensions_pb.FetchOutput) map[string]int32 {
+	require.Len(t, output.Objects, 1)
+	versions := map[string]int32{}
+	for _, version := range output.Objects[0].Versions {
+		versions[version.Version] = version.Replicas
+	}
+	return versions
+}
+
+func TestSdkClientApplyAndFetch(t *testing.T) {
+	ctx := context.Background()
+	setupCommonFlags(t, false)
+	t.Setenv("AWS_DEFAULT_REGION", "us-west-2")
+	// unlike the CLI fakes, the SDK validates required fields
+	require.NoError(t, os.WriteFile(commonFlags.taskDefinitionFile, []byte(`{
+		"family": "my-family",
+		"containerDefinitions": [{"name": "app", "image": "app:latest", "environment": [{"name": "LOG_LEVEL", "value": "debug"}]}]
+	}`), 0o600))
+	standIn := newEcsStandIn(t)
+	// make lookups by tag span several pages
+	standIn.pageSize = 1
+	client := standIn.client()
+
+	_, err := runApply(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DescribeServices", "GetResources", "RegisterTaskDefinition", "CreateService"}, standIn.Calls())
+	output, err := runFetch(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, extensions_pb.ExternalObject_PENDING, output.Objects[0].Status)
+	require.Equal(t, map[string]int32{"svc-v1": 2}, fetchedVersions(t, output))
+
+	standIn.completeRollouts()
+	output, err = runFetch(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, extensions_pb.ExternalObject_SUCCEEDED, output.Objects[0].Status)
+
+	commonFlags.pvnServiceVersion = "svc-v2"
+	_, err = runApply(ctx, client)
+	require.NoError(t, err)
+	output, err = runFetch(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, extensions_pb.ExternalObject_PENDING, output.Objects[0].Status)
+	require.Equal(t, map[string]int32{"svc-v1": 2, "svc-v2": 2}, fetchedVersions(t, output))
+
+	// going back to an already registered version reuses its task definition
+	standIn.completeRollouts()
+	commonFlags.pvnServiceVersion = "svc-v1"
+	before := len(standIn.Calls())
+	_, err = runApply(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DescribeServices", "GetResources", "DescribeTaskDefinition", "UpdateService", "TagResource"}, standIn.Calls()[before:])
+	require.Equal(t, []any{map[string]any{"key": "pvn:id", "value": "svc-id"}}, standIn.services["my-service"]["tags"])
+	output, err = runFetch(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int32{"svc-v1": 2, "svc-v2": 2}, fetchedVersions(t, output))
+
+	before = len(standIn.Calls())
+	resources, err := client.GetResources(ctx, "ecs:task-definition", []tagging_types.TagFilter{
+		{Key: aws.String(serviceIdTagKey), Values: []string{"svc-id"}},
+	})
+	require.NoError(t, err)
+	require.Len(t, resources, 2)
+	require.Equal(t, []string{"GetResources", "GetResources"}, standIn.Calls()[before:])
+}
+
+func TestSdkClientDelete(t *testing.T) {
+	ctx := context.Background()
+	setupCommonFlags(t, false)
+	require.NoError(t, os.WriteFile(commonFlags.taskDefinitionFile, []byte(`{
+		"family": "my-family",
+		"containerDefinitions": [{"name": "app", "image": "app:latest"}]
+	}`), 0o600))
+	prevFlags := deleteFlags
+	t.Cleanup(func() { deleteFlags = prevFlags })
+	deleteFlags.deregisterTaskDefinitions = true
+	deleteFlags.waitOptions = waitOptions{pollInterval: time.Millisecond}
+	standIn := newEcsStandIn(t)
+	client := standIn.client()
+	for _, version := range []string{"svc-v1", "svc-v2"} {
+		commonFlags.pvnServiceVersion = version
+		_, err := runApply(ctx, client)
+		require.NoError(t, err)
+	}
+
+	before := len(standIn.Calls())
+	exitCode, err := runDelete(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, 0, exitCode)
+	require.Equal(t, []string{
+		"DescribeServices", "UpdateService", "DeleteService", "DescribeServices", "ListClusters", "ListServices", "GetResources",
+		"DescribeTaskDefinition", "DeregisterTaskDefinition", "DescribeTaskDefinition", "DeregisterTaskDefinition",
+	}, standIn.Calls()[before:])
+
+	// deleting again only finds the task definitions already deregistered
+	before = len(standIn.Calls())
+	_, err = runDelete(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, []string{"DescribeServices", "ListClusters", "ListServices", "GetResources", "DescribeTaskDefinition", "DescribeTaskDefinition"}, standIn.Calls()[before:])
+}
+
+func TestSdkClientGc(t *testing.T) {
+	ctx := context.Background()
+	setupCommonFlags(t, false)
+	require.NoError(t, os.WriteFile(commonFlags.taskDefinitionFile, []byte(`{
+		"family": "my-family",
+		"containerDefinitions": [{"name": "app", "image": "app:latest"}]
+	}`), 0o600))
+	standIn := newEcsStandIn(t)
+	client := standIn.client()
+	for _, version := range []string{"svc-v1", "svc-v2", "svc-v3"} {
+		commonFlags.pvnServiceVersion = version
+		_, err := runApply(ctx, client)
+		require.NoError(t, err)
+		standIn.completeRollouts()
+	}
+	// another release channel of the Prodvana Service is still on an older version, sharing its task definition
+	commonFlags.ecsServiceName = "my-service-canary"
+	commonFlags.pvnServiceVersion = "svc-v2"
+	_, err := runApply(ctx, client)
+	require.NoError(t, err)
+	standIn.completeRollouts()
+	commonFlags.ecsServiceName = "my-service"
+
+	before := len(standIn.Calls())
+	require.NoError(t, runGc(ctx, client, 1))
+	require.Equal(t, []string{
+		"ListClusters", "ListServices", "DescribeServices", "GetResources",
+		"DescribeTaskDefinition", "DeregisterTaskDefinition", "DeleteTaskDefinitions",
+	}, standIn.Calls()[before:])
+	statuses := func() map[string]any {
+		statuses := map[string]any{}
+		for _, td := range standIn.taskDefs {
+			statuses[td["taskDefinitionArn"].(string)] = td["status"]
+		}
+		return statuses
+	}
+	require.Equal(t, map[string]any{
+		standInAccount + ":task-definition/my-family:1": "DELETE_IN_PROGRESS",
+		standInAccount + ":task-definition/my-family:2": "ACTIVE",
+		standInAccount + ":task-definition/my-family:3": "ACTIVE",
+	}, statuses())
+
+	// task definitions being deleted are skipped
+	before = len(standIn.Calls())
+	require.NoError(t, runGc(ctx, client, 1))
+	require.Equal(t, []string{"ListClusters", "ListServices", "DescribeServices", "GetResources", "DescribeTaskDefinition"}, standIn.Calls()[before:])
+
+	// rolling back to a cleaned up version registers its task definition again
+	commonFlags.pvnServiceVersion = "svc-v1"
+	_, err = runApply(ctx, client)
+	require.NoError(t, err)
+	require.Equal(t, "ACTIVE", statuses()[standInAccount+":task-definition/my-family:4"])
+	require.Equal(t, standInAccount+":task-definition/my-family:4", standIn.services["my-service"]["taskDefinition"])
+}
+
+func TestSdkClientTypedErrors(t *testing.T) {
+	standIn := newEcsStandIn(t)
+	_, err := standIn.client().DescribeTaskDefinition(context.Background(), "arn:task/missing:1")
+	var clientErr *types.ClientException
+	require.True(t, go_errors.As(err, &clientErr), "got %v", err)
+	require.Contains(t, err.Error(), "ecs.DescribeTaskDefinition failed")
+}
+
+func TestNewClientDefaultsToCli(t *testing.T) {
+	flag := fetchCmd.InheritedFlags().Lookup("aws-client")
+	require.NotNil(t, flag, "--aws-client must be inherited by subcommands")
+	require.Equal(t, awsClientCli, flag.DefValue)
+	client, err := newClient(context.Background())
+	require.NoError(t, err)
+	require.IsType(t, &cliClient{}, client)
+}
@@ -15,19 +15,38 @@ var commonFlags = struct {
 	updateTaskDefinitionOnly bool
 }{}
 
-func registerCommonFlags(cmd *cobra.Command) {
-	cmd.Flags().StringVar(&commonFlags.taskDefinitionFile, "task-definition-file", "", "Path to ECS task definition file")
-	cmdutil.Must(cmd.MarkFlagRequired("task-definition-file"))
-	cmd.Flags().StringVar(&commonFlags.serviceSpecFile, "service-spec-file", "", "Path to ECS service spec file")
-	cmdutil.Must(cmd.MarkFlagRequired("service-spec-file"))
+// registerServiceFlags registers the flags identifying the ECS service and the Prodvana Service it belongs to,
+// for commands that operate on an existing service.
+func registerServiceFlags(cmd *cobra.Command) {
 	cmd.Flags().StringVar(&commonFlags.ecsServiceName, "ecs-service-name", "", "Name of ECS service")
 	cmdutil.Must(cmd.MarkFlagRequired("ecs-service-name"))
 	cmd.Flags().StringVar(&commonFlags.ecsClusterName, "ecs-cluster-name", "", "Name of ECS cluster")
 	cmdutil.Must(cmd.MarkFlagRequired("ecs-cluster-name"))
 	cmd.Flags().StringVar(&commonFlags.pvnServiceId, "pvn-service-id", "", "Prodvana Service ID")
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-id"))
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		cmdutil.AddLogAttrs(
+			"service_id", commonFlags.pvnServiceId,
+			"service_version", commonFlags.pvnServiceVersion,
+			"ecs_cluster", commonFlags.ecsClusterName,
+			"ecs_service", commonFlags.ecsServiceName,
+		)
+	}
+}
+
+func registerCommonFlags(cmd *cobra.Command) {
+	registerServiceFlags(cmd)
+	cmd.Flags().StringVar(&commonFlags.taskDefinitionFile, "task-definition-file", "", "Path to ECS task definition file")
+	cmdutil.Must(cmd.MarkFlagRequired("task-definition-file"))
+	cmd.Flags().StringVar(&commonFlags.serviceSpecFile, "service-spec-file", "", "Path to ECS service spec file")
+	cmdutil.Must(cmd.MarkFlagRequired("service-spec-file"))
 	cmd.Flags().StringVar(&commonFlags.pvnServiceVersion, "pvn-service-version", "", "Prodvana Service Version")
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-version"))
 	cmd.Flags().BoolVar(&commonFlags.updateTaskDefinitionOnly, "update-task-definition-only", false, "Update task definition only")
 	cmdutil.Must(cmd.MarkFlagRequired("update-task-definition-only"))
 }
+
+// newRunner returns the Runner for commands, retrying the ones that fail transiently.
+func newRunner() cmdutil.Runner {
+	return cmdutil.NewRetryingRunner(cmdutil.NewRunner(), cmdutil.ClassifyAwsError, cmdutil.DefaultRetryPolicy)
+}
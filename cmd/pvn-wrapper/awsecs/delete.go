@@ -0,0 +1,175 @@
+package awsecs
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+// deregisterTaskDefinitions deregisters the task definitions that are still active, and returns the ones that are
+// INACTIVE now, leaving out the ones already being deleted.
+func deregisterTaskDefinitions(ctx context.Context, client ecsClient, revisions []taskDefinitionRevision) ([]taskDefinitionRevision, error) {
+	var inactive []taskDefinitionRevision
+	for _, rev := range revisions {
+		def, err := describeTaskDefinition(ctx, client, rev.arn)
+		if err != nil {
+			return inactive, err
+		}
+		var status types.TaskDefinitionStatus
+		if def.TaskDefinition != nil {
+			status = def.TaskDefinition.Status
+		}
+		switch status {
+		case types.TaskDefinitionStatusDeleteInProgress:
+			continue
+		case types.TaskDefinitionStatusInactive:
+		default:
+			slog.Info("Deregistering task definition", "task_definition", rev.arn, "version", rev.version)
+			if err := client.DeregisterTaskDefinition(ctx, rev.arn); err != nil {
+				return inactive, err
+			}
+		}
+		inactive = append(inactive, rev)
+	}
+	return inactive, nil
+}
+
+// waitForServiceDeletion polls the service until it is INACTIVE and returns the exit code pvn-wrapper should exit with.
+// Deleted services stay DRAINING until all of their tasks stopped.
+func waitForServiceDeletion(ctx context.Context, client ecsClient, cluster, service string, opts waitOptions) (int, error) {
+	var lastStatus string
+	var lastRunning int32 = -1
+	deleted, err := waitUntil(ctx, opts, func(ctx context.Context) (bool, error) {
+		serviceOutput, err := describeService(ctx, client, cluster, service)
+		if err != nil {
+			return false, err
+		}
+		if serviceMissing(serviceOutput) {
+			return true, nil
+		}
+		svc := serviceOutput.Services[0]
+		if aws.ToString(svc.Status) != lastStatus || svc.RunningCount != lastRunning {
+			slog.Info("Waiting for service to be deleted", "status", aws.ToString(svc.Status), "running", svc.RunningCount)
+			lastStatus, lastRunning = aws.ToString(svc.Status), svc.RunningCount
+		}
+		return false, nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed waiting for service to be deleted")
+	}
+	if !deleted {
+		slog.Error("Timed out waiting for service to be deleted", "timeout", opts.timeout)
+		return waitTimedOutExitCode, nil
+	}
+	slog.Info("Service deleted")
+	return 0, nil
+}
+
+// runDelete deletes the service and returns the exit code pvn-wrapper should exit with.
+func runDelete(ctx context.Context, client ecsClient) (int, error) {
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+	if err != nil {
+		return 0, err
+	}
+	if serviceMissing(serviceOutput) {
+		slog.Info("ECS service does not exist or was already deleted")
+	} else {
+		svc := serviceOutput.Services[0]
+		if aws.ToString(svc.Status) == "DRAINING" {
+			slog.Info("ECS service is already being deleted")
+		} else {
+			// services must be scaled to zero before they can be deleted without --force, except for daemon services
+			if !deleteFlags.force && svc.SchedulingStrategy != types.SchedulingStrategyDaemon && svc.DesiredCount > 0 {
+				slog.Info("Scaling service to zero", "desired_count", svc.DesiredCount)
+				if err := client.ScaleService(ctx, commonFlags.ecsClusterName, commonFlags.ecsServiceName, 0); err != nil {
+					return 0, err
+				}
+			}
+			slog.Info("Deleting service", "force", deleteFlags.force)
+			if err := client.DeleteService(ctx, commonFlags.ecsClusterName, commonFlags.ecsServiceName, deleteFlags.force); err != nil {
+				return 0, err
+			}
+		}
+		exitCode, err := waitForServiceDeletion(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName, deleteFlags.waitOptions)
+		if err != nil || exitCode != 0 {
+			return exitCode, err
+		}
+	}
+	if !deleteFlags.deregisterTaskDefinitions {
+		return 0, nil
+	}
+	inUse, err := servicesTaskDefinitions(ctx, client)
+	if err != nil {
+		return 0, err
+	}
+	revisions, err := listTaskDefinitionRevisions(ctx, client, commonFlags.pvnServiceId)
+	if err != nil {
+		return 0, err
+	}
+	var unused []taskDefinitionRevision
+	for _, rev := range revisions {
+		if inUse[rev.arn] {
+			slog.Info("Keeping task definition in use", "task_definition", rev.arn, "version", rev.version)
+			continue
+		}
+		unused = append(unused, rev)
+	}
+	inactive, err := deregisterTaskDefinitions(ctx, client, unused)
+	if err != nil {
+		return 0, err
+	}
+	slog.Info("Task definitions deregistered", "count", len(inactive))
+	return 0, nil
+}
+
+var deleteFlags = struct {
+	force                     bool
+	deregisterTaskDefinitions bool
+	waitOptions
+}{}
+
+var deleteCmd = &cobra.Command{
+	Use:   "delete",
+	Short: "Delete an ECS service",
+	Long: `Delete an ECS service.
+
+The service is scaled to zero, deleted, and pvn-wrapper waits until it is INACTIVE, which happens once all of its tasks
+stopped. With --force, the service is deleted without scaling it to zero first. Deleting a service that does not exist
+or was already deleted succeeds.
+
+With --deregister-task-definitions, the task definitions tagged with the Prodvana Service ID are deregistered once the
+service is deleted, except for the ones used by any deployment of any other ECS service in the region, such as the
+ECS services of other release channels.
+
+pvn-wrapper exits with 3 if the service was not deleted within --wait-timeout.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		exitCode, err := runDelete(cmd.Context(), client)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			cmdutil.Exit(exitCode)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(deleteCmd)
+
+	registerServiceFlags(deleteCmd)
+	deleteCmd.Flags().BoolVar(&deleteFlags.force, "force", false, "Delete the service without scaling it to zero first")
+	deleteCmd.Flags().BoolVar(&deleteFlags.deregisterTaskDefinitions, "deregister-task-definitions", false, "Deregister the task definitions tagged with the Prodvana Service ID and not used by any other ECS service once the service is deleted")
+	registerWaitFlags(deleteCmd, &deleteFlags.waitOptions, "the service deletion")
+}
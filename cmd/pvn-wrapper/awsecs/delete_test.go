@@ -0,0 +1,161 @@
+package awsecs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunDelete(t *testing.T) {
+	var (
+		scaleToZero  = cmdutil.FakeCall{Args: []string{"ecs", "update-service", "--service", "my-service", "--cluster", "my-cluster", "--desired-count", "0"}}
+		deleteArgs   = []string{"ecs", "delete-service", "--service", "my-service", "--cluster", "my-cluster"}
+		draining     = cmdutil.FakeCall{Args: describeServicesArgs, Stdout: `{"services": [{"status": "DRAINING", "desiredCount": 0, "runningCount": 1}]}`}
+		inactive     = cmdutil.FakeCall{Args: describeServicesArgs, Stdout: `{"services": [{"status": "INACTIVE", "desiredCount": 0}]}`}
+		stillRunning = make([]cmdutil.FakeCall, 1000)
+		listClusters = cmdutil.FakeCall{
+			Args:   []string{"ecs", "list-clusters"},
+			Stdout: `{"clusterArns": ["arn:aws:ecs:us-west-2:123456789012:cluster/my-cluster", "arn:aws:ecs:us-west-2:123456789012:cluster/other-cluster"]}`,
+		}
+		listServices = func(cluster string, arns ...string) cmdutil.FakeCall {
+			return cmdutil.FakeCall{
+				Args:   []string{"ecs", "list-services", "--cluster", "arn:aws:ecs:us-west-2:123456789012:cluster/" + cluster},
+				Stdout: `{"serviceArns": ["` + strings.Join(arns, `", "`) + `"]}`,
+			}
+		}
+		noServices = func(cluster string) cmdutil.FakeCall {
+			return cmdutil.FakeCall{
+				Args:   []string{"ecs", "list-services", "--cluster", "arn:aws:ecs:us-west-2:123456789012:cluster/" + cluster},
+				Stdout: `{"serviceArns": []}`,
+			}
+		}
+		listRevisions = cmdutil.FakeCall{
+			Args: []string{
+				"resourcegroupstaggingapi", "get-resources",
+				"--resource-type-filters", "ecs:task-definition",
+				"--tag-filters", "Key=pvn:id,Values=svc-id",
+			},
+			Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/a:2"}, {"ResourceARN": "arn:task/a:1"}]}`,
+		}
+	)
+	for i := range stillRunning {
+		stillRunning[i] = draining
+	}
+	for _, tc := range []struct {
+		name                      string
+		force                     bool
+		deregisterTaskDefinitions bool
+		calls                     []cmdutil.FakeCall
+		timeout                   time.Duration
+		expectedExitCode          int
+		expectedErr               string
+	}{
+		{
+			name: "scale to zero and delete",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 2, "runningCount": 2}]}`},
+				scaleToZero,
+				{Args: deleteArgs},
+				draining,
+				inactive,
+			},
+		},
+		{
+			name:  "force",
+			force: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 2, "runningCount": 2}]}`},
+				{Args: append(deleteArgs, "--force")},
+				inactive,
+			},
+		},
+		{
+			name: "already being deleted",
+			calls: []cmdutil.FakeCall{
+				draining,
+				inactive,
+			},
+		},
+		{
+			name:                      "already deleted",
+			deregisterTaskDefinitions: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				listClusters,
+				noServices("my-cluster"),
+				noServices("other-cluster"),
+				listRevisions,
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v2"),
+				{Args: []string{"ecs", "deregister-task-definition", "--task-definition", "arn:task/a:2"}},
+				{
+					Args:   []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/a:1"},
+					Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/a:1", "status": "INACTIVE"}}`,
+				},
+			},
+		},
+		{
+			name:                      "keep task definitions used by other ECS services",
+			deregisterTaskDefinitions: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				listClusters,
+				noServices("my-cluster"),
+				// e.g. another release channel, or a service never applied by Prodvana and so not tagged
+				listServices("other-cluster", "arn:aws:ecs:us-west-2:123456789012:service/other-cluster/my-service"),
+				{
+					Args: []string{
+						"ecs", "describe-services",
+						"--cluster", "arn:aws:ecs:us-west-2:123456789012:cluster/other-cluster",
+						"--services", "arn:aws:ecs:us-west-2:123456789012:service/other-cluster/my-service",
+					},
+					Stdout: `{"services": [{"status": "ACTIVE", "taskDefinition": "arn:task/a:2", "deployments": [
+						{"status": "PRIMARY", "taskDefinition": "arn:task/a:2"}
+					]}]}`,
+				},
+				listRevisions,
+				describeTaskDefinitionCall("arn:task/a:1", "svc-id", "svc-v1"),
+				{Args: []string{"ecs", "deregister-task-definition", "--task-definition", "arn:task/a:1"}},
+			},
+		},
+		{
+			name:  "delete fails",
+			force: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 2}]}`},
+				{Args: append(deleteArgs, "--force"), Stderr: "AccessDeniedException", ExitCode: 254},
+			},
+			expectedErr: "aws ecs delete-service",
+		},
+		{
+			name:                      "timed out",
+			deregisterTaskDefinitions: true,
+			calls:                     stillRunning,
+			timeout:                   50 * time.Millisecond,
+			expectedExitCode:          waitTimedOutExitCode,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, false)
+			prevFlags := deleteFlags
+			t.Cleanup(func() { deleteFlags = prevFlags })
+			deleteFlags.force = tc.force
+			deleteFlags.deregisterTaskDefinitions = tc.deregisterTaskDefinitions
+			deleteFlags.waitOptions = waitOptions{timeout: tc.timeout, pollInterval: time.Millisecond}
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			exitCode, err := runDelete(context.Background(), newCliClient(runner))
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedExitCode, exitCode)
+			}
+			if tc.timeout == 0 {
+				require.Empty(t, runner.Unused())
+			}
+		})
+	}
+}
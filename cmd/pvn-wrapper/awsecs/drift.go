@@ -0,0 +1,274 @@
+package awsecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+// appended to the version of a deployment whose service drifted from the spec,
+// so that Prodvana sees it as a different version and applies the spec again
+const driftedVersionSuffix = "-drifted"
+
+// fieldDiff is a setting whose live value differs from the one in the spec.
+type fieldDiff struct {
+	field string
+	// JSON encoded values, null if unset
+	live string
+	want string
+	// whether the values must be left out of messages, e.g. for environment variables
+	sensitive bool
+}
+
+func (d fieldDiff) String() string {
+	if d.sensitive {
+		return fmt.Sprintf("%s differs", d.field)
+	}
+	return fmt.Sprintf("%s is %s, want %s", d.field, d.live, d.want)
+}
+
+type differ struct {
+	diffs []fieldDiff
+}
+
+func jsonString(v interface{}) string {
+	out, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(out)
+}
+
+func (d *differ) compare(field string, live, want interface{}) {
+	d.compareValues(field, live, want, false)
+}
+
+func (d *differ) compareValues(field string, live, want interface{}, sensitive bool) {
+	liveString, wantString := jsonString(live), jsonString(want)
+	if liveString != wantString {
+		d.diffs = append(d.diffs, fieldDiff{field: field, live: liveString, want: wantString, sensitive: sensitive})
+	}
+}
+
+func sorted(values []string) []string {
+	values = append([]string{}, values...)
+	sort.Strings(values)
+	return values
+}
+
+type loadBalancerKey struct {
+	TargetGroupArn   string `json:"targetGroupArn,omitempty"`
+	LoadBalancerName string `json:"loadBalancerName,omitempty"`
+	ContainerName    string `json:"containerName"`
+	ContainerPort    int32  `json:"containerPort"`
+}
+
+func loadBalancerKeys(loadBalancers []types.LoadBalancer) []loadBalancerKey {
+	keys := []loadBalancerKey{}
+	for _, lb := range loadBalancers {
+		keys = append(keys, loadBalancerKey{
+			TargetGroupArn:   aws.ToString(lb.TargetGroupArn),
+			LoadBalancerName: aws.ToString(lb.LoadBalancerName),
+			ContainerName:    aws.ToString(lb.ContainerName),
+			ContainerPort:    aws.ToInt32(lb.ContainerPort),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return jsonString(keys[i]) < jsonString(keys[j])
+	})
+	return keys
+}
+
+type capacityProviderKey struct {
+	CapacityProvider string `json:"capacityProvider"`
+	Base             int32  `json:"base"`
+	Weight           int32  `json:"weight"`
+}
+
+func capacityProviderKeys(strategy []types.CapacityProviderStrategyItem) []capacityProviderKey {
+	keys := []capacityProviderKey{}
+	for _, item := range strategy {
+		keys = append(keys, capacityProviderKey{
+			CapacityProvider: aws.ToString(item.CapacityProvider),
+			Base:             item.Base,
+			Weight:           item.Weight,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].CapacityProvider < keys[j].CapacityProvider
+	})
+	return keys
+}
+
+// diffService compares the service settings that are set in the spec with the live service.
+func diffService(svc types.Service, spec *ecs.CreateServiceInput) []fieldDiff {
+	d := &differ{}
+	if spec.DesiredCount != nil {
+		d.compare("desiredCount", svc.DesiredCount, *spec.DesiredCount)
+	}
+	if spec.NetworkConfiguration != nil && spec.NetworkConfiguration.AwsvpcConfiguration != nil {
+		want := spec.NetworkConfiguration.AwsvpcConfiguration
+		live := &types.AwsVpcConfiguration{}
+		if svc.NetworkConfiguration != nil && svc.NetworkConfiguration.AwsvpcConfiguration != nil {
+			live = svc.NetworkConfiguration.AwsvpcConfiguration
+		}
+		d.compare("networkConfiguration.awsvpcConfiguration.subnets", sorted(live.Subnets), sorted(want.Subnets))
+		d.compare("networkConfiguration.awsvpcConfiguration.securityGroups", sorted(live.SecurityGroups), sorted(want.SecurityGroups))
+		wantAssignPublicIp := want.AssignPublicIp
+		if wantAssignPublicIp == "" {
+			wantAssignPublicIp = types.AssignPublicIpDisabled
+		}
+		d.compare("networkConfiguration.awsvpcConfiguration.assignPublicIp", live.AssignPublicIp, wantAssignPublicIp)
+	}
+	if spec.LoadBalancers != nil {
+		d.compare("loadBalancers", loadBalancerKeys(svc.LoadBalancers), loadBalancerKeys(spec.LoadBalancers))
+	}
+	if spec.CapacityProviderStrategy != nil {
+		d.compare("capacityProviderStrategy", capacityProviderKeys(svc.CapacityProviderStrategy), capacityProviderKeys(spec.CapacityProviderStrategy))
+	}
+	if spec.DeploymentConfiguration != nil {
+		want := spec.DeploymentConfiguration
+		live := &types.DeploymentConfiguration{}
+		if svc.DeploymentConfiguration != nil {
+			live = svc.DeploymentConfiguration
+		}
+		if want.MaximumPercent != nil {
+			d.compare("deploymentConfiguration.maximumPercent", live.MaximumPercent, want.MaximumPercent)
+		}
+		if want.MinimumHealthyPercent != nil {
+			d.compare("deploymentConfiguration.minimumHealthyPercent", live.MinimumHealthyPercent, want.MinimumHealthyPercent)
+		}
+		if want.DeploymentCircuitBreaker != nil {
+			d.compare("deploymentConfiguration.deploymentCircuitBreaker", circuitBreaker(svc), want.DeploymentCircuitBreaker)
+		}
+	}
+	return d.diffs
+}
+
+func environmentList(env []types.KeyValuePair) []string {
+	pairs := []string{}
+	for _, pair := range env {
+		pairs = append(pairs, aws.ToString(pair.Name)+"="+aws.ToString(pair.Value))
+	}
+	return sorted(pairs)
+}
+
+// diffTaskDefinition compares the container and task size settings that are set in the task definition file with the
+// registered task definition.
+func diffTaskDefinition(live *types.TaskDefinition, want *ecs.RegisterTaskDefinitionInput) []fieldDiff {
+	d := &differ{}
+	if want.Cpu != nil {
+		d.compare("cpu", live.Cpu, want.Cpu)
+	}
+	if want.Memory != nil {
+		d.compare("memory", live.Memory, want.Memory)
+	}
+	liveContainers := map[string]types.ContainerDefinition{}
+	for _, container := range live.ContainerDefinitions {
+		liveContainers[aws.ToString(container.Name)] = container
+	}
+	wantNames := map[string]bool{}
+	for _, wantContainer := range want.ContainerDefinitions {
+		name := aws.ToString(wantContainer.Name)
+		wantNames[name] = true
+		field := fmt.Sprintf("containerDefinitions[%s]", name)
+		liveContainer, ok := liveContainers[name]
+		if !ok {
+			d.diffs = append(d.diffs, fieldDiff{field: field, live: "null", want: jsonString(name)})
+			continue
+		}
+		d.compare(field+".image", liveContainer.Image, wantContainer.Image)
+		if wantContainer.Cpu != 0 {
+			d.compare(field+".cpu", liveContainer.Cpu, wantContainer.Cpu)
+		}
+		if wantContainer.Memory != nil {
+			d.compare(field+".memory", liveContainer.Memory, wantContainer.Memory)
+		}
+		if wantContainer.MemoryReservation != nil {
+			d.compare(field+".memoryReservation", liveContainer.MemoryReservation, wantContainer.MemoryReservation)
+		}
+		if wantContainer.Command != nil {
+			d.compare(field+".command", liveContainer.Command, wantContainer.Command)
+		}
+		if wantContainer.EntryPoint != nil {
+			d.compare(field+".entryPoint", liveContainer.EntryPoint, wantContainer.EntryPoint)
+		}
+		if wantContainer.Environment != nil {
+			d.compareValues(field+".environment", environmentList(liveContainer.Environment), environmentList(wantContainer.Environment), true)
+		}
+	}
+	var extra []string
+	for name := range liveContainers {
+		if !wantNames[name] {
+			extra = append(extra, name)
+		}
+	}
+	for _, name := range sorted(extra) {
+		d.diffs = append(d.diffs, fieldDiff{field: fmt.Sprintf("containerDefinitions[%s]", name), live: jsonString(name), want: "null"})
+	}
+	return d.diffs
+}
+
+// detectDrift compares the live service and the task definition of its PRIMARY deployment with the spec files,
+// patched the same way as by apply. It returns the drift of the service and of the task definition separately, since
+// apply reuses the task definition registered for a version, and so cannot undo drift of the task definition.
+func detectDrift(svc types.Service, taskDef *types.TaskDefinition) ([]fieldDiff, []fieldDiff, error) {
+	var serviceDiffs, taskDefDiffs []fieldDiff
+	if !commonFlags.updateTaskDefinitionOnly {
+		specPath, err := patchServiceSpec(commonFlags.serviceSpecFile, commonFlags.ecsServiceName, commonFlags.ecsClusterName, aws.ToString(svc.TaskDefinition), commonFlags.pvnServiceId, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() { _ = os.Remove(specPath) }()
+		var spec ecs.CreateServiceInput
+		if err := readInput(specPath, &spec); err != nil {
+			return nil, nil, err
+		}
+		if fetchFlags.driftIgnoreDesiredCount {
+			spec.DesiredCount = nil
+		}
+		serviceDiffs = diffService(svc, &spec)
+	}
+	if taskDef != nil {
+		taskDefPath, err := patchTaskDefinition(commonFlags.taskDefinitionFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer func() { _ = os.Remove(taskDefPath) }()
+		var want ecs.RegisterTaskDefinitionInput
+		if err := readInput(taskDefPath, &want); err != nil {
+			return nil, nil, err
+		}
+		taskDefDiffs = diffTaskDefinition(taskDef, &want)
+	}
+	return serviceDiffs, taskDefDiffs, nil
+}
+
+func driftFields(diffs []fieldDiff) string {
+	var fields []string
+	for _, diff := range diffs {
+		fields = append(fields, diff.field)
+	}
+	return strings.Join(fields, ", ")
+}
+
+// driftMessage describes the drift of the service and its task definition, one of which is not empty.
+func driftMessage(serviceDiffs, taskDefDiffs []fieldDiff) string {
+	var messages []string
+	if len(serviceDiffs) > 0 {
+		messages = append(messages, fmt.Sprintf("ECS service drifted from its spec in %s.", driftFields(serviceDiffs)))
+	}
+	if len(taskDefDiffs) > 0 {
+		messages = append(messages, fmt.Sprintf(
+			"Task definition drifted from its spec in %s, which apply does not undo since it reuses the task definition registered for the version. Release a new version to change it.",
+			driftFields(taskDefDiffs),
+		))
+	}
+	return strings.Join(messages, " ")
+}
@@ -0,0 +1,206 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func diffStrings(diffs []fieldDiff) []string {
+	var out []string
+	for _, diff := range diffs {
+		out = append(out, diff.String())
+	}
+	return out
+}
+
+func TestDiffService(t *testing.T) {
+	const live = `{
+		"desiredCount": 3,
+		"networkConfiguration": {"awsvpcConfiguration": {"subnets": ["subnet-b", "subnet-a"], "securityGroups": ["sg-1"], "assignPublicIp": "DISABLED"}},
+		"loadBalancers": [{"targetGroupArn": "arn:tg/1", "containerName": "app", "containerPort": 8080}],
+		"deploymentConfiguration": {"maximumPercent": 200, "minimumHealthyPercent": 100, "deploymentCircuitBreaker": {"enable": true, "rollback": true}}
+	}`
+	for _, tc := range []struct {
+		name     string
+		spec     string
+		expected []string
+	}{
+		{
+			name: "no drift",
+			spec: `{
+				"desiredCount": 3,
+				"networkConfiguration": {"awsvpcConfiguration": {"subnets": ["subnet-a", "subnet-b"], "securityGroups": ["sg-1"]}},
+				"loadBalancers": [{"targetGroupArn": "arn:tg/1", "containerName": "app", "containerPort": 8080}],
+				"deploymentConfiguration": {"minimumHealthyPercent": 100, "deploymentCircuitBreaker": {"enable": true, "rollback": true}}
+			}`,
+		},
+		{
+			name: "settings missing from the spec are not compared",
+			spec: `{}`,
+		},
+		{
+			name: "drift",
+			spec: `{
+				"desiredCount": 2,
+				"networkConfiguration": {"awsvpcConfiguration": {"subnets": ["subnet-a"], "securityGroups": ["sg-1"], "assignPublicIp": "ENABLED"}},
+				"loadBalancers": [],
+				"capacityProviderStrategy": [{"capacityProvider": "FARGATE_SPOT", "weight": 1}],
+				"deploymentConfiguration": {"maximumPercent": 150}
+			}`,
+			expected: []string{
+				"desiredCount is 3, want 2",
+				`networkConfiguration.awsvpcConfiguration.subnets is ["subnet-a","subnet-b"], want ["subnet-a"]`,
+				`networkConfiguration.awsvpcConfiguration.assignPublicIp is "DISABLED", want "ENABLED"`,
+				`loadBalancers is [{"targetGroupArn":"arn:tg/1","containerName":"app","containerPort":8080}], want []`,
+				`capacityProviderStrategy is [], want [{"capacityProvider":"FARGATE_SPOT","base":0,"weight":1}]`,
+				"deploymentConfiguration.maximumPercent is 200, want 150",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var svc types.Service
+			require.NoError(t, json.Unmarshal([]byte(live), &svc))
+			var spec ecs.CreateServiceInput
+			require.NoError(t, json.Unmarshal([]byte(tc.spec), &spec))
+			require.Equal(t, tc.expected, diffStrings(diffService(svc, &spec)))
+		})
+	}
+}
+
+func TestDiffTaskDefinition(t *testing.T) {
+	const live = `{
+		"cpu": "256",
+		"memory": "512",
+		"containerDefinitions": [
+			{"name": "app", "image": "app:v1", "cpu": 128, "essential": true, "environment": [{"name": "DB_PASSWORD", "value": "hunter2"}]},
+			{"name": "sidecar", "image": "sidecar:v1"}
+		]
+	}`
+	for _, tc := range []struct {
+		name     string
+		want     string
+		expected []string
+	}{
+		{
+			name: "no drift",
+			want: `{"cpu": "256", "containerDefinitions": [
+				{"name": "app", "image": "app:v1", "environment": [{"name": "DB_PASSWORD", "value": "hunter2"}]},
+				{"name": "sidecar", "image": "sidecar:v1"}
+			]}`,
+		},
+		{
+			name: "drift",
+			want: `{"memory": "1024", "containerDefinitions": [
+				{"name": "app", "image": "app:v2", "cpu": 128, "environment": [{"name": "DB_PASSWORD", "value": "changed"}]},
+				{"name": "worker", "image": "worker:v1"}
+			]}`,
+			expected: []string{
+				`memory is "512", want "1024"`,
+				`containerDefinitions[app].image is "app:v1", want "app:v2"`,
+				"containerDefinitions[app].environment differs",
+				`containerDefinitions[worker] is null, want "worker"`,
+				`containerDefinitions[sidecar] is "sidecar", want null`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var liveDef types.TaskDefinition
+			require.NoError(t, json.Unmarshal([]byte(live), &liveDef))
+			var want ecs.RegisterTaskDefinitionInput
+			require.NoError(t, json.Unmarshal([]byte(tc.want), &want))
+			require.Equal(t, tc.expected, diffStrings(diffTaskDefinition(&liveDef, &want)))
+		})
+	}
+}
+
+func TestRunFetchDetectDrift(t *testing.T) {
+	for _, tc := range []struct {
+		name                    string
+		serviceSpec             string
+		image                   string
+		driftIgnoreDesiredCount bool
+		expectedVersion         string
+		expectedMessage         string
+		expectedEvents          []string
+	}{
+		{
+			name:            "service drift",
+			serviceSpec:     `{"desiredCount": 2}`,
+			image:           "app:v1",
+			expectedVersion: "svc-v1" + driftedVersionSuffix,
+			expectedMessage: "ECS service drifted from its spec in desiredCount.",
+			expectedEvents:  []string{"Drift: desiredCount is 3, want 2", "Deployment d1 started."},
+		},
+		{
+			name:            "fields removed by apply are allowed",
+			serviceSpec:     `{"service": "my-service", "serviceName": "my-service", "desiredCount": 2}`,
+			image:           "app:v1",
+			expectedVersion: "svc-v1" + driftedVersionSuffix,
+			expectedMessage: "ECS service drifted from its spec in desiredCount.",
+			expectedEvents:  []string{"Drift: desiredCount is 3, want 2", "Deployment d1 started."},
+		},
+		{
+			name:                    "ignore desired count",
+			serviceSpec:             `{"desiredCount": 2}`,
+			image:                   "app:v1",
+			driftIgnoreDesiredCount: true,
+			expectedVersion:         "svc-v1",
+		},
+		{
+			name:            "task definition drift is only reported",
+			serviceSpec:     `{"desiredCount": 3}`,
+			image:           "app:v2",
+			expectedVersion: "svc-v1",
+			expectedMessage: "Task definition drifted from its spec in containerDefinitions[app].image, which apply does not undo since it reuses the task definition registered for the version. Release a new version to change it.",
+			expectedEvents:  []string{`Task definition drift: containerDefinitions[app].image is "app:v1", want "app:v2"`, "Deployment d1 started."},
+		},
+		{
+			name:            "invalid spec does not fail fetch",
+			serviceSpec:     `{"desiredCount": 2, "unknownField": true}`,
+			image:           "app:v1",
+			expectedVersion: "svc-v1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, false)
+			t.Setenv("AWS_DEFAULT_REGION", "us-west-2")
+			prevFlags := fetchFlags
+			t.Cleanup(func() { fetchFlags = prevFlags })
+			fetchFlags.detectDrift = true
+			fetchFlags.driftIgnoreDesiredCount = tc.driftIgnoreDesiredCount
+			require.NoError(t, os.WriteFile(commonFlags.serviceSpecFile, []byte(tc.serviceSpec), 0o600))
+			require.NoError(t, os.WriteFile(commonFlags.taskDefinitionFile, []byte(`{"family": "my-family", "containerDefinitions": [{"name": "app", "image": "`+tc.image+`"}]}`), 0o600))
+			runner := cmdutil.NewFakeRunner(
+				cmdutil.FakeCall{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 3, "deployments": [
+					{"id": "d1", "status": "PRIMARY", "taskDefinition": "arn:task/a:1", "desiredCount": 3, "runningCount": 3, "rolloutState": "COMPLETED", "createdAt": "2024-01-01T00:00:00Z"}
+				]}]}`},
+				cmdutil.FakeCall{
+					Args: []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/a:1"},
+					Stdout: `{
+						"taskDefinition": {"taskDefinitionArn": "arn:task/a:1", "containerDefinitions": [{"name": "app", "image": "app:v1"}]},
+						"tags": [{"key": "pvn:id", "value": "svc-id"}, {"key": "pvn:version", "value": "svc-v1"}]
+					}`,
+				},
+			)
+			output, err := runFetch(context.Background(), newCliClient(runner))
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+			require.Equal(t, extensions_pb.ExternalObject_SUCCEEDED, output.Objects[0].Status)
+			require.Equal(t, map[string]int32{tc.expectedVersion: 3}, fetchedVersions(t, output))
+			require.Equal(t, tc.expectedMessage, output.Objects[0].Message)
+			var events []string
+			for _, event := range output.Objects[0].DebugEvents {
+				events = append(events, event.Message)
+			}
+			require.Equal(t, tc.expectedEvents, events)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package awsecs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	runtimes_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// maximum number of service events reported by fetch
+	maxServiceEvents = 10
+	// maximum number of tasks DescribeTasks accepts
+	maxDescribeTasks = 100
+)
+
+// serviceEvents converts the service's events since the deployment started into debug events.
+// ECS returns events newest first.
+func serviceEvents(svc types.Service, since time.Time) []*runtimes_pb.DebugEvent {
+	var events []*runtimes_pb.DebugEvent
+	for _, event := range svc.Events {
+		if len(events) >= maxServiceEvents || aws.ToTime(event.CreatedAt).Before(since) {
+			break
+		}
+		events = append(events, &runtimes_pb.DebugEvent{
+			Timestamp: timestamppb.New(aws.ToTime(event.CreatedAt)),
+			Message:   aws.ToString(event.Message),
+		})
+	}
+	return events
+}
+
+func arnId(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
+
+// stoppedTaskMessage explains why a task stopped, from its stopped reason, container exit codes, and health checks.
+func stoppedTaskMessage(task types.Task) string {
+	parts := []string{fmt.Sprintf("Task %s stopped: %s.", arnId(aws.ToString(task.TaskArn)), aws.ToString(task.StoppedReason))}
+	unhealthyContainer := false
+	for _, container := range task.Containers {
+		name := aws.ToString(container.Name)
+		reason := aws.ToString(container.Reason)
+		switch {
+		case container.ExitCode != nil && *container.ExitCode != 0 && reason != "":
+			parts = append(parts, fmt.Sprintf("Container %s exited with code %d: %s.", name, *container.ExitCode, reason))
+		case container.ExitCode != nil && *container.ExitCode != 0:
+			parts = append(parts, fmt.Sprintf("Container %s exited with code %d.", name, *container.ExitCode))
+		case reason != "":
+			parts = append(parts, fmt.Sprintf("Container %s: %s.", name, reason))
+		}
+		if container.HealthStatus == types.HealthStatusUnhealthy {
+			unhealthyContainer = true
+			parts = append(parts, fmt.Sprintf("Container %s failed its health check.", name))
+		}
+	}
+	if task.HealthStatus == types.HealthStatusUnhealthy && !unhealthyContainer {
+		parts = append(parts, "Task failed its health check.")
+	}
+	return strings.Join(parts, " ")
+}
+
+// stoppedTaskEvents describes the recently stopped tasks of a deployment as debug events, newest first.
+// ECS only keeps stopped tasks for about an hour. Tasks started by a service deployment have the deployment ID
+// as startedBy, so only the deployment's tasks are listed, up to as many as can be described at once.
+func stoppedTaskEvents(ctx context.Context, client ecsClient, cluster, deploymentId string) ([]*runtimes_pb.DebugEvent, error) {
+	taskArns, err := client.ListTasks(ctx, cluster, deploymentId, types.DesiredStatusStopped, maxDescribeTasks)
+	if err != nil {
+		return nil, err
+	}
+	if len(taskArns) == 0 {
+		return nil, nil
+	}
+	tasksOutput, err := client.DescribeTasks(ctx, cluster, taskArns)
+	if err != nil {
+		return nil, err
+	}
+	var events []*runtimes_pb.DebugEvent
+	for _, task := range tasksOutput.Tasks {
+		stoppedAt := task.StoppedAt
+		if stoppedAt == nil {
+			stoppedAt = task.StoppingAt
+		}
+		events = append(events, &runtimes_pb.DebugEvent{
+			Timestamp: timestamppb.New(aws.ToTime(stoppedAt)),
+			Message:   stoppedTaskMessage(task),
+		})
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Timestamp.AsTime().After(events[j].Timestamp.AsTime())
+	})
+	return events, nil
+}
@@ -1,23 +1,28 @@
 package awsecs
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"sort"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 	"github.com/pkg/errors"
 	common_config_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/common_config"
 	runtimes_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes"
 	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/spf13/cobra"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
-func runFetch() (*extensions_pb.FetchOutput, error) {
-	serviceOutput, err := describeService(commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+func runFetch(ctx context.Context, client ecsClient) (*extensions_pb.FetchOutput, error) {
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
 	if err != nil {
 		return nil, err
 	}
@@ -50,23 +55,23 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 		}, nil
 	}
 	versionChan := make(chan *extensions_pb.ExternalObjectVersion)
-	errg := errgroup.Group{}
-	for _, depl := range serviceOutput.Services[0].Deployments {
-		depl := depl
+	errg, errgCtx := errgroup.WithContext(ctx)
+	// task definitions of the deployments, by index
+	taskDefs := make([]*types.TaskDefinition, len(serviceOutput.Services[0].Deployments))
+	for i, depl := range serviceOutput.Services[0].Deployments {
+		i, depl := i, depl
 		errg.Go(func() error {
-			def, err := describeTaskDefinition(depl.TaskDefinition)
+			def, err := describeTaskDefinition(errgCtx, client, aws.ToString(depl.TaskDefinition))
 			if err != nil {
 				return err
 			}
+			taskDefs[i] = def.TaskDefinition
 			tags := tagsToMap(def.Tags)
 			version := &extensions_pb.ExternalObjectVersion{
-				Replicas:          int32(depl.PendingCount) + int32(depl.RunningCount),
-				Active:            depl.Status == "PRIMARY",
-				AvailableReplicas: int32(depl.RunningCount),
-				TargetReplicas:    int32(depl.DesiredCount),
-				// TODO(naphat) today we use the service version string to detect drift.
-				// It is currently not possible to change ECS-service-level settings like desired count
-				// without also creating a new version string, so this works.
+				Replicas:          depl.PendingCount + depl.RunningCount,
+				Active:            aws.ToString(depl.Status) == "PRIMARY",
+				AvailableReplicas: depl.RunningCount,
+				TargetReplicas:    depl.DesiredCount,
 			}
 			if version.Replicas == 0 {
 				// skip, this deployment is no longer active and has no replicas left
@@ -94,41 +99,108 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 		return nil, err
 	}
 	<-done
+	// versions are collected concurrently, sort them for stable output
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version < versions[j].Version
+	})
 	ecsServiceObj.Versions = versions
 	foundCount := 0
 	var debugMessage string
 	var debugEvents []*runtimes_pb.DebugEvent
-	for _, depl := range serviceOutput.Services[0].Deployments {
-		if depl.Status == "PRIMARY" {
+	var primary types.Deployment
+	var primaryTaskDef *types.TaskDefinition
+	var lastStoppedTask string
+	for i, depl := range serviceOutput.Services[0].Deployments {
+		if aws.ToString(depl.Status) == "PRIMARY" {
+			primaryTaskDef = taskDefs[i]
 			switch depl.RolloutState {
-			case "COMPLETED":
+			case types.DeploymentRolloutStateCompleted:
 				ecsServiceObj.Status = extensions_pb.ExternalObject_SUCCEEDED
-			case "FAILED":
+			case types.DeploymentRolloutStateFailed:
 				ecsServiceObj.Status = extensions_pb.ExternalObject_FAILED
 			}
 			foundCount++
+			primary = depl
 			debugEvents = append(debugEvents, &runtimes_pb.DebugEvent{
-				Timestamp: timestamppb.New(depl.CreatedAt),
-				Message:   fmt.Sprintf("Deployment %s started.", depl.Id),
+				Timestamp: timestamppb.New(aws.ToTime(depl.CreatedAt)),
+				Message:   fmt.Sprintf("Deployment %s started.", aws.ToString(depl.Id)),
 			})
 			if depl.FailedTasks > 0 {
 				debugEvents = append(debugEvents, &runtimes_pb.DebugEvent{
-					Timestamp: timestamppb.New(depl.UpdatedAt),
-					Message:   fmt.Sprintf("Deployment %s has %d failing tasks.", depl.Id, depl.FailedTasks),
+					Timestamp: timestamppb.New(aws.ToTime(depl.UpdatedAt)),
+					Message:   fmt.Sprintf("Deployment %s has %d failing tasks.", aws.ToString(depl.Id), depl.FailedTasks),
 				})
+				// stopped tasks only help explain the status, do not fail fetch if they cannot be described
+				taskEvents, err := stoppedTaskEvents(ctx, client, commonFlags.ecsClusterName, aws.ToString(depl.Id))
+				if err != nil {
+					slog.Warn("Failed to describe stopped tasks", "deployment", aws.ToString(depl.Id), "error", err)
+				}
+				if len(taskEvents) > 0 {
+					lastStoppedTask = taskEvents[0].Message
+				}
+				debugEvents = append(debugEvents, taskEvents...)
 			}
 		}
 	}
 	if foundCount != 1 {
-		log.Printf("Found multiple PRIMARY deployments for service %s, marking it as PENDING", commonFlags.ecsServiceName)
+		slog.Info("Found multiple PRIMARY deployments for service, marking it as PENDING")
 		ecsServiceObj.Status = extensions_pb.ExternalObject_PENDING
 		debugMessage = "Found multiple PRIMARY deployments"
+	} else {
+		debugEvents = append(debugEvents, serviceEvents(serviceOutput.Services[0], aws.ToTime(primary.CreatedAt))...)
+		switch {
+		case primary.RolloutState == types.DeploymentRolloutStateFailed:
+			debugMessage = fmt.Sprintf("Deployment %s failed: %s", aws.ToString(primary.Id), aws.ToString(primary.RolloutStateReason))
+		case primary.FailedTasks > 0:
+			debugMessage = fmt.Sprintf("Deployment %s has %d failing tasks.", aws.ToString(primary.Id), primary.FailedTasks)
+		}
+		if lastStoppedTask != "" {
+			debugMessage += " Last stopped task: " + lastStoppedTask
+		}
+	}
+	// Without --detect-drift, only the service version string is used to detect drift,
+	// so changes made to the service outside of Prodvana go unnoticed.
+	var serviceDrift, taskDefDrift []fieldDiff
+	if fetchFlags.detectDrift && foundCount == 1 {
+		// only a deployment of the current version can drift, other versions are already applied again
+		for _, version := range versions {
+			if version.Active && version.Version == commonFlags.pvnServiceVersion {
+				serviceDrift, taskDefDrift, err = detectDrift(serviceOutput.Services[0], primaryTaskDef)
+				if err != nil {
+					// drift only adds to the status, do not fail fetch if it cannot be checked
+					slog.Warn("Failed to detect drift", "error", err)
+				}
+				if len(serviceDrift) > 0 {
+					version.Version += driftedVersionSuffix
+				}
+			}
+		}
+	}
+	drifted := len(serviceDrift) > 0 || len(taskDefDrift) > 0
+	if drifted {
+		slog.Info("ECS service drifted from its spec", "service_drift", len(serviceDrift), "task_definition_drift", len(taskDefDrift))
+		now := timestamppb.Now()
+		for _, diff := range serviceDrift {
+			debugEvents = append(debugEvents, &runtimes_pb.DebugEvent{
+				Timestamp: now,
+				Message:   "Drift: " + diff.String(),
+			})
+		}
+		for _, diff := range taskDefDrift {
+			debugEvents = append(debugEvents, &runtimes_pb.DebugEvent{
+				Timestamp: now,
+				Message:   "Task definition drift: " + diff.String(),
+			})
+		}
+		if debugMessage == "" {
+			debugMessage = driftMessage(serviceDrift, taskDefDrift)
+		}
 	}
-	sort.Slice(debugEvents, func(i, j int) bool {
+	sort.SliceStable(debugEvents, func(i, j int) bool {
 		// sort descending order
 		return debugEvents[i].Timestamp.AsTime().After(debugEvents[j].Timestamp.AsTime())
 	})
-	if ecsServiceObj.Status == extensions_pb.ExternalObject_PENDING {
+	if ecsServiceObj.Status == extensions_pb.ExternalObject_PENDING || ecsServiceObj.Status == extensions_pb.ExternalObject_FAILED || drifted {
 		ecsServiceObj.Message = debugMessage
 		ecsServiceObj.DebugEvents = debugEvents
 	}
@@ -139,12 +211,24 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 	}, nil
 }
 
+var fetchFlags = struct {
+	timeout                 time.Duration
+	detectDrift             bool
+	driftIgnoreDesiredCount bool
+}{}
+
 var fetchCmd = &cobra.Command{
 	Use:   "fetch",
 	Short: "Fetch current state of an ECS service",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		fetchOutput, err := runFetch()
+		ctx, cancel := cmdutil.WithTimeout(cmd.Context(), fetchFlags.timeout)
+		defer cancel()
+		client, err := newClient(ctx)
+		if err != nil {
+			return err
+		}
+		fetchOutput, err := runFetch(ctx, client)
 		if err != nil {
 			return err
 		}
@@ -164,4 +248,7 @@ func init() {
 	RootCmd.AddCommand(fetchCmd)
 
 	registerCommonFlags(fetchCmd)
+	fetchCmd.Flags().DurationVar(&fetchFlags.timeout, "fetch-timeout", 0, "Maximum time fetch may take, including every command it runs. 0 means no limit.")
+	fetchCmd.Flags().BoolVar(&fetchFlags.detectDrift, "detect-drift", false, "Compare the live service and task definition with --service-spec-file and --task-definition-file. If the service differs, "+driftedVersionSuffix+" is added to the version, so that Prodvana applies it again. Differences of the task definition are only reported as debug events, since apply reuses the task definition registered for a version.")
+	fetchCmd.Flags().BoolVar(&fetchFlags.driftIgnoreDesiredCount, "drift-ignore-desired-count", false, "With --detect-drift, do not compare desiredCount, for services scaled by autoscaling")
 }
@@ -0,0 +1,56 @@
+package awsecs
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+var updateGolden = flag.Bool("update", false, "Update golden files")
+
+// TestRunFetchReplayed replays the aws calls of sessions under testdata/<session>, in the format written by
+// PVN_WRAPPER_RECORD, and compares the fetch output against testdata/<session>.golden.json.
+// The sessions are written by hand after the output of the aws CLI, not recorded against an AWS account, so edit
+// them along with the calls fetch makes.
+// Run with -update to regenerate the golden files after an intended change.
+func TestRunFetchReplayed(t *testing.T) {
+	sessions, err := filepath.Glob(filepath.Join("testdata", "fetch-*"))
+	require.NoError(t, err)
+	for _, sessionDir := range sessions {
+		info, err := os.Stat(sessionDir)
+		require.NoError(t, err)
+		if !info.IsDir() {
+			continue
+		}
+		t.Run(filepath.Base(sessionDir), func(t *testing.T) {
+			setupCommonFlags(t, false)
+			t.Setenv("AWS_DEFAULT_REGION", "us-west-2")
+			runner, err := cmdutil.NewReplayRunner(sessionDir)
+			require.NoError(t, err)
+			output, err := runFetch(context.Background(), newCliClient(runner))
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+
+			goldenPath := sessionDir + ".golden.json"
+			if *updateGolden {
+				outputBytes, err := protojson.MarshalOptions{Multiline: true}.Marshal(output)
+				require.NoError(t, err)
+				require.NoError(t, os.WriteFile(goldenPath, outputBytes, 0o644))
+			}
+			goldenBytes, err := os.ReadFile(goldenPath)
+			require.NoError(t, err)
+			var expected extensions_pb.FetchOutput
+			require.NoError(t, protojson.Unmarshal(goldenBytes, &expected))
+			require.Empty(t, cmp.Diff(&expected, output, protocmp.Transform()))
+		})
+	}
+}
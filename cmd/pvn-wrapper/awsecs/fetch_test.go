@@ -0,0 +1,139 @@
+package awsecs
+
+import (
+	"context"
+	"testing"
+
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func describeTaskDefinitionCall(arn, serviceId, version string) cmdutil.FakeCall {
+	return cmdutil.FakeCall{
+		Args: []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", arn},
+		Stdout: `{
+			"taskDefinition": {"taskDefinitionArn": "` + arn + `", "status": "ACTIVE"},
+			"tags": [{"key": "pvn:id", "value": "` + serviceId + `"}, {"key": "pvn:version", "value": "` + version + `"}]
+		}`,
+	}
+}
+
+func TestRunFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		calls          []cmdutil.FakeCall
+		expectedStatus extensions_pb.ExternalObject_Status
+		// version -> replicas
+		expectedVersions map[string]int32
+		expectedMessage  string
+		// messages of the debug events, newest first
+		expectedEvents []string
+	}{
+		{
+			name:           "missing service",
+			calls:          []cmdutil.FakeCall{{Args: describeServicesArgs, Stdout: serviceMissingOutput}},
+			expectedStatus: extensions_pb.ExternalObject_PENDING,
+		},
+		{
+			name: "completed rollout",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "deployments": [
+					{"id": "d1", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "runningCount": 2, "rolloutState": "COMPLETED", "createdAt": "2024-01-01T00:00:00Z"}
+				]}]}`},
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v2"),
+			},
+			expectedStatus:   extensions_pb.ExternalObject_SUCCEEDED,
+			expectedVersions: map[string]int32{"svc-v2": 2},
+		},
+		{
+			name: "rollout in progress",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "deployments": [
+					{"id": "d2", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "pendingCount": 1, "runningCount": 1, "rolloutState": "IN_PROGRESS", "createdAt": "2024-01-02T00:00:00Z"},
+					{"id": "d1", "status": "ACTIVE", "taskDefinition": "arn:task/a:1", "desiredCount": 2, "runningCount": 1, "rolloutState": "COMPLETED", "createdAt": "2024-01-01T00:00:00Z"},
+					{"id": "d0", "status": "INACTIVE", "taskDefinition": "arn:task/a:0", "desiredCount": 0, "rolloutState": "COMPLETED", "createdAt": "2023-12-01T00:00:00Z"}
+				]}]}`},
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v2"),
+				describeTaskDefinitionCall("arn:task/a:1", "svc-id", "svc-v1"),
+				describeTaskDefinitionCall("arn:task/a:0", "svc-id", "svc-v0"),
+			},
+			expectedStatus:   extensions_pb.ExternalObject_PENDING,
+			expectedVersions: map[string]int32{"svc-v2": 2, "svc-v1": 1},
+		},
+		{
+			name: "failed rollout",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "deployments": [
+					{"id": "d1", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "pendingCount": 2, "failedTasks": 3, "rolloutState": "FAILED", "rolloutStateReason": "ECS deployment circuit breaker: tasks failed to start.", "createdAt": "2024-01-01T00:00:00Z", "updatedAt": "2024-01-01T00:10:00Z"}
+				], "events": [
+					{"id": "e2", "createdAt": "2024-01-01T00:09:00Z", "message": "(service my-service) deployment failed: tasks failed to start."},
+					{"id": "e1", "createdAt": "2023-12-31T00:00:00Z", "message": "(service my-service) has reached a steady state."}
+				]}]}`},
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v2"),
+				{
+					Args:   []string{"ecs", "list-tasks", "--cluster", "my-cluster", "--started-by", "d1", "--desired-status", "STOPPED", "--max-items", "100"},
+					Stdout: `{"taskArns": ["arn:task/my-cluster/t1", "arn:task/my-cluster/t2"]}`,
+				},
+				{
+					Args: []string{"ecs", "describe-tasks", "--cluster", "my-cluster", "--tasks", "arn:task/my-cluster/t1", "arn:task/my-cluster/t2"},
+					Stdout: `{"tasks": [
+						{"taskArn": "arn:task/my-cluster/t1", "startedBy": "d1", "stoppedReason": "Task failed ELB health checks in (target-group arn:tg)", "healthStatus": "UNHEALTHY", "stoppedAt": "2024-01-01T00:08:00Z", "containers": [{"name": "app", "exitCode": 0}]},
+						{"taskArn": "arn:task/my-cluster/t2", "startedBy": "d1", "stoppedReason": "Essential container in task exited", "stoppedAt": "2024-01-01T00:07:00Z", "containers": [{"name": "app", "exitCode": 1}]}
+					]}`,
+				},
+			},
+			expectedStatus:   extensions_pb.ExternalObject_FAILED,
+			expectedVersions: map[string]int32{"svc-v2": 2},
+			expectedMessage:  "Deployment d1 failed: ECS deployment circuit breaker: tasks failed to start. Last stopped task: Task t1 stopped: Task failed ELB health checks in (target-group arn:tg). Task failed its health check.",
+			expectedEvents: []string{
+				"Deployment d1 has 3 failing tasks.",
+				"(service my-service) deployment failed: tasks failed to start.",
+				"Task t1 stopped: Task failed ELB health checks in (target-group arn:tg). Task failed its health check.",
+				"Task t2 stopped: Essential container in task exited. Container app exited with code 1.",
+				"Deployment d1 started.",
+			},
+		},
+		{
+			name: "version from another service is unknown",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "deployments": [
+					{"id": "d1", "status": "PRIMARY", "taskDefinition": "arn:task/b:1", "desiredCount": 1, "runningCount": 1, "rolloutState": "COMPLETED", "createdAt": "2024-01-01T00:00:00Z"}
+				]}]}`},
+				describeTaskDefinitionCall("arn:task/b:1", "other-svc", "other-v1"),
+			},
+			expectedStatus:   extensions_pb.ExternalObject_SUCCEEDED,
+			expectedVersions: map[string]int32{"": 1},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, false)
+			t.Setenv("AWS_DEFAULT_REGION", "us-west-2")
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			output, err := runFetch(context.Background(), newCliClient(runner))
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+			require.Len(t, output.Objects, 1)
+			obj := output.Objects[0]
+			require.Equal(t, "my-service", obj.Name)
+			require.Equal(t, tc.expectedStatus, obj.Status)
+			versions := map[string]int32{}
+			for _, version := range obj.Versions {
+				versions[version.Version] = version.Replicas
+			}
+			if tc.expectedVersions == nil {
+				require.Empty(t, versions)
+			} else {
+				require.Equal(t, tc.expectedVersions, versions)
+			}
+			if tc.expectedEvents != nil {
+				require.Equal(t, tc.expectedMessage, obj.Message)
+				var events []string
+				for _, event := range obj.DebugEvents {
+					events = append(events, event.Message)
+				}
+				require.Equal(t, tc.expectedEvents, events)
+			}
+		})
+	}
+}
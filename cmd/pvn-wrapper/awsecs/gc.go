@@ -0,0 +1,156 @@
+package awsecs
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// maximum number of task definitions DeleteTaskDefinitions accepts
+const maxDeleteTaskDefinitions = 10
+
+// gcCandidates returns the task definitions to clean up: in each family, the ones older than the newest keep versions,
+// except for the ones in use. revisions must be sorted newest first within each family.
+func gcCandidates(revisions []taskDefinitionRevision, keep int, inUse map[string]bool) []taskDefinitionRevision {
+	var candidates []taskDefinitionRevision
+	// versions seen so far in each family
+	kept := map[string]map[string]bool{}
+	for _, rev := range revisions {
+		if kept[rev.family] == nil {
+			kept[rev.family] = map[string]bool{}
+		}
+		versions := kept[rev.family]
+		if versions[rev.version] || len(versions) < keep {
+			versions[rev.version] = true
+			continue
+		}
+		if inUse[rev.arn] {
+			slog.Info("Keeping task definition in use", "task_definition", rev.arn, "version", rev.version)
+			continue
+		}
+		candidates = append(candidates, rev)
+	}
+	return candidates
+}
+
+func addServiceTaskDefinitions(inUse map[string]bool, svc types.Service) {
+	inUse[aws.ToString(svc.TaskDefinition)] = true
+	for _, depl := range svc.Deployments {
+		inUse[aws.ToString(depl.TaskDefinition)] = true
+	}
+}
+
+// maximum number of services DescribeServices accepts
+const maxDescribeServices = 10
+
+// servicesTaskDefinitions returns the task definitions used by any deployment of any ECS service in the region.
+// Besides the ECS services of the Prodvana Service, such as the ones of other release channels, any other service may
+// use its task definitions too, so every service on every cluster is checked.
+func servicesTaskDefinitions(ctx context.Context, client ecsClient) (map[string]bool, error) {
+	clusters, err := client.ListClusters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inUse := map[string]bool{}
+	serviceCount := 0
+	for _, cluster := range clusters {
+		services, err := client.ListServices(ctx, cluster)
+		if err != nil {
+			return nil, err
+		}
+		for start := 0; start < len(services); start += maxDescribeServices {
+			output, err := client.DescribeServices(ctx, cluster, services[start:min(start+maxDescribeServices, len(services))]...)
+			if err != nil {
+				return nil, err
+			}
+			for _, svc := range output.Services {
+				if aws.ToString(svc.Status) == "INACTIVE" {
+					continue
+				}
+				serviceCount++
+				addServiceTaskDefinitions(inUse, svc)
+			}
+		}
+	}
+	slog.Info("Found task definitions in use by ECS services", "clusters", len(clusters), "services", serviceCount)
+	return inUse, nil
+}
+
+// runGc deregisters and deletes the task definitions of the Prodvana Service beyond the newest keep versions.
+func runGc(ctx context.Context, client ecsClient, keep int) error {
+	if keep < 1 {
+		return errors.Errorf("must keep at least 1 version, got %d", keep)
+	}
+	inUse, err := servicesTaskDefinitions(ctx, client)
+	if err != nil {
+		return err
+	}
+	revisions, err := listTaskDefinitionRevisions(ctx, client, commonFlags.pvnServiceId)
+	if err != nil {
+		return err
+	}
+	candidates := gcCandidates(revisions, keep, inUse)
+	if len(candidates) == 0 {
+		slog.Info("No task definitions to clean up", "keep", keep)
+		return nil
+	}
+	inactive, err := deregisterTaskDefinitions(ctx, client, candidates)
+	if err != nil {
+		return err
+	}
+	var failures []string
+	for start := 0; start < len(inactive); start += maxDeleteTaskDefinitions {
+		var arns []string
+		for _, rev := range inactive[start:min(start+maxDeleteTaskDefinitions, len(inactive))] {
+			arns = append(arns, rev.arn)
+		}
+		slog.Info("Deleting task definitions", "task_definitions", arns)
+		output, err := client.DeleteTaskDefinitions(ctx, arns)
+		if err != nil {
+			return err
+		}
+		for _, failure := range output.Failures {
+			failures = append(failures, aws.ToString(failure.Arn)+": "+aws.ToString(failure.Reason))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Errorf("failed to delete task definitions:\n%s", strings.Join(failures, "\n"))
+	}
+	slog.Info("Cleaned up task definitions", "count", len(inactive), "keep", keep)
+	return nil
+}
+
+var gcFlags = struct {
+	keep int
+}{}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Clean up old task definitions of a Prodvana Service",
+	Long: `Clean up old task definitions of a Prodvana Service.
+
+Task definitions tagged with the Prodvana Service ID are deregistered and deleted, except for the ones of the newest
+--keep versions in each task definition family, and the ones used by any deployment of any ECS service in the region,
+such as the ECS services of other release channels.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		return runGc(cmd.Context(), client, gcFlags.keep)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(gcCmd)
+
+	registerServiceFlags(gcCmd)
+	gcCmd.Flags().IntVar(&gcFlags.keep, "keep", 10, "Number of most recent versions whose task definitions are kept")
+}
@@ -0,0 +1,57 @@
+package awsecs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGcCandidates(t *testing.T) {
+	revisions := []taskDefinitionRevision{
+		{arn: "arn:task/a:5", family: "a", revision: 5, version: "v4"},
+		{arn: "arn:task/a:4", family: "a", revision: 4, version: "v3"},
+		{arn: "arn:task/a:3", family: "a", revision: 3, version: "v3"},
+		{arn: "arn:task/a:2", family: "a", revision: 2, version: "v2"},
+		{arn: "arn:task/a:1", family: "a", revision: 1, version: "v1"},
+		{arn: "arn:task/b:2", family: "b", revision: 2, version: "v2"},
+		{arn: "arn:task/b:1", family: "b", revision: 1, version: "v1"},
+	}
+	arns := func(revisions []taskDefinitionRevision) []string {
+		var arns []string
+		for _, rev := range revisions {
+			arns = append(arns, rev.arn)
+		}
+		return arns
+	}
+	for _, tc := range []struct {
+		name     string
+		keep     int
+		inUse    map[string]bool
+		expected []string
+	}{
+		{
+			name:     "keep one version per family",
+			keep:     1,
+			expected: []string{"arn:task/a:4", "arn:task/a:3", "arn:task/a:2", "arn:task/a:1", "arn:task/b:1"},
+		},
+		{
+			name:     "keep every revision of kept versions",
+			keep:     2,
+			expected: []string{"arn:task/a:2", "arn:task/a:1"},
+		},
+		{
+			name:     "keep task definitions in use",
+			keep:     1,
+			inUse:    map[string]bool{"arn:task/a:2": true, "arn:task/b:1": true},
+			expected: []string{"arn:task/a:4", "arn:task/a:3", "arn:task/a:1"},
+		},
+		{
+			name: "keep everything",
+			keep: 4,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, arns(gcCandidates(revisions, tc.keep, tc.inUse)))
+		})
+	}
+}
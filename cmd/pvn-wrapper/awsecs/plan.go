@@ -0,0 +1,301 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// exit code when the plan has changes, like terraform plan --detailed-exitcode
+	planChangesExitCode = 2
+	// stands in for the ARN of a task definition that apply would register
+	newTaskDefinitionArn = "(new task definition)"
+)
+
+const (
+	planActionCreate = "create"
+	planActionUpdate = "update"
+	planActionNone   = "none"
+)
+
+// planChange is a field that apply would change, with JSON values. Sensitive values are left out.
+type planChange struct {
+	Field     string          `json:"field"`
+	Live      json.RawMessage `json:"live,omitempty"`
+	Want      json.RawMessage `json:"want,omitempty"`
+	Sensitive bool            `json:"sensitive,omitempty"`
+}
+
+// ecsPlan is what aws-ecs apply would do, written as JSON with --plan-json-out.
+type ecsPlan struct {
+	Service string `json:"service"`
+	// one of create, update, none
+	Action string `json:"action"`
+	// the task definition the service would use, empty if a new one would be registered
+	TaskDefinitionArn      string       `json:"taskDefinitionArn,omitempty"`
+	RegisterTaskDefinition bool         `json:"registerTaskDefinition"`
+	ServiceChanges         []planChange `json:"serviceChanges"`
+	TaskDefinitionChanges  []planChange `json:"taskDefinitionChanges"`
+	// the redacted task definition apply would register, if any
+	TaskDefinition json.RawMessage `json:"taskDefinition,omitempty"`
+	// the redacted service spec apply would create or update the service with, unless only the task definition is updated
+	ServiceSpec json.RawMessage `json:"serviceSpec,omitempty"`
+}
+
+func planChanges(diffs []fieldDiff) []planChange {
+	changes := []planChange{}
+	for _, diff := range diffs {
+		change := planChange{Field: diff.field, Sensitive: diff.sensitive}
+		if !diff.sensitive {
+			change.Live = json.RawMessage(diff.live)
+			change.Want = json.RawMessage(diff.want)
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+// taskDefinitionInput converts a registered task definition to the input that would register it,
+// which has the same field names.
+func taskDefinitionInput(def *types.TaskDefinition) (*ecs.RegisterTaskDefinitionInput, error) {
+	content, err := json.Marshal(def)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal task definition")
+	}
+	var input ecs.RegisterTaskDefinitionInput
+	if err := json.Unmarshal(content, &input); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal task definition")
+	}
+	return &input, nil
+}
+
+// diffTaskDefinitions compares the service's current task definition with the one apply would use,
+// either the already registered taskArn, or the one in the file at taskDefPath.
+func diffTaskDefinitions(ctx context.Context, client ecsClient, liveArn, taskArn, taskDefPath string) ([]fieldDiff, error) {
+	liveDef, err := describeTaskDefinition(ctx, client, liveArn)
+	if err != nil {
+		return nil, err
+	}
+	want := &ecs.RegisterTaskDefinitionInput{}
+	if taskArn == newTaskDefinitionArn {
+		if err := readInput(taskDefPath, want); err != nil {
+			return nil, err
+		}
+	} else {
+		wantDef, err := describeTaskDefinition(ctx, client, taskArn)
+		if err != nil {
+			return nil, err
+		}
+		want, err = taskDefinitionInput(wantDef.TaskDefinition)
+		if err != nil {
+			return nil, err
+		}
+	}
+	d := &differ{}
+	d.compare("tags."+serviceVersionTagKey, tagsToMap(liveDef.Tags)[serviceVersionTagKey], commonFlags.pvnServiceVersion)
+	live := liveDef.TaskDefinition
+	if live == nil {
+		live = &types.TaskDefinition{}
+	}
+	return append(d.diffs, diffTaskDefinition(live, want)...), nil
+}
+
+// readRedacted reads a patched spec file for the plan, with sensitive values masked.
+func readRedacted(path string) (json.RawMessage, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	return cmdutil.RedactJSON(content), nil
+}
+
+// runPlan works out what apply would change, without changing anything.
+func runPlan(ctx context.Context, client ecsClient) (*ecsPlan, error) {
+	newTaskDefPath, err := patchTaskDefinition(commonFlags.taskDefinitionFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(newTaskDefPath) }()
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+	if err != nil {
+		return nil, err
+	}
+	existingArn, err := findTaskDefinition(ctx, client, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion, serviceOutput)
+	if err != nil {
+		return nil, err
+	}
+	plan := &ecsPlan{
+		Service:                commonFlags.ecsServiceName,
+		TaskDefinitionArn:      existingArn,
+		RegisterTaskDefinition: existingArn == "",
+		ServiceChanges:         []planChange{},
+		TaskDefinitionChanges:  []planChange{},
+	}
+	taskArn := existingArn
+	if taskArn == "" {
+		taskArn = newTaskDefinitionArn
+		plan.TaskDefinition, err = readRedacted(newTaskDefPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	missing := serviceMissing(serviceOutput)
+	if missing && commonFlags.updateTaskDefinitionOnly {
+		return nil, errors.Errorf("cannot update task definition only when ECS service does not exist. ECS service: %s", commonFlags.ecsServiceName)
+	}
+	var spec ecs.CreateServiceInput
+	if !commonFlags.updateTaskDefinitionOnly {
+		newServiceSpecPath, err := patchServiceSpec(commonFlags.serviceSpecFile, commonFlags.ecsServiceName, commonFlags.ecsClusterName, taskArn, commonFlags.pvnServiceId, !missing)
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = os.Remove(newServiceSpecPath) }()
+		if err := readInput(newServiceSpecPath, &spec); err != nil {
+			return nil, err
+		}
+		plan.ServiceSpec, err = readRedacted(newServiceSpecPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if missing {
+		plan.Action = planActionCreate
+		return plan, nil
+	}
+	svc := serviceOutput.Services[0]
+	liveArn := aws.ToString(svc.TaskDefinition)
+	var serviceDiffs []fieldDiff
+	if liveArn != taskArn {
+		serviceDiffs = append(serviceDiffs, fieldDiff{field: "taskDefinition", live: jsonString(liveArn), want: jsonString(taskArn)})
+		taskDefDiffs, err := diffTaskDefinitions(ctx, client, liveArn, taskArn, newTaskDefPath)
+		if err != nil {
+			return nil, err
+		}
+		plan.TaskDefinitionChanges = planChanges(taskDefDiffs)
+	}
+	if !commonFlags.updateTaskDefinitionOnly {
+		serviceDiffs = append(serviceDiffs, diffService(svc, &spec)...)
+	}
+	plan.ServiceChanges = planChanges(serviceDiffs)
+	plan.Action = planActionNone
+	if len(plan.ServiceChanges) > 0 || len(plan.TaskDefinitionChanges) > 0 {
+		plan.Action = planActionUpdate
+	}
+	return plan, nil
+}
+
+func writeChanges(w io.Writer, changes []planChange) {
+	for _, change := range changes {
+		if change.Sensitive {
+			_, _ = fmt.Fprintf(w, "  ~ %s: (sensitive value changed)\n", change.Field)
+		} else {
+			_, _ = fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Field, change.Live, change.Want)
+		}
+	}
+}
+
+// render writes the plan in a human-readable form.
+func (p *ecsPlan) render(w io.Writer) {
+	switch p.Action {
+	case planActionNone:
+		_, _ = fmt.Fprintf(w, "No changes. ECS service %s is up to date.\n", p.Service)
+		return
+	case planActionCreate:
+		_, _ = fmt.Fprintf(w, "ECS service %s will be created.\n", p.Service)
+	case planActionUpdate:
+		_, _ = fmt.Fprintf(w, "ECS service %s will be updated.\n", p.Service)
+	}
+	if p.RegisterTaskDefinition {
+		_, _ = fmt.Fprintln(w, "A new task definition will be registered.")
+	} else {
+		_, _ = fmt.Fprintf(w, "Task definition %s will be used.\n", p.TaskDefinitionArn)
+	}
+	if len(p.ServiceChanges) > 0 {
+		_, _ = fmt.Fprintln(w, "\nService changes:")
+		writeChanges(w, p.ServiceChanges)
+	}
+	if len(p.TaskDefinitionChanges) > 0 {
+		_, _ = fmt.Fprintln(w, "\nTask definition changes:")
+		writeChanges(w, p.TaskDefinitionChanges)
+	}
+	if len(p.TaskDefinition) > 0 {
+		_, _ = fmt.Fprintf(w, "\nTask definition to register:\n%s\n", p.TaskDefinition)
+	}
+	if len(p.ServiceSpec) > 0 {
+		_, _ = fmt.Fprintf(w, "\nService spec:\n%s\n", p.ServiceSpec)
+	}
+}
+
+// exitCode returns the exit code pvn-wrapper should exit with for the plan.
+// Changes only exit with planChangesExitCode with detailedExitcode, so that a plan with changes does not fail by default.
+func (p *ecsPlan) exitCode(detailedExitcode bool) int {
+	if p.Action == planActionNone || !detailedExitcode {
+		return 0
+	}
+	return planChangesExitCode
+}
+
+var planFlags = struct {
+	planJsonOut      string
+	detailedExitcode bool
+}{}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show what apply would change in an ECS service",
+	Long: `Show what apply would change in an ECS service.
+
+Takes the same flags as apply, and compares the task definition and service spec apply would use with the live
+service and its task definition. Only the settings present in the spec files are compared. The changes are printed
+in a human-readable form together with the task definition and service spec apply would use, and written as JSON
+with --plan-json-out. Values of environment variables and other sensitive fields are left out.
+
+With --detailed-exitcode, exits with 0, 1, or 2, like terraform plan --detailed-exitcode.
+Otherwise, exits with 0 whether there are changes or not.
+
+0 - No changes detected
+1 - Unknown error
+2 - Changes detected
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		plan, err := runPlan(cmd.Context(), client)
+		if err != nil {
+			return err
+		}
+		plan.render(os.Stdout)
+		if planFlags.planJsonOut != "" {
+			content, err := json.MarshalIndent(plan, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal plan")
+			}
+			if err := os.WriteFile(planFlags.planJsonOut, content, 0o644); err != nil {
+				return errors.Wrapf(err, "failed to write %s", planFlags.planJsonOut)
+			}
+		}
+		cmdutil.Exit(plan.exitCode(planFlags.detailedExitcode))
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(planCmd)
+
+	registerCommonFlags(planCmd)
+	planCmd.Flags().StringVar(&planFlags.planJsonOut, "plan-json-out", "", "Path to write the plan to as JSON")
+	planCmd.Flags().BoolVar(&planFlags.detailedExitcode, "detailed-exitcode", false, "Exit with 2 if there are changes, like terraform plan --detailed-exitcode")
+}
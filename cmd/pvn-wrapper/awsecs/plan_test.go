@@ -0,0 +1,161 @@
+package awsecs
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunPlan(t *testing.T) {
+	liveTaskDefinition := cmdutil.FakeCall{
+		Args: []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/a:1"},
+		Stdout: `{
+			"taskDefinition": {"taskDefinitionArn": "arn:task/a:1", "containerDefinitions": [{"name": "app", "image": "app:v1", "environment": [{"name": "TOKEN", "value": "old"}]}]},
+			"tags": [{"key": "pvn:id", "value": "svc-id"}, {"key": "pvn:version", "value": "svc-v0"}]
+		}`,
+	}
+	// the patched task definition, with the environment redacted
+	taskDefinitionToRegister := `
+Task definition to register:
+{
+  "containerDefinitions": [
+    {
+      "environment": [
+        {
+          "name": "TOKEN",
+          "value": "<redacted>"
+        }
+      ],
+      "image": "app:v2",
+      "name": "app"
+    }
+  ],
+  "family": "my-family",
+  "tags": [
+    {
+      "key": "pvn:id",
+      "value": "svc-id"
+    },
+    {
+      "key": "pvn:version",
+      "value": "svc-v1"
+    }
+  ]
+}
+`
+	for _, tc := range []struct {
+		name                     string
+		updateTaskDefinitionOnly bool
+		calls                    []cmdutil.FakeCall
+		expectedExitCode         int
+		expectedOutput           string
+	}{
+		{
+			name: "create",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: serviceMissingOutput},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": []}`},
+			},
+			expectedExitCode: planChangesExitCode,
+			expectedOutput: "ECS service my-service will be created.\nA new task definition will be registered.\n" + taskDefinitionToRegister + `
+Service spec:
+{
+  "cluster": "my-cluster",
+  "desiredCount": 2,
+  "tags": [
+    {
+      "key": "pvn:id",
+      "value": "svc-id"
+    }
+  ],
+  "taskDefinition": "(new task definition)"
+}
+`,
+		},
+		{
+			name: "no changes",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 2, "taskDefinition": "arn:task/a:1"}]}`},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/a:1"}]}`},
+				describeTaskDefinitionCall("arn:task/a:1", "svc-id", "svc-v1"),
+			},
+			expectedOutput: "No changes. ECS service my-service is up to date.\n",
+		},
+		{
+			name: "new task definition",
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 3, "taskDefinition": "arn:task/a:1"}]}`},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": []}`},
+				liveTaskDefinition,
+			},
+			expectedExitCode: planChangesExitCode,
+			expectedOutput: `ECS service my-service will be updated.
+A new task definition will be registered.
+
+Service changes:
+  ~ taskDefinition: "arn:task/a:1" -> "(new task definition)"
+  ~ desiredCount: 3 -> 2
+
+Task definition changes:
+  ~ tags.pvn:version: "svc-v0" -> "svc-v1"
+  ~ containerDefinitions[app].image: "app:v1" -> "app:v2"
+  ~ containerDefinitions[app].environment: (sensitive value changed)
+` + taskDefinitionToRegister + `
+Service spec:
+{
+  "cluster": "my-cluster",
+  "desiredCount": 2,
+  "taskDefinition": "(new task definition)"
+}
+`,
+		},
+		{
+			name:                     "registered task definition",
+			updateTaskDefinitionOnly: true,
+			calls: []cmdutil.FakeCall{
+				{Args: describeServicesArgs, Stdout: `{"services": [{"status": "ACTIVE", "desiredCount": 3, "taskDefinition": "arn:task/a:1"}]}`},
+				{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/a:2"}]}`},
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v1"),
+				liveTaskDefinition,
+				{
+					Args: []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/a:2"},
+					Stdout: `{
+						"taskDefinition": {"taskDefinitionArn": "arn:task/a:2", "containerDefinitions": [{"name": "app", "image": "app:v1", "environment": [{"name": "TOKEN", "value": "old"}]}]},
+						"tags": [{"key": "pvn:id", "value": "svc-id"}, {"key": "pvn:version", "value": "svc-v1"}]
+					}`,
+				},
+			},
+			expectedExitCode: planChangesExitCode,
+			expectedOutput: `ECS service my-service will be updated.
+Task definition arn:task/a:2 will be used.
+
+Service changes:
+  ~ taskDefinition: "arn:task/a:1" -> "arn:task/a:2"
+
+Task definition changes:
+  ~ tags.pvn:version: "svc-v0" -> "svc-v1"
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, tc.updateTaskDefinitionOnly)
+			require.NoError(t, os.WriteFile(commonFlags.taskDefinitionFile, []byte(`{
+				"family": "my-family",
+				"containerDefinitions": [{"name": "app", "image": "app:v2", "environment": [{"name": "TOKEN", "value": "new"}]}]
+			}`), 0o600))
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			plan, err := runPlan(context.Background(), newCliClient(runner))
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+			require.Equal(t, tc.expectedExitCode, plan.exitCode(true))
+			require.Equal(t, 0, plan.exitCode(false))
+			var out bytes.Buffer
+			plan.render(&out)
+			require.Equal(t, tc.expectedOutput, out.String())
+		})
+	}
+}
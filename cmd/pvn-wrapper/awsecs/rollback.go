@@ -0,0 +1,164 @@
+package awsecs
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+// rollbackCandidates returns the task definitions to roll back to, in order of preference.
+// With toVersion, these are the ones tagged with that version in the current family, followed by the ones in other
+// families if allowOtherFamilies is set. Other families may be the ones of other release channels, with their images
+// and config, so they are never used otherwise.
+// Without toVersion, they are the older revisions in the current family with a different version than the current one.
+func rollbackCandidates(revisions []taskDefinitionRevision, current taskDefinitionRevision, toVersion string, allowOtherFamilies bool) []taskDefinitionRevision {
+	var candidates, otherFamilies []taskDefinitionRevision
+	for _, rev := range revisions {
+		switch {
+		case toVersion != "" && rev.version != toVersion:
+		case toVersion != "" && rev.family != current.family:
+			if allowOtherFamilies {
+				otherFamilies = append(otherFamilies, rev)
+			}
+		case toVersion != "":
+			candidates = append(candidates, rev)
+		case rev.family == current.family && rev.revision < current.revision && rev.version != current.version:
+			candidates = append(candidates, rev)
+		}
+	}
+	return append(candidates, otherFamilies...)
+}
+
+// findRollbackTarget returns the task definition to roll back to from the current one,
+// skipping deregistered task definitions, which services cannot be updated to.
+func findRollbackTarget(ctx context.Context, client ecsClient, currentArn, toVersion string, allowOtherFamilies bool) (string, error) {
+	currentDef, err := describeTaskDefinition(ctx, client, currentArn)
+	if err != nil {
+		return "", err
+	}
+	tags := tagsToMap(currentDef.Tags)
+	if toVersion == "" && tags[serviceIdTagKey] != commonFlags.pvnServiceId {
+		return "", errors.Errorf("current task definition %s was not deployed for Prodvana Service %s, pass --to-version to pick the version to roll back to", currentArn, commonFlags.pvnServiceId)
+	}
+	family, revision, err := parseTaskDefinitionArn(currentArn)
+	if err != nil {
+		return "", err
+	}
+	current := taskDefinitionRevision{
+		arn:      currentArn,
+		family:   family,
+		revision: revision,
+		version:  tags[serviceVersionTagKey],
+	}
+	slog.Info("Current task definition", "task_definition", currentArn, "version", current.version)
+	revisions, err := listTaskDefinitionRevisions(ctx, client, commonFlags.pvnServiceId)
+	if err != nil {
+		return "", err
+	}
+	for _, candidate := range rollbackCandidates(revisions, current, toVersion, allowOtherFamilies) {
+		if candidate.arn == currentArn {
+			return candidate.arn, nil
+		}
+		def, err := describeTaskDefinition(ctx, client, candidate.arn)
+		if err != nil {
+			return "", err
+		}
+		if def.TaskDefinition != nil && def.TaskDefinition.Status == types.TaskDefinitionStatusInactive {
+			slog.Info("Skipping deregistered task definition", "task_definition", candidate.arn, "version", candidate.version)
+			continue
+		}
+		if candidate.family != current.family {
+			slog.Warn("Rolling back to a task definition of another family", "task_definition", candidate.arn, "family", candidate.family, "current_family", current.family)
+		}
+		return candidate.arn, nil
+	}
+	if toVersion != "" {
+		if !allowOtherFamilies && len(rollbackCandidates(revisions, current, toVersion, true)) > 0 {
+			return "", errors.Errorf("no active task definition found for version %s in family %s, only in other families, pass --allow-other-families to use them", toVersion, current.family)
+		}
+		return "", errors.Errorf("no active task definition found for version %s", toVersion)
+	}
+	return "", errors.Errorf("no active task definition found for a version before %s", current.version)
+}
+
+// runRollback updates the service to a previous task definition and returns the exit code pvn-wrapper should exit with.
+func runRollback(ctx context.Context, client ecsClient) (int, error) {
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+	if err != nil {
+		return 0, err
+	}
+	if serviceMissing(serviceOutput) {
+		return 0, errors.Errorf("ECS service %s does not exist", commonFlags.ecsServiceName)
+	}
+	currentArn := aws.ToString(serviceOutput.Services[0].TaskDefinition)
+	taskArn, err := findRollbackTarget(ctx, client, currentArn, rollbackFlags.toVersion, rollbackFlags.allowOtherFamilies)
+	if err != nil {
+		return 0, err
+	}
+	if taskArn == currentArn {
+		slog.Info("Service is already using the task definition to roll back to", "task_definition", taskArn)
+	} else {
+		slog.Info("Rolling back service", "from_task_definition", currentArn, "task_definition", taskArn)
+		if err := client.UpdateService(ctx, commonFlags.ecsClusterName, commonFlags.ecsServiceName, taskArn, ""); err != nil {
+			return 0, err
+		}
+	}
+	if !rollbackFlags.wait {
+		return 0, nil
+	}
+	return waitForRollout(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName, taskArn, rollbackFlags.waitOptions)
+}
+
+var rollbackFlags = struct {
+	toVersion          string
+	allowOtherFamilies bool
+	wait               bool
+	waitOptions
+}{}
+
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "Roll an ECS service back to a previous task definition",
+	Long: `Roll an ECS service back to a previous task definition.
+
+By default, the service is updated to the newest task definition registered for the Prodvana Service before the one
+it currently uses, with a different pvn:version tag. If ECS already rolled the service back, e.g. because the
+deployment circuit breaker tripped, this goes back one more version, so pass --to-version to pick the version instead.
+Only task definitions of the family the service currently uses are picked. Other families, e.g. the ones of other
+release channels, have their own images and config, so they are only used for --to-version with --allow-other-families.
+Only the task definition is changed, other service settings are left as is.
+
+With --wait, pvn-wrapper waits for the rollback to finish rolling out, and exits with 2 if it failed,
+or 3 if it did not finish within --wait-timeout.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		exitCode, err := runRollback(cmd.Context(), client)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			cmdutil.Exit(exitCode)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(rollbackCmd)
+
+	registerServiceFlags(rollbackCmd)
+	rollbackCmd.Flags().StringVar(&rollbackFlags.toVersion, "to-version", "", "Prodvana Service version to roll back to, defaults to the version before the current one")
+	rollbackCmd.Flags().BoolVar(&rollbackFlags.allowOtherFamilies, "allow-other-families", false, "With --to-version, roll back to a task definition of another family if the current family has none for the version")
+	rollbackCmd.Flags().BoolVar(&rollbackFlags.wait, "wait", false, "Wait for the rollback to complete or fail")
+	registerWaitFlags(rollbackCmd, &rollbackFlags.waitOptions, "the rollback")
+}
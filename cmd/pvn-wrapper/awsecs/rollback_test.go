@@ -0,0 +1,167 @@
+package awsecs
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunRollback(t *testing.T) {
+	var (
+		serviceOutput = cmdutil.FakeCall{
+			Args:   describeServicesArgs,
+			Stdout: `{"services": [{"status": "ACTIVE", "taskDefinition": "arn:task/a:4"}], "failures": []}`,
+		}
+		listRevisions = cmdutil.FakeCall{
+			Args: []string{
+				"resourcegroupstaggingapi", "get-resources",
+				"--resource-type-filters", "ecs:task-definition",
+				"--tag-filters", "Key=pvn:id,Values=svc-id",
+			},
+			Stdout: `{"ResourceTagMappingList": [
+				{"ResourceARN": "arn:task/a:1", "Tags": [{"Key": "pvn:id", "Value": "svc-id"}, {"Key": "pvn:version", "Value": "svc-v1"}]},
+				{"ResourceARN": "arn:task/b:7", "Tags": [{"Key": "pvn:id", "Value": "svc-id"}, {"Key": "pvn:version", "Value": "svc-v1"}]},
+				{"ResourceARN": "arn:task/a:4", "Tags": [{"Key": "pvn:id", "Value": "svc-id"}, {"Key": "pvn:version", "Value": "svc-v3"}]},
+				{"ResourceARN": "arn:task/a:3", "Tags": [{"Key": "pvn:id", "Value": "svc-id"}, {"Key": "pvn:version", "Value": "svc-v3"}]},
+				{"ResourceARN": "arn:task/a:2", "Tags": [{"Key": "pvn:id", "Value": "svc-id"}, {"Key": "pvn:version", "Value": "svc-v2"}]}
+			]}`,
+		}
+		inactive = func(arn string) cmdutil.FakeCall {
+			return cmdutil.FakeCall{
+				Args:   []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", arn},
+				Stdout: `{"taskDefinition": {"taskDefinitionArn": "` + arn + `", "status": "INACTIVE"}}`,
+			}
+		}
+		updateService = func(arn string) cmdutil.FakeCall {
+			return cmdutil.FakeCall{Args: []string{
+				"ecs", "update-service", "--service", "my-service",
+				"--propagate-tags=TASK_DEFINITION", "--cluster", "my-cluster", "--task-definition", arn,
+			}}
+		}
+	)
+	for _, tc := range []struct {
+		name               string
+		toVersion          string
+		allowOtherFamilies bool
+		calls              []cmdutil.FakeCall
+		expectedErr        string
+	}{
+		{
+			name: "previous version",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				describeTaskDefinitionCall("arn:task/a:2", "svc-id", "svc-v2"),
+				updateService("arn:task/a:2"),
+			},
+		},
+		{
+			name: "previous version was deregistered",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				inactive("arn:task/a:2"),
+				describeTaskDefinitionCall("arn:task/a:1", "svc-id", "svc-v1"),
+				updateService("arn:task/a:1"),
+			},
+		},
+		{
+			name: "no previous version",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				inactive("arn:task/a:2"),
+				inactive("arn:task/a:1"),
+			},
+			expectedErr: "no active task definition found for a version before svc-v3",
+		},
+		{
+			name:      "to version in the current family",
+			toVersion: "svc-v1",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				describeTaskDefinitionCall("arn:task/a:1", "svc-id", "svc-v1"),
+				updateService("arn:task/a:1"),
+			},
+		},
+		{
+			name:      "to version only in another family",
+			toVersion: "svc-v1",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				inactive("arn:task/a:1"),
+			},
+			expectedErr: "no active task definition found for version svc-v1 in family a, only in other families, pass --allow-other-families",
+		},
+		{
+			name:               "to version in another family allowed",
+			toVersion:          "svc-v1",
+			allowOtherFamilies: true,
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+				inactive("arn:task/a:1"),
+				describeTaskDefinitionCall("arn:task/b:7", "svc-id", "svc-v1"),
+				updateService("arn:task/b:7"),
+			},
+		},
+		{
+			name:      "to current version",
+			toVersion: "svc-v3",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+			},
+		},
+		{
+			name:      "to unknown version",
+			toVersion: "svc-v9",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "svc-id", "svc-v3"),
+				listRevisions,
+			},
+			expectedErr: "no active task definition found for version svc-v9",
+		},
+		{
+			name: "current task definition from another service",
+			calls: []cmdutil.FakeCall{
+				serviceOutput,
+				describeTaskDefinitionCall("arn:task/a:4", "other-id", "svc-v3"),
+			},
+			expectedErr: "pass --to-version",
+		},
+		{
+			name:        "service missing",
+			calls:       []cmdutil.FakeCall{{Args: describeServicesArgs, Stdout: serviceMissingOutput}},
+			expectedErr: "ECS service my-service does not exist",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, false)
+			prevFlags := rollbackFlags
+			t.Cleanup(func() { rollbackFlags = prevFlags })
+			rollbackFlags.toVersion = tc.toVersion
+			rollbackFlags.allowOtherFamilies = tc.allowOtherFamilies
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			_, err := runRollback(context.Background(), newCliClient(runner))
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
@@ -4,7 +4,10 @@ import (
 	"github.com/spf13/cobra"
 )
 
-var awsPath string
+var (
+	awsPath   string
+	awsClient string
+)
 
 var RootCmd = &cobra.Command{
 	Use:   "aws-ecs <subcommand>",
@@ -16,5 +19,6 @@ pvn-wrapper awsecs apply ...
 }
 
 func init() {
-	RootCmd.Flags().StringVar(&awsPath, "aws-path", "aws", "Path to aws binary")
+	RootCmd.PersistentFlags().StringVar(&awsPath, "aws-path", "aws", "Path to aws binary, used with --aws-client=cli")
+	RootCmd.PersistentFlags().StringVar(&awsClient, "aws-client", awsClientCli, "How to call AWS APIs, one of cli, sdk. cli runs the aws binary and is always used when recording or replaying calls.")
 }
@@ -0,0 +1,389 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+// startedBy of the tasks run by run-task, to tell them apart from the service's tasks
+const runTaskStartedBy = "pvn-wrapper"
+
+const (
+	runTaskStatusStopped   = "stopped"
+	runTaskStatusTimedOut  = "timed_out"
+	runTaskStatusDidNotRun = "did_not_run"
+)
+
+// runTaskResult is how the task ended, written as JSON with --result-json-out. Unlike the exit code of pvn-wrapper,
+// it tells the exit code of a container apart from a timeout or a failure of pvn-wrapper itself.
+type runTaskResult struct {
+	TaskArn string `json:"taskArn"`
+	// one of stopped, timed_out, did_not_run
+	Status string `json:"status"`
+	// the container whose exit code is reported, empty if all essential containers exited with 0
+	Container     string `json:"container,omitempty"`
+	ExitCode      int    `json:"exitCode"`
+	StopCode      string `json:"stopCode,omitempty"`
+	StoppedReason string `json:"stoppedReason,omitempty"`
+	// whether pvn-wrapper stopped the task because it did not stop within --wait-timeout
+	StoppedOnTimeout bool `json:"stoppedOnTimeout,omitempty"`
+}
+
+// exitCode returns the exit code pvn-wrapper should exit with for the result.
+func (r *runTaskResult) exitCode() int {
+	if r.Status == runTaskStatusTimedOut {
+		return waitTimedOutExitCode
+	}
+	return r.ExitCode
+}
+
+// placementKeys are the run-task settings taken from the service spec file when the service does not exist yet.
+var placementKeys = []string{"networkConfiguration", "launchType", "capacityProviderStrategy", "platformVersion"}
+
+type awsVpcConfigurationJson struct {
+	Subnets        []string `json:"subnets"`
+	SecurityGroups []string `json:"securityGroups,omitempty"`
+	AssignPublicIp string   `json:"assignPublicIp,omitempty"`
+}
+
+// servicePlacement returns where the service runs its tasks, in the format accepted by aws ecs run-task --cli-input-json.
+func servicePlacement(svc types.Service) map[string]interface{} {
+	placement := map[string]interface{}{}
+	if svc.NetworkConfiguration != nil && svc.NetworkConfiguration.AwsvpcConfiguration != nil {
+		vpc := svc.NetworkConfiguration.AwsvpcConfiguration
+		placement["networkConfiguration"] = map[string]interface{}{
+			"awsvpcConfiguration": awsVpcConfigurationJson{
+				Subnets:        vpc.Subnets,
+				SecurityGroups: vpc.SecurityGroups,
+				AssignPublicIp: string(vpc.AssignPublicIp),
+			},
+		}
+	}
+	if len(svc.CapacityProviderStrategy) > 0 {
+		placement["capacityProviderStrategy"] = capacityProviderKeys(svc.CapacityProviderStrategy)
+	} else if svc.LaunchType != "" {
+		placement["launchType"] = svc.LaunchType
+	}
+	if svc.PlatformVersion != nil {
+		placement["platformVersion"] = aws.ToString(svc.PlatformVersion)
+	}
+	return placement
+}
+
+// specPlacement returns where the service would run its tasks according to its spec file.
+func specPlacement(serviceSpecPath string) (map[string]interface{}, error) {
+	serviceSpec, err := os.ReadFile(serviceSpecPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read service spec file")
+	}
+	var untypedDef map[string]interface{}
+	if err := json.Unmarshal(serviceSpec, &untypedDef); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal service spec file: %s", string(serviceSpec))
+	}
+	placement := map[string]interface{}{}
+	for _, key := range placementKeys {
+		if value, ok := untypedDef[key]; ok {
+			placement[key] = value
+		}
+	}
+	return placement, nil
+}
+
+// writeRunTaskSpec writes the input of run-task to a temp file and returns its path.
+func writeRunTaskSpec(placement map[string]interface{}, taskArn, overridesPath string) (string, error) {
+	spec := map[string]interface{}{
+		"cluster":        commonFlags.ecsClusterName,
+		"taskDefinition": taskArn,
+		"count":          1,
+		"startedBy":      runTaskStartedBy,
+		"propagateTags":  "TASK_DEFINITION",
+	}
+	for k, v := range placement {
+		spec[k] = v
+	}
+	if overridesPath != "" {
+		overrides, err := os.ReadFile(overridesPath)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to read overrides file")
+		}
+		var untypedOverrides map[string]interface{}
+		if err := json.Unmarshal(overrides, &untypedOverrides); err != nil {
+			return "", errors.Wrapf(err, "failed to unmarshal overrides file: %s", string(overrides))
+		}
+		spec["overrides"] = untypedOverrides
+	}
+	content, err := json.Marshal(spec)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal")
+	}
+	// Printing run-task input to help with debugging.
+	slog.Info("Run task input", "run_task_input", string(cmdutil.RedactJSON(content)))
+
+	tempFile, err := os.CreateTemp("", "ecs-run-task")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make tempfile")
+	}
+	if _, err := tempFile.Write(content); err != nil {
+		return "", errors.Wrap(err, "failed to write to tempfile")
+	}
+	if err := tempFile.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to close tempfile")
+	}
+	return tempFile.Name(), nil
+}
+
+// waitForTask polls the task until it stopped and returns it, or nil if it did not stop within the timeout.
+func waitForTask(ctx context.Context, client ecsClient, cluster, taskArn string, opts waitOptions) (*types.Task, error) {
+	var task *types.Task
+	var lastStatus string
+	stopped, err := waitUntil(ctx, opts, func(ctx context.Context) (bool, error) {
+		tasksOutput, err := client.DescribeTasks(ctx, cluster, []string{taskArn})
+		if err != nil {
+			return false, err
+		}
+		if len(tasksOutput.Tasks) != 1 {
+			return false, errors.Errorf("task %s not found", taskArn)
+		}
+		task = &tasksOutput.Tasks[0]
+		if status := aws.ToString(task.LastStatus); status != lastStatus {
+			slog.Info("Task status", "task", arnId(taskArn), "status", status)
+			lastStatus = status
+		}
+		return lastStatus == "STOPPED", nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed waiting for task to stop")
+	}
+	if !stopped {
+		return nil, nil
+	}
+	return task, nil
+}
+
+// logContainerLogs logs where the CloudWatch logs of the task's containers are, for containers using the awslogs driver.
+func logContainerLogs(taskDef *types.TaskDefinition, taskArn string) {
+	if taskDef == nil {
+		return
+	}
+	for _, container := range taskDef.ContainerDefinitions {
+		logConfig := container.LogConfiguration
+		if logConfig == nil || logConfig.LogDriver != types.LogDriverAwslogs || logConfig.Options["awslogs-stream-prefix"] == "" {
+			continue
+		}
+		slog.Info(
+			"Container logs",
+			"container", aws.ToString(container.Name),
+			"log_group", logConfig.Options["awslogs-group"],
+			"log_stream", strings.Join([]string{logConfig.Options["awslogs-stream-prefix"], aws.ToString(container.Name), arnId(taskArn)}, "/"),
+		)
+	}
+}
+
+// taskExitCode returns the exit code of the container, or if empty, the container with the first non-zero exit code
+// of the essential containers and its exit code. Containers that never ran have no exit code, which is an error.
+func taskExitCode(task *types.Task, taskDef *types.TaskDefinition, containerName string) (string, int, error) {
+	exitCodes := map[string]*int32{}
+	for _, container := range task.Containers {
+		name := aws.ToString(container.Name)
+		exitCodes[name] = container.ExitCode
+		if container.ExitCode != nil {
+			slog.Info("Container exited", "container", name, "exit_code", *container.ExitCode, "reason", aws.ToString(container.Reason))
+		} else {
+			slog.Warn("Container has no exit code", "container", name, "reason", aws.ToString(container.Reason))
+		}
+	}
+	var names []string
+	if containerName != "" {
+		names = []string{containerName}
+	} else if taskDef != nil {
+		for _, container := range taskDef.ContainerDefinitions {
+			if container.Essential == nil || *container.Essential {
+				names = append(names, aws.ToString(container.Name))
+			}
+		}
+	}
+	for _, name := range names {
+		exitCode, ok := exitCodes[name]
+		if !ok || exitCode == nil {
+			return name, 0, errors.Errorf("container %s did not run. %s", name, stoppedTaskMessage(*task))
+		}
+		if *exitCode != 0 {
+			return name, int(*exitCode), nil
+		}
+	}
+	return "", 0, nil
+}
+
+// stopTask stops a task pvn-wrapper is no longer waiting for, so that it does not keep running unattended.
+// A failure is only logged, as the task might have stopped in the meantime.
+func stopTask(ctx context.Context, client ecsClient, taskArn, reason string) bool {
+	slog.Info("Stopping task", "task", taskArn, "reason", reason)
+	if err := client.StopTask(ctx, commonFlags.ecsClusterName, taskArn, reason); err != nil {
+		slog.Error("Failed to stop task", "task", taskArn, "error", err)
+		return false
+	}
+	return true
+}
+
+// runRunTask runs a one-off task from the Prodvana Service's task definition and returns how it ended.
+// Once the task started, the result is returned even with an error, e.g. if its container did not run.
+func runRunTask(ctx context.Context, client ecsClient) (*runTaskResult, error) {
+	newTaskDefPath, err := patchTaskDefinition(commonFlags.taskDefinitionFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(newTaskDefPath) }()
+	serviceOutput, err := describeService(ctx, client, commonFlags.ecsClusterName, commonFlags.ecsServiceName)
+	if err != nil {
+		return nil, err
+	}
+	var placement map[string]interface{}
+	if !serviceMissing(serviceOutput) {
+		placement = servicePlacement(serviceOutput.Services[0])
+	} else if commonFlags.serviceSpecFile != "" {
+		slog.Info("ECS service does not exist, running the task as configured in the service spec file")
+		placement, err = specPlacement(commonFlags.serviceSpecFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, errors.Errorf("ECS service %s does not exist, pass --service-spec-file to run the task as configured there", commonFlags.ecsServiceName)
+	}
+	taskDefArn, err := registerTaskDefinitionIfNeeded(ctx, client, newTaskDefPath, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion, serviceOutput)
+	if err != nil {
+		return nil, err
+	}
+	specPath, err := writeRunTaskSpec(placement, taskDefArn, runTaskFlags.overridesFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(specPath) }()
+	runOutput, err := client.RunTask(ctx, specPath)
+	if err != nil {
+		return nil, err
+	}
+	if len(runOutput.Failures) > 0 {
+		var reasons []string
+		for _, failure := range runOutput.Failures {
+			reasons = append(reasons, aws.ToString(failure.Reason)+" "+aws.ToString(failure.Detail))
+		}
+		return nil, errors.Errorf("failed to run task: %s", strings.Join(reasons, ", "))
+	}
+	if len(runOutput.Tasks) != 1 {
+		return nil, errors.Errorf("unexpected number of tasks started: %d", len(runOutput.Tasks))
+	}
+	taskArn := aws.ToString(runOutput.Tasks[0].TaskArn)
+	slog.Info("Started task", "task", taskArn, "task_definition", taskDefArn)
+	def, err := describeTaskDefinition(ctx, client, taskDefArn)
+	if err != nil {
+		return nil, err
+	}
+	logContainerLogs(def.TaskDefinition, taskArn)
+	task, err := waitForTask(ctx, client, commonFlags.ecsClusterName, taskArn, runTaskFlags.waitOptions)
+	if err != nil {
+		if ctx.Err() != nil && runTaskFlags.stopOnTimeout {
+			stopTask(context.WithoutCancel(ctx), client, taskArn, "pvn-wrapper was canceled")
+		}
+		return nil, err
+	}
+	result := &runTaskResult{TaskArn: taskArn}
+	if task == nil {
+		result.Status = runTaskStatusTimedOut
+		if runTaskFlags.stopOnTimeout {
+			slog.Error("Timed out waiting for task to stop", "task", taskArn, "timeout", runTaskFlags.timeout)
+			result.StoppedOnTimeout = stopTask(ctx, client, taskArn, "pvn-wrapper timed out waiting for the task to stop")
+		} else {
+			slog.Error("Timed out waiting for task to stop, it is still running", "task", taskArn, "timeout", runTaskFlags.timeout)
+		}
+		return result, nil
+	}
+	slog.Info("Task stopped", "task", taskArn, "stop_code", string(task.StopCode), "reason", aws.ToString(task.StoppedReason))
+	result.Status = runTaskStatusStopped
+	result.StopCode = string(task.StopCode)
+	result.StoppedReason = aws.ToString(task.StoppedReason)
+	result.Container, result.ExitCode, err = taskExitCode(task, def.TaskDefinition, runTaskFlags.containerName)
+	if err != nil {
+		result.Status = runTaskStatusDidNotRun
+		return result, err
+	}
+	return result, nil
+}
+
+var runTaskFlags = struct {
+	overridesFile string
+	containerName string
+	stopOnTimeout bool
+	resultJsonOut string
+	waitOptions
+}{}
+
+var runTaskCmd = &cobra.Command{
+	Use:   "run-task",
+	Short: "Run a one-off ECS task, e.g. a database migration",
+	Long: `Run a one-off ECS task, e.g. a database migration.
+
+The task definition of the Prodvana Service version is registered if needed, the same way as apply does it, and run
+once on the cluster with the ECS service's network configuration and launch type or capacity provider strategy.
+If the ECS service does not exist yet, these are taken from --service-spec-file instead.
+Container overrides, e.g. a different command, are read from --overrides-file, in the format of the overrides field
+accepted by aws ecs run-task --cli-input-json.
+
+pvn-wrapper waits for the task to stop and exits with the exit code of --container-name, or without it, with the first
+non-zero exit code of the essential containers. It exits with 3 if the task did not stop within --wait-timeout,
+and with 1 if the container did not run. Since a container can exit with these codes too, use --result-json-out to
+tell them apart. It is written as JSON with the task ARN, a status of stopped, timed_out, or did_not_run, and the
+container and its exit code.
+
+If the task does not stop within --wait-timeout or pvn-wrapper is canceled, the task is stopped, unless
+--stop-on-timeout=false.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, err := newClient(cmd.Context())
+		if err != nil {
+			return err
+		}
+		result, err := runRunTask(cmd.Context(), client)
+		if result != nil && runTaskFlags.resultJsonOut != "" {
+			content, err := json.MarshalIndent(result, "", "  ")
+			if err != nil {
+				return errors.Wrap(err, "failed to marshal result")
+			}
+			if err := os.WriteFile(runTaskFlags.resultJsonOut, content, 0o644); err != nil {
+				return errors.Wrapf(err, "failed to write %s", runTaskFlags.resultJsonOut)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if exitCode := result.exitCode(); exitCode != 0 {
+			cmdutil.Exit(exitCode)
+		}
+		return nil
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(runTaskCmd)
+
+	registerServiceFlags(runTaskCmd)
+	runTaskCmd.Flags().StringVar(&commonFlags.taskDefinitionFile, "task-definition-file", "", "Path to ECS task definition file")
+	cmdutil.Must(runTaskCmd.MarkFlagRequired("task-definition-file"))
+	runTaskCmd.Flags().StringVar(&commonFlags.pvnServiceVersion, "pvn-service-version", "", "Prodvana Service Version")
+	cmdutil.Must(runTaskCmd.MarkFlagRequired("pvn-service-version"))
+	runTaskCmd.Flags().StringVar(&commonFlags.serviceSpecFile, "service-spec-file", "", "Path to ECS service spec file, used for the network configuration if the ECS service does not exist yet")
+	runTaskCmd.Flags().StringVar(&runTaskFlags.overridesFile, "overrides-file", "", "Path to a JSON file with the task overrides, e.g. {\"containerOverrides\": [{\"name\": \"app\", \"command\": [\"migrate\"]}]}")
+	runTaskCmd.Flags().StringVar(&runTaskFlags.containerName, "container-name", "", "Container whose exit code to exit with, defaults to the first non-zero exit code of the essential containers")
+	runTaskCmd.Flags().BoolVar(&runTaskFlags.stopOnTimeout, "stop-on-timeout", true, "Stop the task if it does not stop within --wait-timeout or pvn-wrapper is canceled")
+	runTaskCmd.Flags().StringVar(&runTaskFlags.resultJsonOut, "result-json-out", "", "Path to write how the task ended to as JSON, with the status, container, and exit code")
+	registerWaitFlags(runTaskCmd, &runTaskFlags.waitOptions, "the task")
+}
@@ -0,0 +1,219 @@
+package awsecs
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteRunTaskSpec(t *testing.T) {
+	setupCommonFlags(t, false)
+	var svc types.Service
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"launchType": "FARGATE",
+		"platformVersion": "LATEST",
+		"networkConfiguration": {"awsvpcConfiguration": {"subnets": ["subnet-a"], "securityGroups": ["sg-1"], "assignPublicIp": "DISABLED"}}
+	}`), &svc))
+	overridesPath := filepath.Join(t.TempDir(), "overrides.json")
+	require.NoError(t, os.WriteFile(overridesPath, []byte(`{"containerOverrides": [{"name": "app", "command": ["migrate"]}]}`), 0o600))
+	specPath, err := writeRunTaskSpec(servicePlacement(svc), "arn:task/a:1", overridesPath)
+	require.NoError(t, err)
+	defer func() { _ = os.Remove(specPath) }()
+	spec, err := os.ReadFile(specPath)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"cluster": "my-cluster",
+		"taskDefinition": "arn:task/a:1",
+		"count": 1,
+		"startedBy": "pvn-wrapper",
+		"propagateTags": "TASK_DEFINITION",
+		"launchType": "FARGATE",
+		"platformVersion": "LATEST",
+		"networkConfiguration": {"awsvpcConfiguration": {"subnets": ["subnet-a"], "securityGroups": ["sg-1"], "assignPublicIp": "DISABLED"}},
+		"overrides": {"containerOverrides": [{"name": "app", "command": ["migrate"]}]}
+	}`, string(spec))
+}
+
+func TestRunTaskResultExitCode(t *testing.T) {
+	require.Equal(t, 0, (&runTaskResult{Status: runTaskStatusStopped}).exitCode())
+	require.Equal(t, 3, (&runTaskResult{Status: runTaskStatusStopped, Container: "app", ExitCode: 3}).exitCode())
+	require.Equal(t, waitTimedOutExitCode, (&runTaskResult{Status: runTaskStatusTimedOut}).exitCode())
+}
+
+func TestRunRunTask(t *testing.T) {
+	const taskArn = "arn:aws:ecs:us-west-2:123456789012:task/my-cluster/abc123"
+	var (
+		serviceOutput = cmdutil.FakeCall{
+			Args:   describeServicesArgs,
+			Stdout: `{"services": [{"status": "ACTIVE", "taskDefinition": "arn:task/a:1", "launchType": "FARGATE"}]}`,
+		}
+		existingTaskDefinition = cmdutil.FakeCall{Args: getResourcesArgs, Stdout: `{"ResourceTagMappingList": [{"ResourceARN": "arn:task/a:1"}]}`}
+		runTask                = cmdutil.FakeCall{
+			Args:   []string{"ecs", "run-task", "--cli-input-json", "file://*"},
+			Stdout: `{"tasks": [{"taskArn": "` + taskArn + `", "lastStatus": "PROVISIONING"}], "failures": []}`,
+		}
+		describeTaskDefinition = cmdutil.FakeCall{
+			Args: []string{"ecs", "describe-task-definition", "--include=TAGS", "--task-definition", "arn:task/a:1"},
+			Stdout: `{"taskDefinition": {"taskDefinitionArn": "arn:task/a:1", "status": "ACTIVE", "containerDefinitions": [
+				{"name": "app", "logConfiguration": {"logDriver": "awslogs", "options": {"awslogs-group": "/ecs/app", "awslogs-stream-prefix": "ecs"}}},
+				{"name": "sidecar", "essential": false}
+			]}}`,
+		}
+		describeTasks = func(task string) cmdutil.FakeCall {
+			return cmdutil.FakeCall{
+				Args:   []string{"ecs", "describe-tasks", "--cluster", "my-cluster", "--tasks", taskArn},
+				Stdout: `{"tasks": [` + task + `]}`,
+			}
+		}
+		running = describeTasks(`{"taskArn": "` + taskArn + `", "lastStatus": "RUNNING"}`)
+	)
+	stopTask := cmdutil.FakeCall{Args: []string{"ecs", "stop-task", "--cluster", "my-cluster", "--task", taskArn, "--reason", "pvn-wrapper timed out waiting for the task to stop"}}
+	stopCanceledTask := cmdutil.FakeCall{Args: []string{"ecs", "stop-task", "--cluster", "my-cluster", "--task", taskArn, "--reason", "pvn-wrapper was canceled"}}
+	for _, tc := range []struct {
+		name          string
+		containerName string
+		leaveRunning  bool
+		timeout       time.Duration
+		// cancel the context once the task is seen running, like Ctrl-C or SIGTERM do
+		cancel         bool
+		calls          []cmdutil.FakeCall
+		expectedResult *runTaskResult
+		expectedErr    string
+	}{
+		{
+			name: "succeeded",
+			calls: []cmdutil.FakeCall{
+				serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition, running,
+				describeTasks(`{"taskArn": "` + taskArn + `", "lastStatus": "STOPPED", "stopCode": "EssentialContainerExited", "containers": [
+					{"name": "app", "exitCode": 0}, {"name": "sidecar", "exitCode": 137}
+				]}`),
+			},
+			expectedResult: &runTaskResult{TaskArn: taskArn, Status: runTaskStatusStopped, StopCode: "EssentialContainerExited"},
+		},
+		{
+			name: "failed",
+			calls: []cmdutil.FakeCall{
+				serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition, running,
+				describeTasks(`{"taskArn": "` + taskArn + `", "lastStatus": "STOPPED", "containers": [
+					{"name": "app", "exitCode": 4}, {"name": "sidecar", "exitCode": 0}
+				]}`),
+			},
+			expectedResult: &runTaskResult{TaskArn: taskArn, Status: runTaskStatusStopped, Container: "app", ExitCode: 4},
+		},
+		{
+			name:          "exit code of container name",
+			containerName: "sidecar",
+			calls: []cmdutil.FakeCall{
+				serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition,
+				describeTasks(`{"taskArn": "` + taskArn + `", "lastStatus": "STOPPED", "containers": [
+					{"name": "app", "exitCode": 0}, {"name": "sidecar", "exitCode": 137}
+				]}`),
+			},
+			expectedResult: &runTaskResult{TaskArn: taskArn, Status: runTaskStatusStopped, Container: "sidecar", ExitCode: 137},
+		},
+		{
+			name: "container did not run",
+			calls: []cmdutil.FakeCall{
+				serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition,
+				describeTasks(`{"taskArn": "` + taskArn + `", "lastStatus": "STOPPED", "stoppedReason": "CannotPullContainerError: image not found", "containers": [
+					{"name": "app"}, {"name": "sidecar"}
+				]}`),
+			},
+			expectedResult: &runTaskResult{
+				TaskArn: taskArn, Status: runTaskStatusDidNotRun, Container: "app", StoppedReason: "CannotPullContainerError: image not found",
+			},
+			expectedErr: "container app did not run. Task abc123 stopped: CannotPullContainerError: image not found.",
+		},
+		{
+			name:           "timed out",
+			timeout:        time.Nanosecond,
+			calls:          []cmdutil.FakeCall{serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition, stopTask},
+			expectedResult: &runTaskResult{TaskArn: taskArn, Status: runTaskStatusTimedOut, StoppedOnTimeout: true},
+		},
+		{
+			name:           "timed out leaving the task running",
+			leaveRunning:   true,
+			timeout:        time.Nanosecond,
+			calls:          []cmdutil.FakeCall{serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition},
+			expectedResult: &runTaskResult{TaskArn: taskArn, Status: runTaskStatusTimedOut},
+		},
+		{
+			name:        "canceled",
+			cancel:      true,
+			calls:       []cmdutil.FakeCall{serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition, running, stopCanceledTask},
+			expectedErr: "canceled while waiting",
+		},
+		{
+			name:         "canceled leaving the task running",
+			cancel:       true,
+			leaveRunning: true,
+			calls:        []cmdutil.FakeCall{serviceOutput, existingTaskDefinition, describeTaskDefinition, runTask, describeTaskDefinition, running},
+			expectedErr:  "canceled while waiting",
+		},
+		{
+			name: "task not started",
+			calls: []cmdutil.FakeCall{
+				serviceOutput, existingTaskDefinition, describeTaskDefinition,
+				{
+					Args:   []string{"ecs", "run-task", "--cli-input-json", "file://*"},
+					Stdout: `{"tasks": [], "failures": [{"reason": "RESOURCE:MEMORY", "detail": "not enough memory"}]}`,
+				},
+			},
+			expectedErr: "failed to run task: RESOURCE:MEMORY not enough memory",
+		},
+		{
+			name:        "service missing",
+			calls:       []cmdutil.FakeCall{{Args: describeServicesArgs, Stdout: serviceMissingOutput}},
+			expectedErr: "pass --service-spec-file",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, false)
+			commonFlags.serviceSpecFile = ""
+			prevFlags := runTaskFlags
+			t.Cleanup(func() { runTaskFlags = prevFlags })
+			runTaskFlags.containerName = tc.containerName
+			runTaskFlags.stopOnTimeout = !tc.leaveRunning
+			runTaskFlags.waitOptions = waitOptions{timeout: tc.timeout, pollInterval: time.Millisecond}
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var client cmdutil.Runner = runner
+			if tc.cancel {
+				client = &cancelingRunner{Runner: runner, cancelOn: "describe-tasks", cancel: cancel}
+			}
+			result, err := runRunTask(ctx, newCliClient(client))
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Equal(t, tc.expectedResult, result)
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
+
+// cancelingRunner cancels a context once a command with the subcommand cancelOn ran.
+type cancelingRunner struct {
+	cmdutil.Runner
+	cancelOn string
+	cancel   func()
+}
+
+func (r *cancelingRunner) Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	output, err := r.Runner.Output(ctx, cmd)
+	if slices.Contains(cmd.Args, r.cancelOn) {
+		r.cancel()
+	}
+	return output, err
+}
@@ -0,0 +1,417 @@
+package awsecs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+const standInAccount = "arn:aws:ecs:us-west-2:123456789012"
+
+// ecsStandIn is a minimal in-memory ECS and resource tagging API, served over HTTP for sdkClient to talk to.
+// Documents are kept in their wire format, camelCase JSON with epoch second timestamps.
+type ecsStandIn struct {
+	t      *testing.T
+	server *httptest.Server
+	// number of resources returned per GetResources page
+	pageSize int
+
+	mu       sync.Mutex
+	services map[string]map[string]any
+	taskDefs []map[string]any
+	// operations called, in order
+	calls  []string
+	nextId int
+}
+
+func newEcsStandIn(t *testing.T) *ecsStandIn {
+	s := &ecsStandIn{
+		t:        t,
+		pageSize: 100,
+		services: map[string]map[string]any{},
+	}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *ecsStandIn) client() *sdkClient {
+	return newSdkClient(aws.Config{
+		Region:       "us-west-2",
+		Credentials:  credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+		BaseEndpoint: aws.String(s.server.URL),
+		Retryer:      func() aws.Retryer { return aws.NopRetryer{} },
+	})
+}
+
+// Calls returns the operations called so far.
+func (s *ecsStandIn) Calls() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.calls...)
+}
+
+type standInError struct {
+	errorType string
+	message   string
+}
+
+func (e *standInError) Error() string {
+	return e.errorType + ": " + e.message
+}
+
+func (s *ecsStandIn) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.Header.Get("X-Amz-Target")
+	operation := target[strings.LastIndex(target, ".")+1:]
+	var body map[string]any
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		s.t.Errorf("failed to decode %s request: %v", operation, err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.calls = append(s.calls, operation)
+	var resp any
+	var err error
+	switch operation {
+	case "DescribeServices":
+		resp = s.describeServices(body)
+	case "ListClusters":
+		resp = s.listClusters()
+	case "ListServices":
+		resp = s.listServices(body)
+	case "DescribeTaskDefinition":
+		resp, err = s.describeTaskDefinition(body)
+	case "RegisterTaskDefinition":
+		resp = s.registerTaskDefinition(body)
+	case "CreateService":
+		resp = s.createService(body)
+	case "UpdateService":
+		resp, err = s.updateService(body)
+	case "DeleteService":
+		resp, err = s.deleteService(body)
+	case "DeregisterTaskDefinition":
+		resp, err = s.deregisterTaskDefinition(body)
+	case "DeleteTaskDefinitions":
+		resp = s.deleteTaskDefinitions(body)
+	case "TagResource":
+		resp, err = s.tagResource(body)
+	case "GetResources":
+		resp = s.getResources(body)
+	default:
+		err = &standInError{errorType: "UnknownOperationException", message: operation}
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		resp = map[string]any{"__type": err.(*standInError).errorType, "message": err.(*standInError).message}
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		s.t.Errorf("failed to encode %s response: %v", operation, err)
+	}
+}
+
+func (s *ecsStandIn) describeServices(body map[string]any) any {
+	resp := map[string]any{"services": []any{}, "failures": []any{}}
+	for _, name := range body["services"].([]any) {
+		// services are given by name or ARN
+		name := name.(string)[strings.LastIndex(name.(string), "/")+1:]
+		if svc, ok := s.services[name]; ok {
+			resp["services"] = append(resp["services"].([]any), svc)
+		} else {
+			resp["failures"] = append(resp["failures"].([]any), map[string]any{
+				"arn":    fmt.Sprintf("%s:service/%s/%s", standInAccount, body["cluster"], name),
+				"reason": "MISSING",
+			})
+		}
+	}
+	return resp
+}
+
+func clusterArn(cluster any) string {
+	return fmt.Sprintf("%s:cluster/%s", standInAccount, cluster)
+}
+
+func (s *ecsStandIn) listClusters() any {
+	clusters := map[string]bool{}
+	for _, svc := range s.services {
+		clusters[clusterArn(svc["cluster"])] = true
+	}
+	arns := []string{}
+	for arn := range clusters {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+	return map[string]any{"clusterArns": arns}
+}
+
+// listServices lists the services of a cluster that are not INACTIVE, like ECS does.
+func (s *ecsStandIn) listServices(body map[string]any) any {
+	arns := []string{}
+	for _, svc := range s.services {
+		if clusterArn(svc["cluster"]) == body["cluster"] && svc["status"] != "INACTIVE" {
+			arns = append(arns, svc["serviceArn"].(string))
+		}
+	}
+	sort.Strings(arns)
+	return map[string]any{"serviceArns": arns}
+}
+
+func (s *ecsStandIn) findTaskDef(arn string) map[string]any {
+	for _, td := range s.taskDefs {
+		if td["taskDefinitionArn"] == arn {
+			return td
+		}
+	}
+	return nil
+}
+
+func (s *ecsStandIn) describeTaskDefinition(body map[string]any) (any, error) {
+	td := s.findTaskDef(body["taskDefinition"].(string))
+	if td == nil {
+		return nil, &standInError{errorType: "ClientException", message: "Unable to describe task definition."}
+	}
+	def := map[string]any{}
+	for k, v := range td {
+		if k != "tags" {
+			def[k] = v
+		}
+	}
+	return map[string]any{"taskDefinition": def, "tags": td["tags"]}, nil
+}
+
+func (s *ecsStandIn) registerTaskDefinition(body map[string]any) any {
+	revision := 1
+	for _, td := range s.taskDefs {
+		if td["family"] == body["family"] {
+			revision++
+		}
+	}
+	td := map[string]any{
+		"taskDefinitionArn": fmt.Sprintf("%s:task-definition/%s:%d", standInAccount, body["family"], revision),
+		"revision":          revision,
+		"status":            "ACTIVE",
+	}
+	for k, v := range body {
+		td[k] = v
+	}
+	s.taskDefs = append(s.taskDefs, td)
+	return map[string]any{"taskDefinition": td, "tags": td["tags"]}
+}
+
+// newDeployment starts a deployment that is still in progress and demotes the previous PRIMARY deployment.
+func (s *ecsStandIn) newDeployment(svc map[string]any) {
+	s.nextId++
+	var deployments []any
+	if existing, ok := svc["deployments"].([]any); ok {
+		for _, d := range existing {
+			d.(map[string]any)["status"] = "ACTIVE"
+			deployments = append(deployments, d)
+		}
+	}
+	now := float64(time.Date(2024, 1, 1, 0, s.nextId, 0, 0, time.UTC).Unix())
+	svc["deployments"] = append([]any{map[string]any{
+		"id":             fmt.Sprintf("ecs-svc/%d", s.nextId),
+		"status":         "PRIMARY",
+		"taskDefinition": svc["taskDefinition"],
+		"desiredCount":   svc["desiredCount"],
+		"pendingCount":   svc["desiredCount"],
+		"runningCount":   0,
+		"failedTasks":    0,
+		"rolloutState":   "IN_PROGRESS",
+		"createdAt":      now,
+		"updatedAt":      now,
+	}}, deployments...)
+}
+
+func (s *ecsStandIn) createService(body map[string]any) any {
+	svc := map[string]any{
+		"serviceArn": fmt.Sprintf("%s:service/%s/%s", standInAccount, body["cluster"], body["serviceName"]),
+		"status":     "ACTIVE",
+	}
+	for k, v := range body {
+		svc[k] = v
+	}
+	s.newDeployment(svc)
+	s.services[body["serviceName"].(string)] = svc
+	return map[string]any{"service": svc}
+}
+
+func (s *ecsStandIn) updateService(body map[string]any) (any, error) {
+	svc, ok := s.services[body["service"].(string)]
+	if !ok {
+		return nil, &standInError{errorType: "ServiceNotFoundException", message: "Service not found."}
+	}
+	for k, v := range body {
+		if k != "service" {
+			svc[k] = v
+		}
+	}
+	if _, ok := body["taskDefinition"]; !ok {
+		// only scaling the service does not start a new deployment
+		for _, d := range svc["deployments"].([]any) {
+			if depl := d.(map[string]any); depl["status"] == "PRIMARY" {
+				depl["desiredCount"] = svc["desiredCount"]
+			}
+		}
+		return map[string]any{"service": svc}, nil
+	}
+	s.newDeployment(svc)
+	return map[string]any{"service": svc}, nil
+}
+
+// deleteService deletes the service right away, as if its tasks stopped instantly.
+func (s *ecsStandIn) deleteService(body map[string]any) (any, error) {
+	svc, ok := s.services[body["service"].(string)]
+	if !ok {
+		return nil, &standInError{errorType: "ServiceNotFoundException", message: "Service not found."}
+	}
+	if svc["desiredCount"] != float64(0) && body["force"] != true {
+		return nil, &standInError{errorType: "InvalidParameterException", message: "The service cannot be stopped while it is scaled above 0."}
+	}
+	svc["status"] = "INACTIVE"
+	svc["deployments"] = []any{}
+	return map[string]any{"service": svc}, nil
+}
+
+func (s *ecsStandIn) deregisterTaskDefinition(body map[string]any) (any, error) {
+	td := s.findTaskDef(body["taskDefinition"].(string))
+	if td == nil {
+		return nil, &standInError{errorType: "ClientException", message: "The specified task definition does not exist."}
+	}
+	td["status"] = "INACTIVE"
+	return map[string]any{"taskDefinition": td}, nil
+}
+
+// completeRollouts finishes every PRIMARY deployment and drains the others.
+func (s *ecsStandIn) completeRollouts() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range s.services {
+		var deployments []any
+		for _, d := range svc["deployments"].([]any) {
+			depl := d.(map[string]any)
+			if depl["status"] == "PRIMARY" {
+				depl["runningCount"] = depl["desiredCount"]
+				depl["pendingCount"] = 0
+				depl["rolloutState"] = "COMPLETED"
+				deployments = append(deployments, depl)
+			}
+		}
+		svc["deployments"] = deployments
+	}
+}
+
+func (s *ecsStandIn) deleteTaskDefinitions(body map[string]any) any {
+	resp := map[string]any{"taskDefinitions": []any{}, "failures": []any{}}
+	for _, arn := range body["taskDefinitions"].([]any) {
+		td := s.findTaskDef(arn.(string))
+		if td == nil || td["status"] != "INACTIVE" {
+			resp["failures"] = append(resp["failures"].([]any), map[string]any{
+				"arn":    arn,
+				"reason": "The specified task definition is not inactive.",
+			})
+			continue
+		}
+		td["status"] = "DELETE_IN_PROGRESS"
+		resp["taskDefinitions"] = append(resp["taskDefinitions"].([]any), td)
+	}
+	return resp
+}
+
+// tagResource tags services, the only resources tagged after they were created.
+func (s *ecsStandIn) tagResource(body map[string]any) (any, error) {
+	for _, svc := range s.services {
+		if svc["serviceArn"] != body["resourceArn"] {
+			continue
+		}
+		tags, _ := svc["tags"].([]any)
+		for _, tag := range body["tags"].([]any) {
+			if !tagsMatch(tags, []any{map[string]any{"Key": tag.(map[string]any)["key"], "Values": []any{tag.(map[string]any)["value"]}}}) {
+				tags = append(tags, tag)
+			}
+		}
+		svc["tags"] = tags
+		return map[string]any{}, nil
+	}
+	return nil, &standInError{errorType: "ResourceNotFoundException", message: "The specified resource could not be found."}
+}
+
+func tagsMatch(tags []any, filters []any) bool {
+	tagMap := map[string]string{}
+	for _, tag := range tags {
+		tagMap[tag.(map[string]any)["key"].(string)] = tag.(map[string]any)["value"].(string)
+	}
+	for _, f := range filters {
+		filter := f.(map[string]any)
+		value, ok := tagMap[filter["Key"].(string)]
+		if !ok {
+			return false
+		}
+		matched := false
+		for _, v := range filter["Values"].([]any) {
+			if v == value {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *ecsStandIn) getResources(body map[string]any) any {
+	// resources of the requested type, with their ARN and tags
+	var resources []map[string]any
+	switch body["ResourceTypeFilters"].([]any)[0] {
+	case "ecs:task-definition":
+		for _, td := range s.taskDefs {
+			resources = append(resources, map[string]any{"arn": td["taskDefinitionArn"], "tags": td["tags"]})
+		}
+	case "ecs:service":
+		for _, svc := range s.services {
+			resources = append(resources, map[string]any{"arn": svc["serviceArn"], "tags": svc["tags"]})
+		}
+		// keep pages stable across calls
+		sort.Slice(resources, func(i, j int) bool {
+			return resources[i]["arn"].(string) < resources[j]["arn"].(string)
+		})
+	}
+	var matches []any
+	for _, resource := range resources {
+		tags, _ := resource["tags"].([]any)
+		if !tagsMatch(tags, body["TagFilters"].([]any)) {
+			continue
+		}
+		var resourceTags []any
+		for _, tag := range tags {
+			resourceTags = append(resourceTags, map[string]any{
+				"Key":   tag.(map[string]any)["key"],
+				"Value": tag.(map[string]any)["value"],
+			})
+		}
+		matches = append(matches, map[string]any{"ResourceARN": resource["arn"], "Tags": resourceTags})
+	}
+	start := 0
+	if token, ok := body["PaginationToken"].(string); ok && token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := min(start+s.pageSize, len(matches))
+	resp := map[string]any{"ResourceTagMappingList": append([]any{}, matches[start:end]...)}
+	if end < len(matches) {
+		resp["PaginationToken"] = strconv.Itoa(end)
+	}
+	return resp
+}
@@ -0,0 +1,196 @@
+package awsecs
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// exit code when the rollout failed, e.g. because the deployment circuit breaker tripped
+	rolloutFailedExitCode = 2
+	// exit code when the rollout, or whatever else is waited for, did not finish within --wait-timeout
+	waitTimedOutExitCode = 3
+)
+
+type waitOptions struct {
+	timeout      time.Duration
+	pollInterval time.Duration
+}
+
+// registerWaitFlags registers --wait-timeout and --wait-poll-interval, describing what is waited for as e.g. "the rollout".
+func registerWaitFlags(cmd *cobra.Command, opts *waitOptions, what string) {
+	cmd.Flags().DurationVar(&opts.timeout, "wait-timeout", 30*time.Minute, fmt.Sprintf("Maximum time to wait for %s to finish. 0 means no limit.", what))
+	cmd.Flags().DurationVar(&opts.pollInterval, "wait-poll-interval", 15*time.Second, fmt.Sprintf("How often to check on %s while waiting.", what))
+}
+
+// waitUntil calls poll every opts.pollInterval until it reports done, and returns false if opts.timeout passed first.
+// Errors from poll end the wait, unless they are caused by the timeout.
+func waitUntil(ctx context.Context, opts waitOptions, poll func(context.Context) (bool, error)) (bool, error) {
+	waitCtx, cancel := cmdutil.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+	for {
+		done, err := poll(waitCtx)
+		if err == nil && done {
+			return true, nil
+		}
+		if ctx.Err() != nil {
+			return false, errors.Wrap(ctx.Err(), "canceled while waiting")
+		}
+		if waitCtx.Err() != nil {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		select {
+		case <-waitCtx.Done():
+		case <-time.After(opts.pollInterval):
+		}
+	}
+}
+
+// deploymentProgress is what is reported while waiting, logged whenever it changes.
+type deploymentProgress struct {
+	id           string
+	status       string
+	rolloutState types.DeploymentRolloutState
+	desired      int32
+	running      int32
+	pending      int32
+	failed       int32
+}
+
+func findDeployment(service types.Service, id, taskDefinition string) *types.Deployment {
+	for i, depl := range service.Deployments {
+		if id != "" && aws.ToString(depl.Id) == id {
+			return &service.Deployments[i]
+		}
+		if id == "" && aws.ToString(depl.Status) == "PRIMARY" && aws.ToString(depl.TaskDefinition) == taskDefinition {
+			return &service.Deployments[i]
+		}
+	}
+	return nil
+}
+
+func circuitBreaker(svc types.Service) *types.DeploymentCircuitBreaker {
+	if svc.DeploymentConfiguration == nil || svc.DeploymentConfiguration.DeploymentCircuitBreaker == nil {
+		return &types.DeploymentCircuitBreaker{}
+	}
+	return svc.DeploymentConfiguration.DeploymentCircuitBreaker
+}
+
+// rolloutWaiter follows the deployment of a task definition across polls.
+type rolloutWaiter struct {
+	client         ecsClient
+	cluster        string
+	service        string
+	taskDefinition string
+	start          time.Time
+
+	// set once the deployment shows up
+	deploymentId string
+	last         deploymentProgress
+	polled       bool
+}
+
+// poll checks the deployment once, returning whether the rollout is done and if so, the exit code.
+func (w *rolloutWaiter) poll(ctx context.Context) (bool, int, error) {
+	serviceOutput, err := describeService(ctx, w.client, w.cluster, w.service)
+	if err != nil {
+		return false, 0, err
+	}
+	if serviceMissing(serviceOutput) {
+		return false, 0, errors.Errorf("ECS service %s does not exist", w.service)
+	}
+	svc := serviceOutput.Services[0]
+	if !w.polled && !circuitBreaker(svc).Enable {
+		slog.Warn("Deployment circuit breaker is not enabled for the service, so failing tasks will not fail the rollout and waiting only ends when it completes or times out")
+	}
+	w.polled = true
+	depl := findDeployment(svc, w.deploymentId, w.taskDefinition)
+	if depl == nil {
+		if w.deploymentId != "" {
+			slog.Error("Deployment is gone, it was replaced before it completed", "deployment", w.deploymentId)
+			return true, rolloutFailedExitCode, nil
+		}
+		// the new deployment can take a moment to show up
+		slog.Info("Waiting for deployment to start", "task_definition", w.taskDefinition)
+		return false, 0, nil
+	}
+	w.deploymentId = aws.ToString(depl.Id)
+	progress := deploymentProgress{
+		id:           w.deploymentId,
+		status:       aws.ToString(depl.Status),
+		rolloutState: depl.RolloutState,
+		desired:      depl.DesiredCount,
+		running:      depl.RunningCount,
+		pending:      depl.PendingCount,
+		failed:       depl.FailedTasks,
+	}
+	if progress != w.last {
+		slog.Info(
+			"Rollout progress",
+			"deployment", progress.id,
+			"status", progress.status,
+			"rollout_state", string(progress.rolloutState),
+			"desired", progress.desired,
+			"running", progress.running,
+			"pending", progress.pending,
+			"failed_tasks", progress.failed,
+		)
+		w.last = progress
+	}
+	switch {
+	case depl.RolloutState == types.DeploymentRolloutStateFailed:
+		slog.Error("Rollout failed", "deployment", w.deploymentId, "reason", aws.ToString(depl.RolloutStateReason))
+		if circuitBreaker(svc).Rollback {
+			slog.Info("ECS is rolling back to the last completed deployment")
+		}
+		return true, rolloutFailedExitCode, nil
+	case progress.status != "PRIMARY":
+		slog.Error("Deployment was replaced by a newer deployment before it completed", "deployment", w.deploymentId)
+		return true, rolloutFailedExitCode, nil
+	case depl.RolloutState == types.DeploymentRolloutStateCompleted,
+		// rollout states are only tracked for the ECS deployment controller
+		depl.RolloutState == "" && len(svc.Deployments) == 1 && depl.RunningCount == depl.DesiredCount && depl.PendingCount == 0:
+		slog.Info("Rollout completed", "deployment", w.deploymentId, "elapsed", time.Since(w.start).Round(time.Second))
+		return true, 0, nil
+	}
+	return false, 0, nil
+}
+
+// waitForRollout polls the service until the deployment of taskDefinition completes or fails, and returns the exit code
+// pvn-wrapper should exit with.
+// Rollouts are only marked as failed by ECS if the service has the deployment circuit breaker enabled,
+// otherwise a rollout whose tasks keep failing only ends when the wait times out.
+func waitForRollout(ctx context.Context, client ecsClient, cluster, service, taskDefinition string, opts waitOptions) (int, error) {
+	waiter := &rolloutWaiter{
+		client:         client,
+		cluster:        cluster,
+		service:        service,
+		taskDefinition: taskDefinition,
+		start:          time.Now(),
+	}
+	var exitCode int
+	finished, err := waitUntil(ctx, opts, func(ctx context.Context) (bool, error) {
+		done, code, err := waiter.poll(ctx)
+		exitCode = code
+		return done, err
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "failed waiting for rollout")
+	}
+	if finished {
+		return exitCode, nil
+	}
+	slog.Error("Timed out waiting for rollout", "deployment", waiter.deploymentId, "timeout", opts.timeout)
+	return waitTimedOutExitCode, nil
+}
@@ -0,0 +1,88 @@
+package awsecs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+const circuitBreakerConfig = `"deploymentConfiguration": {"deploymentCircuitBreaker": {"enable": true, "rollback": true}}`
+
+func describeDeploymentsCall(deployments string) cmdutil.FakeCall {
+	return cmdutil.FakeCall{
+		Args:   describeServicesArgs,
+		Stdout: `{"services": [{"status": "ACTIVE", ` + circuitBreakerConfig + `, "deployments": [` + deployments + `]}]}`,
+	}
+}
+
+func TestWaitForRollout(t *testing.T) {
+	const (
+		oldDeployment = `{"id": "d1", "status": "ACTIVE", "taskDefinition": "arn:task/a:1", "desiredCount": 2, "runningCount": 2, "rolloutState": "COMPLETED"}`
+		inProgress    = `{"id": "d2", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "pendingCount": 2, "rolloutState": "IN_PROGRESS"}`
+	)
+	stuck := make([]cmdutil.FakeCall, 1000)
+	for i := range stuck {
+		stuck[i] = describeDeploymentsCall(inProgress + "," + oldDeployment)
+	}
+	for _, tc := range []struct {
+		name             string
+		calls            []cmdutil.FakeCall
+		timeout          time.Duration
+		expectedExitCode int
+	}{
+		{
+			name: "completed",
+			calls: []cmdutil.FakeCall{
+				describeDeploymentsCall(inProgress + "," + oldDeployment),
+				describeDeploymentsCall(`{"id": "d2", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "runningCount": 2, "rolloutState": "COMPLETED"}`),
+			},
+		},
+		{
+			name: "deployment not started yet",
+			calls: []cmdutil.FakeCall{
+				describeDeploymentsCall(`{"id": "d1", "status": "PRIMARY", "taskDefinition": "arn:task/a:1", "desiredCount": 2, "runningCount": 2, "rolloutState": "COMPLETED"}`),
+				describeDeploymentsCall(`{"id": "d2", "status": "PRIMARY", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "runningCount": 2, "rolloutState": "COMPLETED"}`),
+			},
+		},
+		{
+			name: "circuit breaker tripped",
+			calls: []cmdutil.FakeCall{
+				describeDeploymentsCall(inProgress + "," + oldDeployment),
+				describeDeploymentsCall(`{"id": "d3", "status": "PRIMARY", "taskDefinition": "arn:task/a:1", "desiredCount": 2, "pendingCount": 2, "rolloutState": "IN_PROGRESS"},
+					{"id": "d2", "status": "ACTIVE", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "failedTasks": 3, "rolloutState": "FAILED", "rolloutStateReason": "ECS deployment circuit breaker: tasks failed to start."}`),
+			},
+			expectedExitCode: rolloutFailedExitCode,
+		},
+		{
+			name: "replaced by a newer deployment",
+			calls: []cmdutil.FakeCall{
+				describeDeploymentsCall(inProgress),
+				describeDeploymentsCall(`{"id": "d3", "status": "PRIMARY", "taskDefinition": "arn:task/a:3", "desiredCount": 2, "pendingCount": 2, "rolloutState": "IN_PROGRESS"},
+					{"id": "d2", "status": "ACTIVE", "taskDefinition": "arn:task/a:2", "desiredCount": 2, "pendingCount": 2, "rolloutState": "IN_PROGRESS"}`),
+			},
+			expectedExitCode: rolloutFailedExitCode,
+		},
+		{
+			name:             "timed out",
+			calls:            stuck,
+			timeout:          50 * time.Millisecond,
+			expectedExitCode: waitTimedOutExitCode,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			exitCode, err := waitForRollout(context.Background(), newCliClient(runner), "my-cluster", "my-service", "arn:task/a:2", waitOptions{
+				timeout:      tc.timeout,
+				pollInterval: time.Millisecond,
+			})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedExitCode, exitCode)
+			if tc.timeout == 0 {
+				require.Empty(t, runner.Unused())
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/spf13/cobra"
+)
+
+// blobClient is the part of result.BlobClient used by the blob and result commands.
+type blobClient interface {
+	Get(ctx context.Context, id string, w io.Writer) error
+	GetFile(ctx context.Context, id, path string) error
+	Put(ctx context.Context, path string) (string, error)
+	Close() error
+}
+
+// newBlobClient connects to Prodvana. It is a variable so that tests can use a fake client instead.
+var newBlobClient = func() (blobClient, error) {
+	client, err := result.NewBlobClient()
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+var blobCmd = &cobra.Command{
+	Use:   "blob <subcommand>",
+	Short: "Inspect and create Prodvana blobs, for debugging.",
+	Long: `Inspect and create Prodvana blobs, for debugging.
+
+pvn-wrapper blob get my-blob-id -o my-file
+pvn-wrapper blob cat my-blob-id
+pvn-wrapper blob put my-file
+`,
+}
+
+var blobGetFlags = struct {
+	output string
+}{}
+
+var blobGetCmd = &cobra.Command{
+	Use:   "get <blob-id>",
+	Short: "Download a blob to a file. Defaults to a file named after the blob ID.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output := blobGetFlags.output
+		if output == "" {
+			output = args[0]
+		}
+		blobs, err := newBlobClient()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blobs.Close() }()
+		return blobs.GetFile(cmd.Context(), args[0], output)
+	},
+}
+
+var blobCatCmd = &cobra.Command{
+	Use:   "cat <blob-id>",
+	Short: "Print a blob to stdout.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blobs, err := newBlobClient()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blobs.Close() }()
+		return blobs.Get(cmd.Context(), args[0], cmd.OutOrStdout())
+	},
+}
+
+var blobPutCmd = &cobra.Command{
+	Use:   "put <path>",
+	Short: "Upload a file and print its blob ID.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		blobs, err := newBlobClient()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blobs.Close() }()
+		id, err := blobs.Put(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), id)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(blobCmd)
+	blobCmd.AddCommand(blobGetCmd)
+	blobCmd.AddCommand(blobCatCmd)
+	blobCmd.AddCommand(blobPutCmd)
+	blobGetCmd.Flags().StringVarP(&blobGetFlags.output, "output", "o", "", "Path to download the blob to.")
+}
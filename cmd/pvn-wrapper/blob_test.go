@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlobClient serves blobs from memory.
+type fakeBlobClient struct {
+	blobs  map[string]string
+	closed bool
+}
+
+func (c *fakeBlobClient) Get(ctx context.Context, id string, w io.Writer) error {
+	content, ok := c.blobs[id]
+	if !ok {
+		return errors.Errorf("blob %s not found", id)
+	}
+	_, err := io.WriteString(w, content)
+	return err
+}
+
+func (c *fakeBlobClient) GetFile(ctx context.Context, id, path string) error {
+	var content bytes.Buffer
+	if err := c.Get(ctx, id, &content); err != nil {
+		return err
+	}
+	return os.WriteFile(path, content.Bytes(), 0o600)
+}
+
+func (c *fakeBlobClient) Put(ctx context.Context, path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	id := fmt.Sprintf("blob-%d", len(c.blobs)+1)
+	c.blobs[id] = string(content)
+	return id, nil
+}
+
+func (c *fakeBlobClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+// useFakeBlobClient makes newBlobClient return a fakeBlobClient serving blobs for the duration of the test.
+func useFakeBlobClient(t *testing.T, blobs map[string]string) *fakeBlobClient {
+	fake := &fakeBlobClient{blobs: blobs}
+	prev := newBlobClient
+	t.Cleanup(func() { newBlobClient = prev })
+	newBlobClient = func() (blobClient, error) {
+		return fake, nil
+	}
+	return fake
+}
+
+// runCommand runs the RunE of cmd and returns what it printed.
+func runCommand(t *testing.T, cmd *cobra.Command, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetContext(context.Background())
+	t.Cleanup(func() { cmd.SetOut(nil) })
+	err := cmd.RunE(cmd, args)
+	return out.String(), err
+}
+
+func TestBlobCommands(t *testing.T) {
+	fake := useFakeBlobClient(t, map[string]string{"blob-1": "hello"})
+	dir := t.TempDir()
+
+	out, err := runCommand(t, blobCatCmd, "blob-1")
+	require.NoError(t, err)
+	require.Equal(t, "hello", out)
+
+	prevFlags := blobGetFlags
+	t.Cleanup(func() { blobGetFlags = prevFlags })
+	blobGetFlags.output = filepath.Join(dir, "downloaded.txt")
+	_, err = runCommand(t, blobGetCmd, "blob-1")
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(blobGetFlags.output)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(downloaded))
+
+	_, err = runCommand(t, blobGetCmd, "missing")
+	require.EqualError(t, err, "blob missing not found")
+
+	path := filepath.Join(dir, "upload.txt")
+	require.NoError(t, os.WriteFile(path, []byte("uploaded"), 0o600))
+	out, err = runCommand(t, blobPutCmd, path)
+	require.NoError(t, err)
+	require.Equal(t, "blob-2\n", out)
+	require.Equal(t, "uploaded", fake.blobs["blob-2"])
+	require.True(t, fake.closed)
+}
+
+func TestResultShow(t *testing.T) {
+	useFakeBlobClient(t, map[string]string{
+		"stdout-blob": "hello\n",
+		"report-blob": "all good\n",
+	})
+	path := filepath.Join(t.TempDir(), "output.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "exitCode": 3,
+  "execError": "exit status 3",
+  "version": "0.0.2",
+  "hostname": "runner-1",
+  "startTimestampNs": "1700000000000000000",
+  "durationNs": "1500000000",
+  "stdoutBlobId": "stdout-blob",
+  "files": [{"name": "report", "contentBlobId": "report-blob"}]
+}`), 0o600))
+
+	for _, tc := range []struct {
+		name     string
+		noFiles  bool
+		expected string
+	}{
+		{
+			name: "with files",
+			expected: `Exit code: 3
+Exec error: exit status 3
+Version: 0.0.2
+Hostname: runner-1
+Started: 2023-11-14T22:13:20Z
+Duration: 1.5s
+
+=== stdout (stdout-blob) ===
+hello
+
+=== stderr () ===
+(not captured)
+
+=== file report (report-blob) ===
+all good
+`,
+		},
+		{
+			name:    "no files",
+			noFiles: true,
+			expected: `Exit code: 3
+Exec error: exit status 3
+Version: 0.0.2
+Hostname: runner-1
+Started: 2023-11-14T22:13:20Z
+Duration: 1.5s
+
+=== stdout (stdout-blob) ===
+hello
+
+=== stderr () ===
+(not captured)
+
+=== file report (report-blob) ===
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prev := resultShowFlags
+			t.Cleanup(func() { resultShowFlags = prev })
+			resultShowFlags.noFiles = tc.noFiles
+			out, err := runCommand(t, resultShowCmd, path)
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, out)
+		})
+	}
+
+	_, err := runCommand(t, resultShowCmd, filepath.Join(t.TempDir(), "missing.json"))
+	require.ErrorContains(t, err, "failed to read")
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+)
+
+// Name of the output file that the effective (redacted) child environment is uploaded as.
+const envOutputName = "pvn-env"
+
+type envSource string
+
+const (
+	envSourceInherited envSource = "inherited"
+	envSourceFile      envSource = "env-file"
+	envSourceBlob      envSource = "env-from-blob"
+	envSourceFlag      envSource = "env"
+)
+
+type envVar struct {
+	value  string
+	source envSource
+}
+
+type childEnv struct {
+	vars map[string]envVar
+	// whether the values of inherited variables are left out of Redacted, because the entire parent environment
+	// was inherited rather than an allowlist of it
+	redactInherited bool
+}
+
+func splitEnvPair(pair string) (string, string, error) {
+	k, v, found := strings.Cut(pair, "=")
+	if !found || k == "" {
+		return "", "", errors.Errorf("invalid environment variable %q, must be in the format KEY=VALUE", pair)
+	}
+	return k, v, nil
+}
+
+// newChildEnv builds the environment the wrapped command starts from.
+// If clear is false, the entire parent environment is inherited.
+// Otherwise, only variables matching one of passPatterns are inherited.
+func newChildEnv(parent []string, clear bool, passPatterns []string) (*childEnv, error) {
+	if !clear && len(passPatterns) > 0 {
+		return nil, errors.New("--pass-env only applies with --clear-env")
+	}
+	for _, pattern := range passPatterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "invalid --pass-env pattern %q", pattern)
+		}
+	}
+	env := &childEnv{vars: map[string]envVar{}, redactInherited: !clear}
+	for _, pair := range parent {
+		k, v, err := splitEnvPair(pair)
+		if err != nil {
+			// the parent environment can contain odd entries (e.g. "=C:=C:\" on windows), skip them
+			continue
+		}
+		if clear && !matchesAny(k, passPatterns) {
+			continue
+		}
+		env.vars[k] = envVar{value: v, source: envSourceInherited}
+	}
+	return env, nil
+}
+
+func matchesAny(key string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *childEnv) set(key, value string, source envSource) {
+	e.vars[key] = envVar{value: value, source: source}
+}
+
+func (e *childEnv) setPairs(pairs []string, source envSource) error {
+	for _, pair := range pairs {
+		k, v, err := splitEnvPair(pair)
+		if err != nil {
+			return err
+		}
+		e.set(k, v, source)
+	}
+	return nil
+}
+
+// loadFile loads variables from a dotenv-style file: one KEY=VALUE per line,
+// with blank lines, # comments, an optional "export " prefix, and optionally quoted values.
+func (e *childEnv) loadFile(filePath string, source envSource) error {
+	contents, err := os.ReadFile(filePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read env file %s", filePath)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, err := splitEnvPair(line)
+		if err != nil {
+			return errors.Wrapf(err, "%s:%d", filePath, lineNum)
+		}
+		k = strings.TrimSpace(k)
+		v = strings.TrimSpace(v)
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		e.set(k, v, source)
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.Wrapf(err, "failed to parse env file %s", filePath)
+	}
+	return nil
+}
+
+func (e *childEnv) sortedKeys() []string {
+	keys := make([]string, 0, len(e.vars))
+	for k := range e.vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Environ returns the environment in the format expected by exec.Cmd.Env.
+func (e *childEnv) Environ() []string {
+	environ := make([]string, 0, len(e.vars))
+	for _, k := range e.sortedKeys() {
+		environ = append(environ, k+"="+e.vars[k].value)
+	}
+	return environ
+}
+
+func isSensitiveEnvKey(key string) bool {
+	upper := strings.ToUpper(key)
+	if strings.HasPrefix(upper, "PVN_") {
+		return true
+	}
+	return cmdutil.IsSensitiveKey(key)
+}
+
+// Redacted renders the environment for auditing, one variable per line along with where it came from.
+// Values of variables that look sensitive are masked, as are the values of the whole inherited parent environment
+// unless it was cleared, leaving only the variables allowlisted with --pass-env.
+func (e *childEnv) Redacted() []byte {
+	var buf bytes.Buffer
+	for _, k := range e.sortedKeys() {
+		v := e.vars[k]
+		value := v.value
+		if isSensitiveEnvKey(k) || cmdutil.IsSensitiveValue(value) || (e.redactInherited && v.source == envSourceInherited) {
+			value = cmdutil.Redacted
+		}
+		fmt.Fprintf(&buf, "%s=%s # %s\n", k, value, v.source)
+	}
+	return buf.Bytes()
+}
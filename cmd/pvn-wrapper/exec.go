@@ -3,13 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/prodvana/pvn-wrapper/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -17,6 +23,19 @@ var execFlags = struct {
 	in               []string
 	out              []string
 	successExitCodes []int32
+	env              []string
+	envFile          []string
+	envFromBlob      []string
+	clearEnv         bool
+	passEnv          []string
+	limits           resourceLimits
+	creds            credentials
+	tempWorkdir      bool
+	pty              bool
+	stripAnsi        bool
+	onSuccess        string
+	onFailure        string
+	recordInvocation bool
 }{}
 
 var execCmd = &cobra.Command{
@@ -26,29 +45,156 @@ var execCmd = &cobra.Command{
 The exit code matches the exit code of the underlying binary being executed.
 
 pvn-wrapper exec my-binary --my-flag=value my-args ...
+
+The binary can report results by printing markers on their own line to stdout.
+Markers are removed from the uploaded stdout.
+
+::pvn-output name=image_digest::sha256:...   reported as an output file named image_digest
+::pvn-event::message                         reported as a debug event in the pvn-events output file
+
+Output names starting with pvn- are reserved for pvn-wrapper, and markers using them are left in stdout. Markers named
+like an --out output are ignored, the --out file is uploaded instead.
+
+By default, the binary inherits pvn-wrapper's entire environment. Use --clear-env and --pass-env to start
+from an empty environment instead, and --env-file, --env-from-blob, and --env to add variables, applied in that order.
+If any of the flags above is set, the effective environment is uploaded as the pvn-env output file. Values of sensitive
+variables are redacted, as are the values inherited from pvn-wrapper's environment unless it is cleared with --clear-env.
+--pass-env only applies with --clear-env.
+With --record-invocation, the command and flags are uploaded as the pvn-invocation output file, for use with
+pvn-wrapper replay.
+
+pvn-wrapper exec --clear-env --pass-env 'AWS_*' --env FOO=bar my-binary ...
+
+Use the --limit-* flags to apply resource limits to the binary, and --uid/--gid to run it as a different user.
+If the binary is killed for exceeding --limit-cpu-seconds, this is reported in the pvn-limits output file. The other
+limits make syscalls fail in the binary, which reports them in its own output. If the binary fails after printing the
+usual message of such an error, e.g. "Too many open files", the limit is reported in pvn-limits as likely exceeded.
+Binaries that report these errors differently are not detected.
+With --temp-workdir, the binary runs in a fresh temporary directory that is removed afterwards. Relative --in and --out
+paths are resolved against that directory.
+
+Use --pty for tools that only print progress or colors when attached to a terminal. The binary's combined output
+is uploaded as stdout, with ANSI escape sequences removed if --strip-ansi is set. If the binary is killed by a signal,
+its exit code is reported as 128+signal, like shells do, instead of -1 without --pty.
+
+--on-success and --on-failure run a shell command after the binary exits, depending on whether its exit code is one of
+--success-exit-codes. Hooks run in the binary's working directory, as the same --uid/--gid and with the same --limit-*
+flags, with the binary's environment plus:
+
+PVN_EXIT_CODE      exit code of the binary, -1 if it failed to execute
+PVN_EXEC_ERROR     error encountered executing the binary, if any
+PVN_STDOUT_FILE    path to a file containing the binary's stdout
+PVN_STDERR_FILE    path to a file containing the binary's stderr
+PVN_OUTPUT_FILES   newline separated list of output-name=output-file-path, as passed to --out
+
+Hook output is uploaded as pvn-hook-on-success-* and pvn-hook-on-failure-* output files.
+Hook failures are logged and never change the result of pvn-wrapper.
 `,
 	Args: cobra.MinimumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(execFlags.passEnv) > 0 && !execFlags.clearEnv {
+			return errors.New("--pass-env only applies with --clear-env")
+		}
+		if execFlags.stripAnsi && !execFlags.pty {
+			return errors.New("--strip-ansi only applies with --pty")
+		}
+		return nil
+	},
 	Run: func(cmd *cobra.Command, args []string) {
+		var workdir string
+		var removeWorkdir sync.Once
+		if execFlags.tempWorkdir {
+			var err error
+			workdir, err = makeTempWorkdir(execFlags.creds)
+			if err != nil {
+				cmdutil.Fatal(err)
+			}
+			// the run callback removes the workdir before outputs are uploaded, this covers exiting before it runs,
+			// e.g. when an input fails to download
+			cmdutil.OnExit(func(int) {
+				removeWorkdir.Do(func() { removeTempWorkdir(workdir) })
+			})
+		}
+		inWorkdir := func(path string) string {
+			if workdir == "" || filepath.IsAbs(path) {
+				return path
+			}
+			return filepath.Join(workdir, path)
+		}
 		inputFiles := make([]result.InputFile, 0, len(execFlags.in))
 		for _, in := range execFlags.in {
 			components := strings.SplitN(in, "=", 2)
 			if len(components) != 2 {
-				log.Fatal("--in must be in the format input-file-path=input-blob-id")
+				cmdutil.Fatal("--in must be in the format input-file-path=input-blob-id")
 			}
 			inputFiles = append(inputFiles, result.InputFile{
-				Path:   components[0],
+				Path:   inWorkdir(components[0]),
 				BlobId: components[1],
 			})
 		}
+		envBlobPaths := make([]string, 0, len(execFlags.envFromBlob))
+		for _, blobId := range execFlags.envFromBlob {
+			envBlobFile, err := os.CreateTemp("", "pvn-env-blob")
+			if err != nil {
+				cmdutil.Fatal(err)
+			}
+			if err := envBlobFile.Close(); err != nil {
+				cmdutil.Fatal(err)
+			}
+			envBlobPaths = append(envBlobPaths, envBlobFile.Name())
+			inputFiles = append(inputFiles, result.InputFile{
+				Path:   envBlobFile.Name(),
+				BlobId: blobId,
+			})
+		}
 		successExitCodes := execFlags.successExitCodes
 		if len(successExitCodes) == 0 {
 			successExitCodes = []int32{0}
 		}
+		var invBytes []byte
+		if execFlags.recordInvocation {
+			inv, err := currentInvocation(args, successExitCodes)
+			if err != nil {
+				cmdutil.Fatal(err)
+			}
+			invBytes, err = inv.marshal()
+			if err != nil {
+				cmdutil.Fatal(err)
+			}
+		}
 		result.RunWrapper(inputFiles, successExitCodes, func(ctx context.Context) (*pvn_wrapper.Output, []result.OutputFileUpload, error) {
+			if workdir != "" {
+				defer removeWorkdir.Do(func() { removeTempWorkdir(workdir) })
+			}
+			env, err := makeChildEnv(envBlobPaths)
+			if err != nil {
+				return nil, nil, err
+			}
 			execCmd := exec.CommandContext(ctx, args[0], args[1:]...)
-			execCmd.Env = os.Environ()
+			execCmd.Env = tracing.InjectEnv(ctx, env.Environ())
+			execCmd.Dir = workdir
+			if err := applyLimits(execCmd, execFlags.limits, execFlags.creds); err != nil {
+				return nil, nil, err
+			}
+			if workdir != "" && execFlags.creds.isSet() {
+				if err := chownTree(workdir, execFlags.creds); err != nil {
+					return nil, nil, err
+				}
+			}
 
-			outputs := make([]result.OutputFileUpload, 0, len(execFlags.out))
+			outputs := make([]result.OutputFileUpload, 0, len(execFlags.out)+2)
+			if invBytes != nil {
+				outputs = append(outputs, result.OutputFileUpload{
+					Name:    invocationOutputName,
+					Content: invBytes,
+				})
+			}
+			if envFlagsSet() {
+				outputs = append(outputs, result.OutputFileUpload{
+					Name:    envOutputName,
+					Content: env.Redacted(),
+				})
+			}
 			for _, out := range execFlags.out {
 				components := strings.SplitN(out, "=", 2)
 				if len(components) != 2 {
@@ -56,20 +202,133 @@ pvn-wrapper exec my-binary --my-flag=value my-args ...
 				}
 				outputs = append(outputs, result.OutputFileUpload{
 					Name: components[0],
-					Path: components[1],
+					Path: inWorkdir(components[1]),
 				})
 			}
 
-			res, cmdOutputs, err := result.RunCmd(execCmd)
-			outputs = append(outputs, cmdOutputs...)
+			var res *pvn_wrapper.Output
+			var cmdOutputs []result.OutputFileUpload
+			if execFlags.pty {
+				res, cmdOutputs, err = result.RunCmdPty(execCmd, execFlags.stripAnsi)
+			} else {
+				res, cmdOutputs, err = result.RunCmd(execCmd)
+			}
+			outputs = append(outputs, withoutOutputs(cmdOutputs, outNames(execFlags.out))...)
+			if err == nil {
+				var printed []byte
+				for _, output := range cmdOutputs {
+					if output.Stdout || output.Stderr {
+						printed = append(printed, output.Content...)
+					}
+				}
+				outputs = append(outputs, limitsOutputs(execFlags.limits, execCmd.ProcessState, printed)...)
+			}
+			hookName, hookCommand := onFailureHook, execFlags.onFailure
+			if err == nil && slices.Contains(successExitCodes, res.ExitCode) {
+				hookName, hookCommand = onSuccessHook, execFlags.onSuccess
+			}
+			if hookCommand != "" {
+				outputs = append(outputs, runHook(ctx, hookName, hookCommand, hookRun{
+					res:     res,
+					err:     err,
+					outputs: outputs,
+					env:     execCmd.Env,
+					dir:     workdir,
+					limits:  execFlags.limits,
+					creds:   execFlags.creds,
+				})...)
+			}
+			if workdir != "" {
+				// the workdir is removed before outputs are uploaded, so read output files into memory first
+				for i := range outputs {
+					if outputs[i].Path == "" {
+						continue
+					}
+					content, err := os.ReadFile(outputs[i].Path)
+					if err != nil {
+						// leave missing files alone, they are handled during upload
+						continue
+					}
+					outputs[i].Content = content
+					outputs[i].Path = ""
+				}
+			}
 			return res, outputs, err
 		})
 	},
 }
 
+// outNames returns the names of the output files passed to --out.
+func outNames(out []string) map[string]bool {
+	names := map[string]bool{}
+	for _, o := range out {
+		name, _, _ := strings.Cut(o, "=")
+		names[name] = true
+	}
+	return names
+}
+
+// withoutOutputs drops the output files reported by markers that are named like one of names, warning about each.
+func withoutOutputs(cmdOutputs []result.OutputFileUpload, names map[string]bool) []result.OutputFileUpload {
+	kept := make([]result.OutputFileUpload, 0, len(cmdOutputs))
+	for _, output := range cmdOutputs {
+		if !output.Stdout && !output.Stderr && names[output.Name] {
+			slog.Warn("Ignoring output marker named like an --out output", "name", output.Name)
+			continue
+		}
+		kept = append(kept, output)
+	}
+	return kept
+}
+
+// envFlagsSet returns whether any flag changing the binary's environment is set.
+func envFlagsSet() bool {
+	return execFlags.clearEnv || len(execFlags.passEnv) > 0 || len(execFlags.env) > 0 || len(execFlags.envFile) > 0 || len(execFlags.envFromBlob) > 0
+}
+
+func makeChildEnv(envBlobPaths []string) (*childEnv, error) {
+	env, err := newChildEnv(os.Environ(), execFlags.clearEnv, execFlags.passEnv)
+	if err != nil {
+		return nil, err
+	}
+	for _, envFile := range execFlags.envFile {
+		if err := env.loadFile(envFile, envSourceFile); err != nil {
+			return nil, err
+		}
+	}
+	for _, envBlobPath := range envBlobPaths {
+		err := env.loadFile(envBlobPath, envSourceBlob)
+		_ = os.Remove(envBlobPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := env.setPairs(execFlags.env, envSourceFlag); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
 func init() {
 	rootCmd.AddCommand(execCmd)
 	execCmd.Flags().StringArrayVar(&execFlags.in, "in", nil, "List of input files that should be created, in the format input-file-path=input-blob-id. These files will be downloaded from Prodvana and saved to the specified paths before the binary executes.")
 	execCmd.Flags().StringArrayVar(&execFlags.out, "out", nil, "List of output files to capture, in the format of output-name=output-file-path. These files will be uploaded to Prodvana.")
 	execCmd.Flags().Int32SliceVar(&execFlags.successExitCodes, "success-exit-codes", nil, "List of successful exit codes, used in the event that the program exited but an output file is missing. If the output file is missing and the exit code is a successful exit code as defined here, then the script will fail with an error (and no json output). Defaults to 0.")
+	execCmd.Flags().StringArrayVar(&execFlags.env, "env", nil, "Environment variable to set for the binary, in the format KEY=VALUE.")
+	execCmd.Flags().StringArrayVar(&execFlags.envFile, "env-file", nil, "Path to a file of KEY=VALUE lines to add to the binary's environment.")
+	execCmd.Flags().StringArrayVar(&execFlags.envFromBlob, "env-from-blob", nil, "ID of a Prodvana blob containing KEY=VALUE lines to add to the binary's environment.")
+	execCmd.Flags().BoolVar(&execFlags.clearEnv, "clear-env", false, "Do not inherit pvn-wrapper's environment, except for variables matching --pass-env.")
+	execCmd.Flags().StringArrayVar(&execFlags.passEnv, "pass-env", nil, "Glob pattern of environment variables to inherit when --clear-env is set, e.g. AWS_*.")
+	execCmd.Flags().Uint64Var(&execFlags.limits.addressSpaceMb, "limit-address-space-mb", 0, "Maximum address space of the binary, in MB. 0 means unlimited.")
+	execCmd.Flags().Uint64Var(&execFlags.limits.cpuSeconds, "limit-cpu-seconds", 0, "Maximum CPU time of the binary, in seconds. 0 means unlimited.")
+	execCmd.Flags().Uint64Var(&execFlags.limits.openFiles, "limit-open-files", 0, "Maximum number of open files of the binary. 0 means unlimited.")
+	execCmd.Flags().Uint64Var(&execFlags.limits.processes, "limit-processes", 0, "Maximum number of processes for the user the binary runs as. 0 means unlimited.")
+	execCmd.Flags().IntVar(&execFlags.creds.uid, "uid", -1, "Run the binary as this user ID. Defaults to pvn-wrapper's user.")
+	execCmd.Flags().IntVar(&execFlags.creds.gid, "gid", -1, "Run the binary as this group ID. Defaults to pvn-wrapper's group.")
+	execCmd.Flags().BoolVar(&execFlags.tempWorkdir, "temp-workdir", false, "Run the binary in a temporary working directory that is removed afterwards.")
+	execCmd.Flags().BoolVar(&execFlags.recordInvocation, "record-invocation", false, "Upload the command and flags as the pvn-invocation output file, so the job can be rerun with pvn-wrapper replay.")
+	execCmd.Flags().BoolVar(&execFlags.pty, "pty", false, "Run the binary under a pseudo-terminal. Its stdout and stderr are combined into stdout.")
+	execCmd.Flags().BoolVar(&execFlags.stripAnsi, "strip-ansi", false, "Remove ANSI escape sequences from the uploaded stdout when running with --pty.")
+	execCmd.Flags().StringVar(&execFlags.onSuccess, "on-success", "", "Shell command to run after the binary exits with a successful exit code.")
+	execCmd.Flags().StringVar(&execFlags.onFailure, "on-failure", "", "Shell command to run after the binary fails to execute or exits with an unsuccessful exit code.")
 }
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecPreRun(t *testing.T) {
+	prev := execFlags
+	t.Cleanup(func() { execFlags = prev })
+	for _, tc := range []struct {
+		name        string
+		pty         bool
+		stripAnsi   bool
+		expectedErr string
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:      "strip ansi with pty",
+			pty:       true,
+			stripAnsi: true,
+		},
+		{
+			name:        "strip ansi without pty",
+			stripAnsi:   true,
+			expectedErr: "--strip-ansi only applies with --pty",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			execFlags = prev
+			execFlags.pty = tc.pty
+			execFlags.stripAnsi = tc.stripAnsi
+			err := execCmd.PreRunE(execCmd, []string{"true"})
+			if tc.expectedErr != "" {
+				require.EqualError(t, err, tc.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestWithoutOutputs(t *testing.T) {
+	cmdOutputs := []result.OutputFileUpload{
+		{Stdout: true, Content: []byte("out")},
+		{Stderr: true, Content: []byte("err")},
+		{Name: "plan", Content: []byte("from marker")},
+		{Name: "digest", Content: []byte("sha256:abc")},
+	}
+	require.Equal(t, []result.OutputFileUpload{
+		{Stdout: true, Content: []byte("out")},
+		{Stderr: true, Content: []byte("err")},
+		{Name: "digest", Content: []byte("sha256:abc")},
+	}, withoutOutputs(cmdOutputs, outNames([]string{"plan=plan.out", "state=state.json"})))
+	require.Equal(t, cmdOutputs, withoutOutputs(cmdOutputs, outNames(nil)))
+}
@@ -1,22 +1,25 @@
 package fly
 
 import (
+	"context"
 	go_errors "errors"
 	"os"
 	"os/exec"
+	"sort"
 
 	"github.com/pelletier/go-toml"
 	"github.com/pkg/errors"
 	common_config_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/common_config"
 	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/spf13/cobra"
+	"golang.org/x/exp/maps"
 )
 
 type flyCfg struct {
 	App string `toml:"app"`
 }
 
-func createIfNeeded(tomlFile string) error {
+func createIfNeeded(ctx context.Context, runner cmdutil.Runner, tomlFile string) error {
 	bytes, err := os.ReadFile(tomlFile)
 	if err != nil {
 		return errors.Wrap(err, "failed to read toml file")
@@ -25,7 +28,7 @@ func createIfNeeded(tomlFile string) error {
 	if err := toml.Unmarshal(bytes, &cfg); err != nil {
 		return errors.Wrap(err, "failed to unmarshal toml file")
 	}
-	_, err = flyStatus(tomlFile)
+	_, err = flyStatus(ctx, runner, tomlFile)
 	if err == nil {
 		return nil
 	}
@@ -38,7 +41,59 @@ func createIfNeeded(tomlFile string) error {
 		"create",
 		cfg.App,
 	)
-	return cmdutil.RunCmd(createCmd)
+	return runner.Run(ctx, createCmd)
+}
+
+func runApply(ctx context.Context, runner cmdutil.Runner) error {
+	cfg, err := getServiceConfig()
+	if err != nil {
+		return err
+	}
+	tomlFile, err := makeTomlFile(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tomlFile) }()
+	if err := createIfNeeded(ctx, runner, tomlFile); err != nil {
+		return err
+	}
+	envToInject := cfg.Env
+	if envToInject == nil {
+		envToInject = map[string]*common_config_pb.EnvValue{}
+	}
+	envToInject["PVN_SERVICE_ID"] = &common_config_pb.EnvValue{
+		ValueOneof: &common_config_pb.EnvValue_Value{Value: commonFlags.pvnServiceId},
+	}
+	envToInject["PVN_SERVICE_VERSION"] = &common_config_pb.EnvValue{
+		ValueOneof: &common_config_pb.EnvValue_Value{Value: commonFlags.pvnServiceVersion},
+	}
+	flyArgs := []string{
+		"deploy",
+		"--config",
+		tomlFile,
+	}
+	envKeys := maps.Keys(envToInject)
+	// sort for a stable command line
+	sort.Strings(envKeys)
+	for _, k := range envKeys {
+		v := envToInject[k]
+		switch v.GetValueOneof().(type) {
+		case *common_config_pb.EnvValue_Value:
+			flyArgs = append(flyArgs, "--env", k+"="+v.GetValue())
+		default:
+			return errors.Errorf("unrecognized env value type for %s: %T", k, v)
+		}
+	}
+	// TODO(naphat) disable waits
+	createCmd := exec.Command(
+		flyPath,
+		flyArgs...,
+	)
+	err = runner.Run(ctx, createCmd)
+	if err != nil {
+		return err
+	}
+	return nil
 }
 
 var applyCmd = &cobra.Command{
@@ -46,48 +101,7 @@ var applyCmd = &cobra.Command{
 	Short: "Create or update a Fly service",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := getServiceConfig()
-		if err != nil {
-			return err
-		}
-		tomlFile, err := makeTomlFile(cfg)
-		if err != nil {
-			return err
-		}
-		defer func() { _ = os.Remove(tomlFile) }()
-		if err := createIfNeeded(tomlFile); err != nil {
-			return err
-		}
-		envToInject := cfg.Env
-		envToInject["PVN_SERVICE_ID"] = &common_config_pb.EnvValue{
-			ValueOneof: &common_config_pb.EnvValue_Value{Value: commonFlags.pvnServiceId},
-		}
-		envToInject["PVN_SERVICE_VERSION"] = &common_config_pb.EnvValue{
-			ValueOneof: &common_config_pb.EnvValue_Value{Value: commonFlags.pvnServiceVersion},
-		}
-		flyArgs := []string{
-			"deploy",
-			"--config",
-			tomlFile,
-		}
-		for k, v := range envToInject {
-			switch v.GetValueOneof().(type) {
-			case *common_config_pb.EnvValue_Value:
-				flyArgs = append(flyArgs, "--env", k+"="+v.GetValue())
-			default:
-				return errors.Errorf("unrecognized env value type for %s: %T", k, v)
-			}
-		}
-		// TODO(naphat) disable waits
-		createCmd := exec.Command(
-			flyPath,
-			flyArgs...,
-		)
-		err = cmdutil.RunCmd(createCmd)
-		if err != nil {
-			return err
-		}
-		return nil
+		return runApply(cmd.Context(), newRunner())
 	},
 }
 
@@ -1,7 +1,7 @@
 package fly
 
 import (
-	"log"
+	"log/slog"
 	"os"
 
 	"github.com/pkg/errors"
@@ -25,6 +25,9 @@ func registerCommonFlags(cmd *cobra.Command) {
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-id"))
 	cmd.Flags().StringVar(&commonFlags.pvnServiceVersion, "pvn-service-version", "", "Prodvana Service Version")
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-version"))
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		cmdutil.AddLogAttrs("service_id", commonFlags.pvnServiceId, "service_version", commonFlags.pvnServiceVersion)
+	}
 }
 
 func getServiceConfig() (*service_pb.CompiledServiceInstanceConfig, error) {
@@ -57,6 +60,11 @@ func makeTomlFile(cfg *service_pb.CompiledServiceInstanceConfig) (string, error)
 	if err := tempFile.Close(); err != nil {
 		return "", errors.Wrap(err, "failed to close tempfile")
 	}
-	log.Printf("fly toml is:\n%s", string(tomlBytes))
+	slog.Info("Generated fly toml", "toml", string(cmdutil.RedactText(tomlBytes)))
 	return tempFile.Name(), nil
 }
+
+// newRunner returns the Runner for commands, retrying the ones that fail transiently.
+func newRunner() cmdutil.Runner {
+	return cmdutil.NewRetryingRunner(cmdutil.NewRunner(), cmdutil.ClassifyFlyError, cmdutil.DefaultRetryPolicy)
+}
@@ -1,6 +1,7 @@
 package fly
 
 import (
+	"context"
 	"encoding/json"
 	go_errors "errors"
 	"fmt"
@@ -8,6 +9,7 @@ import (
 	"os/exec"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	common_config_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/common_config"
@@ -45,8 +47,7 @@ type flyMetadata struct {
 
 var errServiceNotFound = errors.New("service not found")
 
-func flyStatus(tomlFile string) (*flyStatusOutput, error) {
-
+func flyStatus(ctx context.Context, runner cmdutil.Runner, tomlFile string) (*flyStatusOutput, error) {
 	describeCmd := exec.Command(
 		flyPath,
 		"status",
@@ -54,7 +55,7 @@ func flyStatus(tomlFile string) (*flyStatusOutput, error) {
 		tomlFile,
 		"--json",
 	)
-	output, err := cmdutil.RunCmdOutput(describeCmd)
+	output, err := runner.Output(ctx, describeCmd)
 	if err != nil {
 		if strings.Contains(err.Error(), "Could not find") {
 			return nil, errServiceNotFound
@@ -68,7 +69,7 @@ func flyStatus(tomlFile string) (*flyStatusOutput, error) {
 	return &statusOutput, nil
 }
 
-func runFetch() (*extensions_pb.FetchOutput, error) {
+func runFetch(ctx context.Context, runner cmdutil.Runner) (*extensions_pb.FetchOutput, error) {
 	cfg, err := getServiceConfig()
 	if err != nil {
 		return nil, err
@@ -78,7 +79,7 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 		return nil, err
 	}
 	defer func() { _ = os.Remove(tomlFile) }()
-	status, err := flyStatus(tomlFile)
+	status, err := flyStatus(ctx, runner, tomlFile)
 	if err != nil {
 		if go_errors.Is(err, errServiceNotFound) {
 			return &extensions_pb.FetchOutput{}, nil
@@ -139,12 +140,18 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 	}, nil
 }
 
+var fetchFlags = struct {
+	timeout time.Duration
+}{}
+
 var fetchCmd = &cobra.Command{
 	Use:   "fetch",
 	Short: "Fetch current state of an Cloud Run service",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		fetchOutput, err := runFetch()
+		ctx, cancel := cmdutil.WithTimeout(cmd.Context(), fetchFlags.timeout)
+		defer cancel()
+		fetchOutput, err := runFetch(ctx, newRunner())
 		if err != nil {
 			return err
 		}
@@ -164,4 +171,5 @@ func init() {
 	RootCmd.AddCommand(fetchCmd)
 
 	registerCommonFlags(fetchCmd)
+	fetchCmd.Flags().DurationVar(&fetchFlags.timeout, "fetch-timeout", 0, "Maximum time fetch may take, including every command it runs. 0 means no limit.")
 }
@@ -0,0 +1,165 @@
+package fly
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	common_config_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/common_config"
+	fly_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/fly"
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	service_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/service"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func setupCommonFlags(t *testing.T, env map[string]*common_config_pb.EnvValue) {
+	cfg, err := proto.Marshal(&service_pb.CompiledServiceInstanceConfig{
+		ConfigOneof: &service_pb.CompiledServiceInstanceConfig_Fly{
+			Fly: &fly_pb.FlyConfig{
+				TomlOneof: &fly_pb.FlyConfig_Inlined{Inlined: `app = "my-app"`},
+			},
+		},
+		Env: env,
+	})
+	require.NoError(t, err)
+	cfgPath := filepath.Join(t.TempDir(), "cfg.pb")
+	require.NoError(t, os.WriteFile(cfgPath, cfg, 0o600))
+	prevFlags, prevFlyPath := commonFlags, flyPath
+	t.Cleanup(func() {
+		commonFlags, flyPath = prevFlags, prevFlyPath
+	})
+	flyPath = "fly"
+	commonFlags.pvnCfgFile = cfgPath
+	commonFlags.pvnServiceId = "svc-id"
+	commonFlags.pvnServiceVersion = "svc-v2"
+}
+
+// the toml file is a temp file, so match any path
+var statusArgs = []string{"status", "--config", "*", "--json"}
+
+const statusOutput = `{
+	"ID": "my-app",
+	"Name": "my-app",
+	"AppURL": "https://my-app.fly.dev",
+	"Deployed": true,
+	"Version": 3,
+	"Machines": [
+		{"name": "m1", "config": {"env": {"PVN_SERVICE_ID": "svc-id", "PVN_SERVICE_VERSION": "svc-v2"}, "metadata": {"fly_release_version": "3"}}},
+		{"name": "m2", "config": {"env": {"PVN_SERVICE_ID": "svc-id", "PVN_SERVICE_VERSION": "svc-v2"}, "metadata": {"fly_release_version": "3"}}},
+		{"name": "m3", "config": {"env": {"PVN_SERVICE_ID": "svc-id", "PVN_SERVICE_VERSION": "svc-v1"}, "metadata": {"fly_release_version": "2"}}},
+		{"name": "m4", "config": {"env": {"PVN_SERVICE_ID": "other-svc", "PVN_SERVICE_VERSION": "other-v1"}, "metadata": {"fly_release_version": "1"}}}
+	]
+}`
+
+func TestRunApply(t *testing.T) {
+	deployArgs := []string{
+		"deploy", "--config", "*",
+		"--env", "FOO=bar",
+		"--env", "PVN_SERVICE_ID=svc-id",
+		"--env", "PVN_SERVICE_VERSION=svc-v2",
+	}
+	for _, tc := range []struct {
+		name        string
+		calls       []cmdutil.FakeCall
+		expectedErr string
+	}{
+		{
+			name: "existing app",
+			calls: []cmdutil.FakeCall{
+				{Args: statusArgs, Stdout: statusOutput},
+				{Args: deployArgs},
+			},
+		},
+		{
+			name: "new app",
+			calls: []cmdutil.FakeCall{
+				{Args: statusArgs, Stderr: "Error: Could not find App", ExitCode: 1},
+				{Args: []string{"app", "create", "my-app"}},
+				{Args: deployArgs},
+			},
+		},
+		{
+			name: "status fails",
+			calls: []cmdutil.FakeCall{
+				{Args: statusArgs, Stderr: "Error: unauthorized", ExitCode: 1},
+			},
+			expectedErr: "unauthorized",
+		},
+		{
+			name: "deploy fails",
+			calls: []cmdutil.FakeCall{
+				{Args: statusArgs, Stdout: statusOutput},
+				{Args: deployArgs, ExitCode: 1},
+			},
+			expectedErr: "exit status 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, map[string]*common_config_pb.EnvValue{
+				"FOO": {ValueOneof: &common_config_pb.EnvValue_Value{Value: "bar"}},
+			})
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			err := runApply(context.Background(), runner)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
+
+func TestRunFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		calls          []cmdutil.FakeCall
+		expectedObject bool
+		expectedStatus extensions_pb.ExternalObject_Status
+		// version -> replicas
+		expectedVersions map[string]int32
+		// version -> active
+		expectedActive map[string]bool
+	}{
+		{
+			name: "missing app",
+			calls: []cmdutil.FakeCall{
+				{Args: statusArgs, Stderr: "Error: Could not find App", ExitCode: 1},
+			},
+		},
+		{
+			name:             "deployed app",
+			calls:            []cmdutil.FakeCall{{Args: statusArgs, Stdout: statusOutput}},
+			expectedObject:   true,
+			expectedStatus:   extensions_pb.ExternalObject_SUCCEEDED,
+			expectedVersions: map[string]int32{"svc-v2": 2, "svc-v1": 1, "": 1},
+			expectedActive:   map[string]bool{"svc-v2": true, "svc-v1": false, "": false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t, nil)
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			output, err := runFetch(context.Background(), runner)
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+			if !tc.expectedObject {
+				require.Empty(t, output.Objects)
+				return
+			}
+			require.Len(t, output.Objects, 1)
+			obj := output.Objects[0]
+			require.Equal(t, tc.expectedStatus, obj.Status)
+			versions := map[string]int32{}
+			active := map[string]bool{}
+			for _, version := range obj.Versions {
+				versions[version.Version] = version.Replicas
+				active[version.Version] = version.Active
+			}
+			require.Equal(t, tc.expectedVersions, versions)
+			require.Equal(t, tc.expectedActive, active)
+		})
+	}
+}
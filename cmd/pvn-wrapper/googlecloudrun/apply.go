@@ -1,6 +1,7 @@
 package googlecloudrun
 
 import (
+	"context"
 	"os"
 	"os/exec"
 
@@ -55,35 +56,41 @@ func patchSpecFile(specFilePath, pvnServiceId, pvnServiceVersion string) (string
 	return tempFile.Name(), nil
 }
 
+func runApply(ctx context.Context, runner cmdutil.Runner) error {
+	newSpecPath, err := patchSpecFile(commonFlags.specFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(newSpecPath) }()
+	createCmd := exec.Command(
+		gcloudPath,
+		"--project",
+		commonFlags.gcpProject,
+		"run",
+		"services",
+		"replace",
+		"--region",
+		commonFlags.region,
+		newSpecPath,
+	)
+	err = runner.Run(ctx, createCmd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Create or update a Google Cloud Run service",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := gcloudAuth(); err != nil {
-			return err
-		}
-		newSpecPath, err := patchSpecFile(commonFlags.specFile, commonFlags.pvnServiceId, commonFlags.pvnServiceVersion)
-		if err != nil {
-			return err
-		}
-		defer func() { _ = os.Remove(newSpecPath) }()
-		createCmd := exec.Command(
-			gcloudPath,
-			"--project",
-			commonFlags.gcpProject,
-			"run",
-			"services",
-			"replace",
-			"--region",
-			commonFlags.region,
-			newSpecPath,
-		)
-		err = cmdutil.RunCmd(createCmd)
-		if err != nil {
+		ctx := cmd.Context()
+		runner := newRunner()
+		if err := gcloudAuth(ctx, runner); err != nil {
 			return err
 		}
-		return nil
+		return runApply(ctx, runner)
 	},
 }
 
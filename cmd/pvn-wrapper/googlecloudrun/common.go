@@ -1,6 +1,7 @@
 package googlecloudrun
 
 import (
+	"context"
 	"os"
 	"os/exec"
 
@@ -28,9 +29,17 @@ func registerCommonFlags(cmd *cobra.Command) {
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-id"))
 	cmd.Flags().StringVar(&commonFlags.pvnServiceVersion, "pvn-service-version", "", "Prodvana Service Version")
 	cmdutil.Must(cmd.MarkFlagRequired("pvn-service-version"))
+	cmd.PreRun = func(cmd *cobra.Command, args []string) {
+		cmdutil.AddLogAttrs(
+			"service_id", commonFlags.pvnServiceId,
+			"service_version", commonFlags.pvnServiceVersion,
+			"gcp_project", commonFlags.gcpProject,
+			"region", commonFlags.region,
+		)
+	}
 }
 
-func gcloudAuth() error {
+func gcloudAuth(ctx context.Context, runner cmdutil.Runner) error {
 	credentials := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
 	if credentials == "" {
 		return errors.New("GOOGLE_APPLICATION_CREDENTIALS environment variable must be set")
@@ -48,5 +57,10 @@ func gcloudAuth() error {
 	if err != nil {
 		return errors.Wrap(err, "failed to close temp file")
 	}
-	return cmdutil.RunCmd(exec.Command(gcloudPath, "auth", "activate-service-account", "--key-file", tmpFile.Name()))
+	return runner.Run(ctx, exec.Command(gcloudPath, "auth", "activate-service-account", "--key-file", tmpFile.Name()))
+}
+
+// newRunner returns the Runner for commands, retrying the ones that fail transiently.
+func newRunner() cmdutil.Runner {
+	return cmdutil.NewRetryingRunner(cmdutil.NewRunner(), cmdutil.ClassifyGcloudError, cmdutil.DefaultRetryPolicy)
 }
@@ -1,11 +1,13 @@
 package googlecloudrun
 
 import (
+	"context"
 	go_errors "errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	common_config_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/common_config"
@@ -30,7 +32,7 @@ func unmarshalKnativeService(bytes []byte) (*knative_serving.Service, error) {
 	return &serviceSpec, nil
 }
 
-func describeService(service string) (*knative_serving.Service, error) {
+func describeService(ctx context.Context, runner cmdutil.Runner, service string) (*knative_serving.Service, error) {
 	describeCmd := exec.Command(
 		gcloudPath,
 		"--project",
@@ -44,7 +46,7 @@ func describeService(service string) (*knative_serving.Service, error) {
 		"--format",
 		"yaml",
 	)
-	output, err := cmdutil.RunCmdOutput(describeCmd)
+	output, err := runner.Output(ctx, describeCmd)
 	if err != nil {
 		if strings.Contains(err.Error(), "Cannot find service") {
 			return nil, errServiceNotFound
@@ -63,7 +65,7 @@ func getConditionStatus(conditions apis.Conditions, condType apis.ConditionType)
 	return corev1.ConditionUnknown
 }
 
-func runFetch() (*extensions_pb.FetchOutput, error) {
+func runFetch(ctx context.Context, runner cmdutil.Runner) (*extensions_pb.FetchOutput, error) {
 	specBytes, err := os.ReadFile(commonFlags.specFile)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to read spec file")
@@ -89,7 +91,7 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 			},
 		},
 	}
-	currentState, err := describeService(name)
+	currentState, err := describeService(ctx, runner, name)
 	if err != nil {
 		if go_errors.Is(err, errServiceNotFound) {
 			return &extensions_pb.FetchOutput{
@@ -121,15 +123,22 @@ func runFetch() (*extensions_pb.FetchOutput, error) {
 	}, nil
 }
 
+var fetchFlags = struct {
+	timeout time.Duration
+}{}
+
 var fetchCmd = &cobra.Command{
 	Use:   "fetch",
 	Short: "Fetch current state of an Cloud Run service",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if err := gcloudAuth(); err != nil {
+		ctx, cancel := cmdutil.WithTimeout(cmd.Context(), fetchFlags.timeout)
+		defer cancel()
+		runner := newRunner()
+		if err := gcloudAuth(ctx, runner); err != nil {
 			return err
 		}
-		fetchOutput, err := runFetch()
+		fetchOutput, err := runFetch(ctx, runner)
 		if err != nil {
 			return err
 		}
@@ -149,4 +158,5 @@ func init() {
 	RootCmd.AddCommand(fetchCmd)
 
 	registerCommonFlags(fetchCmd)
+	fetchCmd.Flags().DurationVar(&fetchFlags.timeout, "fetch-timeout", 0, "Maximum time fetch may take, including every command it runs. 0 means no limit.")
 }
@@ -0,0 +1,136 @@
+package googlecloudrun
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	extensions_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes/extensions"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func setupCommonFlags(t *testing.T) {
+	specPath := filepath.Join(t.TempDir(), "service.yaml")
+	require.NoError(t, os.WriteFile(specPath, []byte(`apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: my-service
+`), 0o600))
+	prevFlags, prevGcloudPath := commonFlags, gcloudPath
+	t.Cleanup(func() {
+		commonFlags, gcloudPath = prevFlags, prevGcloudPath
+	})
+	gcloudPath = "gcloud"
+	commonFlags.gcpProject = "my-project"
+	commonFlags.region = "us-central1"
+	commonFlags.specFile = specPath
+	commonFlags.pvnServiceId = "svc-id"
+	commonFlags.pvnServiceVersion = "svc-v1"
+}
+
+var describeArgs = []string{
+	"--project", "my-project", "run", "services", "describe", "--region", "us-central1", "my-service", "--format", "yaml",
+}
+
+func describeOutput(serviceId, version, ready string) string {
+	return `apiVersion: serving.knative.dev/v1
+kind: Service
+metadata:
+  name: my-service
+  annotations:
+    prodvana.io/id: ` + serviceId + `
+    prodvana.io/version: ` + version + `
+status:
+  conditions:
+  - type: Ready
+    status: "` + ready + `"
+`
+}
+
+func TestRunApply(t *testing.T) {
+	replaceArgs := []string{"--project", "my-project", "run", "services", "replace", "--region", "us-central1", "*"}
+	for _, tc := range []struct {
+		name        string
+		calls       []cmdutil.FakeCall
+		expectedErr string
+	}{
+		{
+			name:  "replace",
+			calls: []cmdutil.FakeCall{{Args: replaceArgs}},
+		},
+		{
+			name:        "replace fails",
+			calls:       []cmdutil.FakeCall{{Args: replaceArgs, ExitCode: 1}},
+			expectedErr: "exit status 1",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t)
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			err := runApply(context.Background(), runner)
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
+
+func TestRunFetch(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		calls           []cmdutil.FakeCall
+		expectedStatus  extensions_pb.ExternalObject_Status
+		expectedVersion *string
+	}{
+		{
+			name: "missing service",
+			calls: []cmdutil.FakeCall{
+				{Args: describeArgs, Stderr: "ERROR: Cannot find service [my-service]", ExitCode: 1},
+			},
+		},
+		{
+			name:            "ready",
+			calls:           []cmdutil.FakeCall{{Args: describeArgs, Stdout: describeOutput("svc-id", "svc-v1", "True")}},
+			expectedStatus:  extensions_pb.ExternalObject_SUCCEEDED,
+			expectedVersion: ptr("svc-v1"),
+		},
+		{
+			name:            "not ready",
+			calls:           []cmdutil.FakeCall{{Args: describeArgs, Stdout: describeOutput("svc-id", "svc-v1", "Unknown")}},
+			expectedVersion: ptr("svc-v1"),
+		},
+		{
+			name:            "other service",
+			calls:           []cmdutil.FakeCall{{Args: describeArgs, Stdout: describeOutput("other-svc", "other-v1", "True")}},
+			expectedStatus:  extensions_pb.ExternalObject_SUCCEEDED,
+			expectedVersion: ptr(""),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setupCommonFlags(t)
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			output, err := runFetch(context.Background(), runner)
+			require.NoError(t, err)
+			require.Empty(t, runner.Unused())
+			require.Len(t, output.Objects, 1)
+			obj := output.Objects[0]
+			require.Equal(t, "my-service", obj.Name)
+			require.Equal(t, tc.expectedStatus, obj.Status)
+			if tc.expectedVersion == nil {
+				require.Empty(t, obj.Versions)
+				return
+			}
+			require.Len(t, obj.Versions, 1)
+			require.Equal(t, *tc.expectedVersion, obj.Versions[0].Version)
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
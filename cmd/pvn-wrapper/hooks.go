@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/pkg/errors"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/result"
+)
+
+const (
+	onSuccessHook = "on-success"
+	onFailureHook = "on-failure"
+)
+
+// hookRun describes the main command's result to a hook.
+type hookRun struct {
+	res     *pvn_wrapper_pb.Output
+	err     error
+	outputs []result.OutputFileUpload
+	env     []string
+	dir     string
+	// hooks run with the same limits and credentials as the main command
+	limits resourceLimits
+	creds  credentials
+}
+
+// writeTempOutput writes content to a temp file so hooks can read it. The caller is responsible for removing it.
+func writeTempOutput(pattern string, content []byte) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to make tempfile")
+	}
+	if _, err := f.Write(content); err != nil {
+		_ = f.Close()
+		return "", errors.Wrap(err, "failed to write to tempfile")
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.Wrap(err, "failed to close tempfile")
+	}
+	return f.Name(), nil
+}
+
+func (r hookRun) hookEnv() ([]string, func(), error) {
+	var tempFiles []string
+	cleanup := func() {
+		for _, f := range tempFiles {
+			_ = os.Remove(f)
+		}
+	}
+	env := append([]string{}, r.env...)
+	exitCode := int32(-1)
+	if r.res != nil {
+		exitCode = r.res.ExitCode
+	}
+	env = append(env, fmt.Sprintf("PVN_EXIT_CODE=%d", exitCode))
+	if r.err != nil {
+		env = append(env, "PVN_EXEC_ERROR="+r.err.Error())
+	}
+	var outputFiles []string
+	for _, output := range r.outputs {
+		switch {
+		case output.Stdout || output.Stderr:
+			name := "stdout"
+			if output.Stderr {
+				name = "stderr"
+			}
+			path, err := writeTempOutput("pvn-"+name, output.Content)
+			if err != nil {
+				cleanup()
+				return nil, nil, err
+			}
+			tempFiles = append(tempFiles, path)
+			if r.creds.isSet() {
+				// the temp file is only readable by its owner
+				if err := chownTree(path, r.creds); err != nil {
+					cleanup()
+					return nil, nil, err
+				}
+			}
+			env = append(env, fmt.Sprintf("PVN_%s_FILE=%s", strings.ToUpper(name), path))
+		case output.Path != "":
+			outputFiles = append(outputFiles, output.Name+"="+output.Path)
+		}
+	}
+	// newline separated list of output-name=output-file-path, matching the format of --out
+	env = append(env, "PVN_OUTPUT_FILES="+strings.Join(outputFiles, "\n"))
+	return env, cleanup, nil
+}
+
+func makeHookCmd(ctx context.Context, command string, env []string, run hookRun) (*exec.Cmd, error) {
+	hookCmd := exec.CommandContext(ctx, "sh", "-c", command)
+	hookCmd.Env = env
+	hookCmd.Dir = run.dir
+	if err := applyLimits(hookCmd, run.limits, run.creds); err != nil {
+		return nil, err
+	}
+	return hookCmd, nil
+}
+
+// runHook runs a post-run hook and returns its stdout and stderr as output files.
+// Hook failures are logged and otherwise ignored so that they never mask the main command's result.
+func runHook(ctx context.Context, name, command string, run hookRun) []result.OutputFileUpload {
+	slog.Info("Running hook", "hook", name, "command", command)
+	env, cleanup, err := run.hookEnv()
+	if err != nil {
+		slog.Warn("Failed to prepare hook", "hook", name, "error", err)
+		return nil
+	}
+	defer cleanup()
+	hookCmd, err := makeHookCmd(ctx, command, env, run)
+	if err != nil {
+		slog.Warn("Failed to prepare hook", "hook", name, "error", err)
+		return nil
+	}
+	hookRes, hookOutputs, err := result.RunCmd(hookCmd)
+	if err != nil {
+		slog.Warn("Failed to run hook", "hook", name, "error", err)
+		return nil
+	}
+	if hookRes.ExitCode != 0 {
+		slog.Warn("Hook exited with non-zero exit code", "hook", name, "exit_code", hookRes.ExitCode)
+	}
+	files := make([]result.OutputFileUpload, 0, len(hookOutputs))
+	for _, output := range hookOutputs {
+		// prefix everything, including outputs from markers, to avoid clashing with the main command's outputs
+		suffix := output.Name
+		if output.Stdout {
+			suffix = "stdout"
+		} else if output.Stderr {
+			suffix = "stderr"
+		}
+		files = append(files, result.OutputFileUpload{
+			Name:    fmt.Sprintf("pvn-hook-%s-%s", name, suffix),
+			Content: output.Content,
+		})
+	}
+	return files
+}
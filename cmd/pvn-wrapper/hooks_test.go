@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/stretchr/testify/require"
+)
+
+func envMap(env []string) map[string]string {
+	m := map[string]string{}
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		m[k] = v
+	}
+	return m
+}
+
+func TestHookEnv(t *testing.T) {
+	run := hookRun{
+		res: &pvn_wrapper_pb.Output{ExitCode: 3},
+		err: errors.New("oops"),
+		outputs: []result.OutputFileUpload{
+			{Stdout: true, Content: []byte("out")},
+			{Stderr: true, Content: []byte("err")},
+			{Name: "plan", Path: "/work/plan.out"},
+			{Name: "pvn-invocation", Content: []byte("{}")},
+		},
+		env:   []string{"FOO=bar"},
+		creds: credentials{uid: -1, gid: -1},
+	}
+	env, cleanup, err := run.hookEnv()
+	require.NoError(t, err)
+	vars := envMap(env)
+	require.Equal(t, "bar", vars["FOO"])
+	require.Equal(t, "3", vars["PVN_EXIT_CODE"])
+	require.Equal(t, "oops", vars["PVN_EXEC_ERROR"])
+	require.Equal(t, "plan=/work/plan.out", vars["PVN_OUTPUT_FILES"])
+	for name, want := range map[string]string{"PVN_STDOUT_FILE": "out", "PVN_STDERR_FILE": "err"} {
+		content, err := os.ReadFile(vars[name])
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+	}
+	cleanup()
+	require.NoFileExists(t, vars["PVN_STDOUT_FILE"])
+	require.NoFileExists(t, vars["PVN_STDERR_FILE"])
+
+	// the binary failed to execute
+	env, cleanup, err = hookRun{creds: credentials{uid: -1, gid: -1}}.hookEnv()
+	require.NoError(t, err)
+	defer cleanup()
+	vars = envMap(env)
+	require.Equal(t, "-1", vars["PVN_EXIT_CODE"])
+	require.NotContains(t, vars, "PVN_EXEC_ERROR")
+	require.Equal(t, "", vars["PVN_OUTPUT_FILES"])
+}
+
+func TestRunHook(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run with sh")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "plan.out"), []byte("plan\n"), 0o600))
+	outputs := runHook(context.Background(), onFailureHook, `cat "$PVN_STDOUT_FILE" plan.out; echo "::pvn-output name=summary::ok"; echo "exit $PVN_EXIT_CODE" >&2; exit 1`, hookRun{
+		res:     &pvn_wrapper_pb.Output{ExitCode: 2},
+		outputs: []result.OutputFileUpload{{Stdout: true, Content: []byte("out ")}},
+		env:     os.Environ(),
+		dir:     dir,
+		creds:   credentials{uid: -1, gid: -1},
+	})
+	contents := map[string]string{}
+	for _, output := range outputs {
+		require.Empty(t, output.Path)
+		require.False(t, output.Stdout || output.Stderr)
+		contents[output.Name] = string(output.Content)
+	}
+	require.Equal(t, map[string]string{
+		"pvn-hook-on-failure-stdout":  "out plan\n",
+		"pvn-hook-on-failure-stderr":  "exit 2\n",
+		"pvn-hook-on-failure-summary": "ok",
+	}, contents)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+)
+
+// Name of the output file that describes how exec was invoked, so that the job can be replayed.
+const invocationOutputName = "pvn-invocation"
+
+type invocationInput struct {
+	Path   string `json:"path"`
+	BlobId string `json:"blobId"`
+}
+
+type invocationOutput struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// invocation records the exec flags and arguments needed to replay a job.
+// Values passed with --env are not recorded since they may be sensitive, only their keys are.
+// Arguments are redacted like in logs, so secrets passed as flags do not leave the host.
+type invocation struct {
+	Args             []string           `json:"args"`
+	Inputs           []invocationInput  `json:"inputs"`
+	Outputs          []invocationOutput `json:"outputs"`
+	SuccessExitCodes []int32            `json:"successExitCodes"`
+	ClearEnv         bool               `json:"clearEnv"`
+	PassEnv          []string           `json:"passEnv"`
+	EnvKeys          []string           `json:"envKeys"`
+	EnvFiles         []string           `json:"envFiles"`
+	EnvFromBlobs     []string           `json:"envFromBlobs"`
+	Pty              bool               `json:"pty"`
+	StripAnsi        bool               `json:"stripAnsi"`
+}
+
+func splitFlagPair(value, flag, format string) (string, string, error) {
+	components := strings.SplitN(value, "=", 2)
+	if len(components) != 2 {
+		return "", "", errors.Errorf("--%s must be in the format %s", flag, format)
+	}
+	return components[0], components[1], nil
+}
+
+func currentInvocation(args []string, successExitCodes []int32) (*invocation, error) {
+	inv := &invocation{
+		Args:             cmdutil.RedactArgs(args),
+		SuccessExitCodes: successExitCodes,
+		ClearEnv:         execFlags.clearEnv,
+		PassEnv:          execFlags.passEnv,
+		EnvFiles:         execFlags.envFile,
+		EnvFromBlobs:     execFlags.envFromBlob,
+		Pty:              execFlags.pty,
+		StripAnsi:        execFlags.stripAnsi,
+	}
+	for _, in := range execFlags.in {
+		path, blobId, err := splitFlagPair(in, "in", "input-file-path=input-blob-id")
+		if err != nil {
+			return nil, err
+		}
+		inv.Inputs = append(inv.Inputs, invocationInput{Path: path, BlobId: blobId})
+	}
+	for _, out := range execFlags.out {
+		name, path, err := splitFlagPair(out, "out", "output-name=output-file")
+		if err != nil {
+			return nil, err
+		}
+		inv.Outputs = append(inv.Outputs, invocationOutput{Name: name, Path: path})
+	}
+	for _, env := range execFlags.env {
+		k, _, err := splitEnvPair(env)
+		if err != nil {
+			return nil, err
+		}
+		inv.EnvKeys = append(inv.EnvKeys, k)
+	}
+	return inv, nil
+}
+
+func (inv *invocation) marshal() ([]byte, error) {
+	b, err := json.Marshal(inv)
+	return b, errors.Wrap(err, "failed to marshal invocation")
+}
+
+func unmarshalInvocation(b []byte) (*invocation, error) {
+	var inv invocation
+	if err := json.Unmarshal(b, &inv); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal invocation")
+	}
+	if len(inv.Args) == 0 {
+		return nil, errors.New("invocation has no command to run")
+	}
+	return &inv, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCurrentInvocation(t *testing.T) {
+	prevFlags := execFlags
+	t.Cleanup(func() { execFlags = prevFlags })
+	execFlags.in = []string{"/tmp/plan.json=blob-1"}
+	execFlags.out = []string{"plan=/tmp/plan.out"}
+	execFlags.env = []string{"DB_PASSWORD=hunter2", "LOG_LEVEL=debug"}
+	execFlags.clearEnv = true
+	execFlags.passEnv = []string{"HOME"}
+
+	inv, err := currentInvocation([]string{"deploy", "--password=hunter2", "--token", "abc", "--region", "us-west-2"}, []int32{0, 2})
+	require.NoError(t, err)
+	require.Equal(t, &invocation{
+		Args:             []string{"deploy", "--password=<redacted>", "--token", "<redacted>", "--region", "us-west-2"},
+		Inputs:           []invocationInput{{Path: "/tmp/plan.json", BlobId: "blob-1"}},
+		Outputs:          []invocationOutput{{Name: "plan", Path: "/tmp/plan.out"}},
+		SuccessExitCodes: []int32{0, 2},
+		ClearEnv:         true,
+		PassEnv:          []string{"HOME"},
+		EnvKeys:          []string{"DB_PASSWORD", "LOG_LEVEL"},
+	}, inv)
+
+	b, err := inv.marshal()
+	require.NoError(t, err)
+	require.NotContains(t, string(b), "hunter2")
+	unmarshaled, err := unmarshalInvocation(b)
+	require.NoError(t, err)
+	require.Equal(t, inv, unmarshaled)
+}
+
+func TestCurrentInvocationInvalidFlags(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		in          []string
+		out         []string
+		expectedErr string
+	}{
+		{
+			name:        "in without blob",
+			in:          []string{"/tmp/plan.json"},
+			expectedErr: "--in must be in the format input-file-path=input-blob-id",
+		},
+		{
+			name:        "out without file",
+			out:         []string{"plan"},
+			expectedErr: "--out must be in the format output-name=output-file",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			prevFlags := execFlags
+			t.Cleanup(func() { execFlags = prevFlags })
+			execFlags.in = tc.in
+			execFlags.out = tc.out
+			_, err := currentInvocation([]string{"deploy"}, nil)
+			require.EqualError(t, err, tc.expectedErr)
+		})
+	}
+}
+
+func TestUnmarshalInvocationWithoutCommand(t *testing.T) {
+	_, err := unmarshalInvocation([]byte(`{"args": []}`))
+	require.EqualError(t, err, "invocation has no command to run")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/result"
+)
+
+type resourceLimits struct {
+	addressSpaceMb uint64
+	cpuSeconds     uint64
+	openFiles      uint64
+	processes      uint64
+}
+
+func (l resourceLimits) isSet() bool {
+	return l.addressSpaceMb > 0 || l.cpuSeconds > 0 || l.openFiles > 0 || l.processes > 0
+}
+
+// shimArgs returns the flags to pass to the exec-limited shim to apply these limits.
+func (l resourceLimits) shimArgs() []string {
+	var args []string
+	add := func(flag string, value uint64) {
+		if value > 0 {
+			args = append(args, fmt.Sprintf("--%s=%d", flag, value))
+		}
+	}
+	add("limit-address-space-mb", l.addressSpaceMb)
+	add("limit-cpu-seconds", l.cpuSeconds)
+	add("limit-open-files", l.openFiles)
+	add("limit-processes", l.processes)
+	return args
+}
+
+type credentials struct {
+	uid int
+	gid int
+}
+
+func (c credentials) isSet() bool {
+	return c.uid >= 0 || c.gid >= 0
+}
+
+// Name of the output file that lists the resource limits the command ran into.
+const limitsOutputName = "pvn-limits"
+
+// limitHit is a resource limit the command ran into, as reported in the pvn-limits output file.
+type limitHit struct {
+	// name of the exec flag setting the limit, e.g. limit-cpu-seconds
+	Flag    string `json:"flag"`
+	Value   uint64 `json:"value"`
+	Message string `json:"message"`
+	// set if the limit was likely hit, as told by the command's output, rather than certainly hit
+	Likely bool `json:"likely,omitempty"`
+}
+
+// limitErrors are the errors that running into the limits other than CPU time makes syscalls fail with, as printed
+// by the C library, shells, and common runtimes.
+var limitErrors = []struct {
+	flag     string
+	name     string
+	unit     string
+	limit    func(resourceLimits) uint64
+	messages []string
+}{
+	{
+		flag:     "limit-address-space-mb",
+		name:     "address space",
+		unit:     "MB",
+		limit:    func(l resourceLimits) uint64 { return l.addressSpaceMb },
+		messages: []string{"cannot allocate memory", "out of memory", "memoryerror", "std::bad_alloc"},
+	},
+	{
+		flag:     "limit-open-files",
+		name:     "open files",
+		limit:    func(l resourceLimits) uint64 { return l.openFiles },
+		messages: []string{"too many open files"},
+	},
+	{
+		flag:     "limit-processes",
+		name:     "processes",
+		limit:    func(l resourceLimits) uint64 { return l.processes },
+		messages: []string{"resource temporarily unavailable", "fork: retry", "cannot fork"},
+	},
+}
+
+// limitHits returns the resource limits the command ran into, as told by how it exited and what it printed.
+// Only CPU time limits end the command with a signal, so they are certain. The other limits make syscalls fail in the
+// command with ENOMEM, EMFILE, or EAGAIN, which the command reports in its own way. If a failed command printed one of
+// the usual messages of these errors, the limit is reported as likely hit. Commands that print these errors
+// differently, or not at all, are not detected.
+func limitHits(limits resourceLimits, state *os.ProcessState, output []byte) []limitHit {
+	if state == nil || state.Success() {
+		return nil
+	}
+	var hits []limitHit
+	if limits.cpuSeconds > 0 && cpuLimitHit(state, limits.cpuSeconds) {
+		hits = append(hits, limitHit{
+			Flag:    "limit-cpu-seconds",
+			Value:   limits.cpuSeconds,
+			Message: fmt.Sprintf("CPU time limit of %d seconds exceeded", limits.cpuSeconds),
+		})
+	}
+	lowerOutput := bytes.ToLower(output)
+	for _, limitError := range limitErrors {
+		value := limitError.limit(limits)
+		if value == 0 {
+			continue
+		}
+		for _, message := range limitError.messages {
+			if !bytes.Contains(lowerOutput, []byte(message)) {
+				continue
+			}
+			limit := strings.TrimSpace(fmt.Sprintf("%d %s", value, limitError.unit))
+			hits = append(hits, limitHit{
+				Flag:    limitError.flag,
+				Value:   value,
+				Message: fmt.Sprintf("%s limit of %s likely exceeded, the command failed with %q", limitError.name, limit, message),
+				Likely:  true,
+			})
+			break
+		}
+	}
+	return hits
+}
+
+// limitsOutputs returns the pvn-limits output file for the limits the command ran into, if any.
+// output is what the command printed.
+func limitsOutputs(limits resourceLimits, state *os.ProcessState, output []byte) []result.OutputFileUpload {
+	hits := limitHits(limits, state, output)
+	if len(hits) == 0 {
+		return nil
+	}
+	for _, hit := range hits {
+		slog.Warn("Resource limit hit", "flag", hit.Flag, "value", hit.Value, "message", hit.Message, "likely", hit.Likely)
+	}
+	content, err := json.Marshal(hits)
+	if err != nil {
+		slog.Warn("Failed to marshal resource limits hit", "error", err)
+		return nil
+	}
+	return []result.OutputFileUpload{{Name: limitsOutputName, Content: content}}
+}
+
+// makeTempWorkdir creates an isolated working directory for the command.
+// The caller is responsible for removing it with removeTempWorkdir.
+func makeTempWorkdir(creds credentials) (string, error) {
+	dir, err := os.MkdirTemp("", "pvn-workdir")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create temp workdir")
+	}
+	if creds.isSet() {
+		if err := os.Chmod(dir, 0o755); err != nil {
+			return "", errors.Wrap(err, "failed to chmod temp workdir")
+		}
+	}
+	return dir, nil
+}
+
+// removeTempWorkdir removes a working directory created by makeTempWorkdir, warning if it cannot.
+func removeTempWorkdir(dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		slog.Warn("Failed to remove temp workdir", "workdir", dir, "error", err)
+	}
+}
+
+// chownTree hands ownership of dir and everything in it over to the credentials the command runs as,
+// so that an unprivileged command can use its workdir and input files.
+func chownTree(dir string, creds credentials) error {
+	return filepath.Walk(dir, func(path string, _ os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := os.Lchown(path, creds.uid, creds.gid); err != nil {
+			return errors.Wrapf(err, "failed to chown %s to %d:%d", path, creds.uid, creds.gid)
+		}
+		return nil
+	})
+}
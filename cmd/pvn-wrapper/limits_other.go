@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+func applyLimits(cmd *exec.Cmd, limits resourceLimits, creds credentials) error {
+	if limits.isSet() || creds.isSet() {
+		return errors.New("resource limits and --uid/--gid are not supported on this platform")
+	}
+	return nil
+}
+
+func cpuLimitHit(state *os.ProcessState, cpuSeconds uint64) bool {
+	return false
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShimArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		limits   resourceLimits
+		expected []string
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name:     "some limits",
+			limits:   resourceLimits{cpuSeconds: 60, processes: 10},
+			expected: []string{"--limit-cpu-seconds=60", "--limit-processes=10"},
+		},
+		{
+			name:   "all limits",
+			limits: resourceLimits{addressSpaceMb: 512, cpuSeconds: 60, openFiles: 100, processes: 10},
+			expected: []string{
+				"--limit-address-space-mb=512", "--limit-cpu-seconds=60", "--limit-open-files=100", "--limit-processes=10",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.limits.shimArgs())
+		})
+	}
+}
+
+func TestLimitHitsSucceeded(t *testing.T) {
+	cmd := exec.Command("go", "version")
+	require.NoError(t, cmd.Run())
+	limits := resourceLimits{cpuSeconds: 1}
+	require.Empty(t, limitHits(limits, cmd.ProcessState, nil))
+	require.Empty(t, limitHits(limits, nil, nil))
+	require.Empty(t, limitsOutputs(limits, cmd.ProcessState, []byte("Too many open files")))
+}
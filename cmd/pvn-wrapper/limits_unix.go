@@ -0,0 +1,113 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/sys/unix"
+)
+
+var execLimitedFlags = resourceLimits{}
+
+// execLimitedCmd is an internal shim used by exec to apply resource limits.
+// It sets its own rlimits, which are inherited across exec, then replaces itself with the target binary.
+var execLimitedCmd = &cobra.Command{
+	Use:    "exec-limited",
+	Short:  "Internal command used by exec to apply resource limits.",
+	Hidden: true,
+	Args:   cobra.MinimumNArgs(1),
+	// the shim replaces itself with the target binary, so it never gets to shut down logging, metrics, or tracing,
+	// and the wrapper that started it already reports on the run
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		set := func(resource int, value uint64) error {
+			if value == 0 {
+				return nil
+			}
+			return unix.Setrlimit(resource, &unix.Rlimit{Cur: value, Max: value})
+		}
+		if err := set(unix.RLIMIT_AS, execLimitedFlags.addressSpaceMb*1024*1024); err != nil {
+			return errors.Wrap(err, "failed to set address space limit")
+		}
+		if err := set(unix.RLIMIT_CPU, execLimitedFlags.cpuSeconds); err != nil {
+			return errors.Wrap(err, "failed to set CPU time limit")
+		}
+		if err := set(unix.RLIMIT_NOFILE, execLimitedFlags.openFiles); err != nil {
+			return errors.Wrap(err, "failed to set open files limit")
+		}
+		if err := set(unix.RLIMIT_NPROC, execLimitedFlags.processes); err != nil {
+			return errors.Wrap(err, "failed to set process limit")
+		}
+		binary, err := exec.LookPath(args[0])
+		if err != nil {
+			return err
+		}
+		return syscall.Exec(binary, args, os.Environ())
+	},
+}
+
+// applyLimits rewrites cmd so that it runs with the given resource limits and credentials.
+func applyLimits(cmd *exec.Cmd, limits resourceLimits, creds credentials) error {
+	if creds.isSet() {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cred := &syscall.Credential{
+			Uid: uint32(os.Getuid()),
+			Gid: uint32(os.Getgid()),
+			// drop supplementary groups of the wrapper
+			Groups: []uint32{},
+		}
+		if creds.uid >= 0 {
+			cred.Uid = uint32(creds.uid)
+		}
+		if creds.gid >= 0 {
+			cred.Gid = uint32(creds.gid)
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+	if !limits.isSet() {
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "failed to find pvn-wrapper executable")
+	}
+	shimArgs := append([]string{self, execLimitedCmd.Use}, limits.shimArgs()...)
+	shimArgs = append(shimArgs, "--")
+	cmd.Args = append(shimArgs, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+func cpuLimitHit(state *os.ProcessState, cpuSeconds uint64) bool {
+	status, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		// the kernel sends SIGKILL once the hard limit is reached
+		return state.UserTime()+state.SystemTime() >= time.Duration(cpuSeconds)*time.Second
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(execLimitedCmd)
+	execLimitedCmd.Flags().Uint64Var(&execLimitedFlags.addressSpaceMb, "limit-address-space-mb", 0, "")
+	execLimitedCmd.Flags().Uint64Var(&execLimitedFlags.cpuSeconds, "limit-cpu-seconds", 0, "")
+	execLimitedCmd.Flags().Uint64Var(&execLimitedFlags.openFiles, "limit-open-files", 0, "")
+	execLimitedCmd.Flags().Uint64Var(&execLimitedFlags.processes, "limit-processes", 0, "")
+}
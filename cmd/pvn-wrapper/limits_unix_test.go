@@ -0,0 +1,158 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsOutputs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		script   string
+		limits   resourceLimits
+		expected []result.OutputFileUpload
+	}{
+		{
+			name:   "killed for exceeding the CPU time limit",
+			script: "kill -XCPU $$",
+			limits: resourceLimits{cpuSeconds: 60},
+			expected: []result.OutputFileUpload{{
+				Name:    limitsOutputName,
+				Content: []byte(`[{"flag":"limit-cpu-seconds","value":60,"message":"CPU time limit of 60 seconds exceeded"}]`),
+			}},
+		},
+		{
+			name:   "killed before reaching the CPU time limit",
+			script: "kill -KILL $$",
+			limits: resourceLimits{cpuSeconds: 60},
+		},
+		{
+			name:   "signal without a CPU time limit",
+			script: "kill -XCPU $$",
+			limits: resourceLimits{openFiles: 100},
+		},
+		{
+			name:   "failed with out of resources errors in its output",
+			script: "echo 'sh: fork: Resource temporarily unavailable' >&2; echo 'open: Too many open files' >&2; exit 1",
+			limits: resourceLimits{openFiles: 100, processes: 10},
+			expected: []result.OutputFileUpload{{
+				Name: limitsOutputName,
+				Content: []byte(`[{"flag":"limit-open-files","value":100,"message":"open files limit of 100 likely exceeded, the command failed with \"too many open files\"","likely":true},` +
+					`{"flag":"limit-processes","value":10,"message":"processes limit of 10 likely exceeded, the command failed with \"resource temporarily unavailable\"","likely":true}]`),
+			}},
+		},
+		{
+			name:   "failed with out of memory errors in its output",
+			script: "echo 'fatal error: runtime: out of memory' >&2; exit 2",
+			limits: resourceLimits{addressSpaceMb: 512},
+			expected: []result.OutputFileUpload{{
+				Name:    limitsOutputName,
+				Content: []byte(`[{"flag":"limit-address-space-mb","value":512,"message":"address space limit of 512 MB likely exceeded, the command failed with \"out of memory\"","likely":true}]`),
+			}},
+		},
+		{
+			name:   "out of resources errors without the limit set",
+			script: "echo 'Too many open files' >&2; exit 1",
+			limits: resourceLimits{processes: 10},
+		},
+		{
+			name:   "succeeded with out of resources errors in its output",
+			script: "echo 'Too many open files' >&2",
+			limits: resourceLimits{openFiles: 100},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cmd := exec.Command("sh", "-c", tc.script)
+			output, _ := cmd.CombinedOutput()
+			require.Equal(t, tc.expected, limitsOutputs(tc.limits, cmd.ProcessState, output))
+		})
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	self, err := os.Executable()
+	require.NoError(t, err)
+	cmd := exec.Command("sh", "-c", "true")
+	require.NoError(t, applyLimits(cmd, resourceLimits{openFiles: 100}, credentials{uid: 1000, gid: -1}))
+	require.Equal(t, self, cmd.Path)
+	require.Equal(t, []string{self, "exec-limited", "--limit-open-files=100", "--", "sh", "-c", "true"}, cmd.Args)
+	require.Equal(t, &syscall.Credential{Uid: 1000, Gid: uint32(os.Getgid()), Groups: []uint32{}}, cmd.SysProcAttr.Credential)
+
+	unlimited := exec.Command("sh", "-c", "true")
+	path := unlimited.Path
+	require.NoError(t, applyLimits(unlimited, resourceLimits{}, credentials{uid: -1, gid: -1}))
+	require.Equal(t, path, unlimited.Path)
+	require.Equal(t, []string{"sh", "-c", "true"}, unlimited.Args)
+	require.Nil(t, unlimited.SysProcAttr)
+}
+
+func TestMakeHookCmdAppliesLimits(t *testing.T) {
+	self, err := os.Executable()
+	require.NoError(t, err)
+	run := hookRun{
+		limits: resourceLimits{processes: 10},
+		creds:  credentials{uid: 1000, gid: 1000},
+		dir:    "/work",
+	}
+	cmd, err := makeHookCmd(context.Background(), "echo hi", []string{"FOO=bar"}, run)
+	require.NoError(t, err)
+	require.Equal(t, []string{self, "exec-limited", "--limit-processes=10", "--", "sh", "-c", "echo hi"}, cmd.Args)
+	require.Equal(t, &syscall.Credential{Uid: 1000, Gid: 1000, Groups: []uint32{}}, cmd.SysProcAttr.Credential)
+	require.Equal(t, "/work", cmd.Dir)
+	require.Equal(t, []string{"FOO=bar"}, cmd.Env)
+}
+
+func TestHookEnvChownsTempFiles(t *testing.T) {
+	creds := credentials{uid: os.Getuid(), gid: os.Getgid()}
+	if os.Getuid() == 0 {
+		creds = credentials{uid: 12345, gid: 23456}
+	}
+	env, cleanup, err := hookRun{
+		outputs: []result.OutputFileUpload{{Stdout: true, Content: []byte("out")}},
+		creds:   creds,
+	}.hookEnv()
+	require.NoError(t, err)
+	defer cleanup()
+	info, err := os.Stat(envMap(env)["PVN_STDOUT_FILE"])
+	require.NoError(t, err)
+	stat := info.Sys().(*syscall.Stat_t)
+	require.Equal(t, uint32(creds.uid), stat.Uid)
+	require.Equal(t, uint32(creds.gid), stat.Gid)
+}
+
+func TestExecLimitedSkipsSetup(t *testing.T) {
+	cmd, _, err := rootCmd.Find([]string{"exec-limited"})
+	require.NoError(t, err)
+	require.Equal(t, execLimitedCmd, cmd)
+	// cobra runs the closest persistent hooks, which must not be the root command's
+	require.NotNil(t, cmd.PersistentPreRunE)
+	require.NoError(t, cmd.PersistentPreRunE(cmd, nil))
+}
+
+func TestChownTree(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sub", "input.json"), []byte("{}"), 0o600))
+	require.NoError(t, os.Symlink("missing", filepath.Join(dir, "dangling")))
+	creds := credentials{uid: os.Getuid(), gid: os.Getgid()}
+	if os.Getuid() == 0 {
+		creds = credentials{uid: 12345, gid: 23456}
+	}
+	require.NoError(t, chownTree(dir, creds))
+	for _, path := range []string{dir, filepath.Join(dir, "sub"), filepath.Join(dir, "sub", "input.json"), filepath.Join(dir, "dangling")} {
+		info, err := os.Lstat(path)
+		require.NoError(t, err)
+		stat := info.Sys().(*syscall.Stat_t)
+		require.Equal(t, uint32(creds.uid), stat.Uid, path)
+		require.Equal(t, uint32(creds.gid), stat.Gid, path)
+	}
+}
@@ -7,8 +7,8 @@ import (
 var pulumiPath string
 
 var RootCmd = &cobra.Command{
-	Use:     "pulumi <subcommand>",
-	Short:   "Pulumi wrapper commands",
+	Use:   "pulumi <subcommand>",
+	Short: "Pulumi wrapper commands",
 	Long: `Pulumi wrapper commands.
 
 pvn-wrapper pulumi preview ...
@@ -3,12 +3,12 @@ package pulumi
 import (
 	"bytes"
 	"context"
-	go_errors "errors"
 	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/spf13/cobra"
 )
 
@@ -39,39 +39,49 @@ pvn-wrapper terraform apply -- --show-sames
 pvn-wrapper will always pass --non-interactive.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
-		applyArgs := []string{"up"}
-		applyArgs = append(applyArgs, args...)
-		applyArgs = append(applyArgs,
-			"--non-interactive",
-		)
-		execCmd := exec.CommandContext(ctx, pulumiPath, applyArgs...)
-		var stderr bytes.Buffer
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = &stderr
-		execErr := execCmd.Run()
-		// write out stderr before doing any processing to be transparent
-		_, err := os.Stderr.Write(stderr.Bytes())
+		exitCode, err := runUp(cmd.Context(), cmdutil.NewRunner(), args)
 		if err != nil {
-			return errors.Wrap(err, "failed to write to stderr")
+			return err
 		}
-		if execErr != nil {
-			stderrString := stderr.String()
-			if strings.Contains(stderrString, "the stack is currently locked") {
-				os.Exit(upFlags.retryableExitCode)
-			}
-			// other errors, try to match original exit code
-			var exitErr *exec.ExitError
-			if go_errors.As(execErr, &exitErr) {
-				os.Exit(exitErr.ExitCode())
-			} else {
-				return errors.Wrap(execErr, "up command failed unexpectedly")
-			}
+		if exitCode != 0 {
+			cmdutil.Exit(exitCode)
 		}
 		return nil
 	},
 }
 
+// runUp runs pulumi up and returns the exit code pvn-wrapper should exit with.
+func runUp(ctx context.Context, runner cmdutil.Runner, args []string) (int, error) {
+	applyArgs := []string{"up"}
+	applyArgs = append(applyArgs, args...)
+	applyArgs = append(applyArgs,
+		"--non-interactive",
+	)
+	execCmd := exec.Command(pulumiPath, applyArgs...)
+	var stderr bytes.Buffer
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = &stderr
+	execErr := runner.Run(ctx, execCmd)
+	// write out stderr before doing any processing to be transparent
+	_, err := os.Stderr.Write(stderr.Bytes())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to write to stderr")
+	}
+	if execErr != nil {
+		stderrString := stderr.String()
+		if strings.Contains(stderrString, "the stack is currently locked") {
+			return upFlags.retryableExitCode, nil
+		}
+		// other errors, try to match original exit code
+		exitCode, ok := cmdutil.ExitCode(execErr)
+		if !ok {
+			return 0, errors.Wrap(execErr, "up command failed unexpectedly")
+		}
+		return exitCode, nil
+	}
+	return 0, nil
+}
+
 func init() {
 	RootCmd.AddCommand(upCmd)
 
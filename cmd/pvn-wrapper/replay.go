@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/prodvana/pvn-wrapper/tracing"
+	"github.com/spf13/cobra"
+)
+
+var replayFlags = struct {
+	jobId          string
+	outputFile     string
+	invocationFile string
+	keepScratch    bool
+}{}
+
+// artifact is a file produced by the original run, either uploaded as a blob or still pending in the spool.
+type artifact struct {
+	blobId string
+	path   string
+}
+
+func (a artifact) read(ctx context.Context, blobs *result.BlobClient) ([]byte, error) {
+	if a.path != "" {
+		content, err := os.ReadFile(a.path)
+		return content, errors.Wrapf(err, "failed to read %s", a.path)
+	}
+	if a.blobId == "" {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := blobs.Get(ctx, a.blobId, &buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type originalRun struct {
+	output *pvn_wrapper_pb.Output
+	stdout artifact
+	stderr artifact
+	files  map[string]artifact
+	// names of files in the order they were reported
+	fileNames []string
+}
+
+func loadOriginalRun(output *pvn_wrapper_pb.Output, pending []result.OutputFileUpload) *originalRun {
+	run := &originalRun{
+		output: output,
+		stdout: artifact{blobId: output.StdoutBlobId},
+		stderr: artifact{blobId: output.StderrBlobId},
+		files:  map[string]artifact{},
+	}
+	addFile := func(name string, a artifact) {
+		if _, ok := run.files[name]; !ok {
+			run.fileNames = append(run.fileNames, name)
+		}
+		run.files[name] = a
+	}
+	for _, file := range output.Files {
+		addFile(file.Name, artifact{blobId: file.ContentBlobId})
+	}
+	for _, file := range pending {
+		a := artifact{path: file.Path}
+		switch {
+		case file.Stdout:
+			run.stdout = a
+		case file.Stderr:
+			run.stderr = a
+		default:
+			addFile(file.Name, a)
+		}
+	}
+	return run
+}
+
+// replayPath maps a path from the original run into the scratch directory.
+// Absolute paths are nested under the scratch directory so that replaying never touches the original locations.
+// Paths that resolve outside the scratch directory, e.g. ../x, are rejected.
+func replayPath(scratch, path string) (string, error) {
+	orig := path
+	if filepath.IsAbs(path) {
+		path = strings.TrimPrefix(path, filepath.VolumeName(path))
+	}
+	joined := filepath.Join(scratch, path)
+	rel, err := filepath.Rel(scratch, joined)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.Errorf("path %s is outside the replay directory", orig)
+	}
+	return joined, nil
+}
+
+// rewriteArgs replaces from with to in args, either as a whole argument or as the value of a --flag=value argument.
+func rewriteArgs(args []string, from, to string) {
+	for i, arg := range args {
+		if arg == from {
+			args[i] = to
+		} else if strings.HasSuffix(arg, "="+from) {
+			args[i] = strings.TrimSuffix(arg, from) + to
+		}
+	}
+}
+
+type replayedRun struct {
+	output  *pvn_wrapper_pb.Output
+	stdout  []byte
+	stderr  []byte
+	files   map[string][]byte
+	missing map[string]bool
+}
+
+func (r *replayedRun) fileNames() []string {
+	names := make([]string, 0, len(r.files))
+	for name := range r.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func replay(ctx context.Context, blobs *result.BlobClient, inv *invocation, scratch string) (*replayedRun, error) {
+	args := append([]string{}, inv.Args...)
+	for _, arg := range args {
+		if strings.Contains(arg, cmdutil.Redacted) {
+			slog.Warn("Argument was redacted in the original run and is replayed as is, pass it in an --invocation-file to replay it", "arg", arg)
+		}
+	}
+	for _, in := range inv.Inputs {
+		path, err := replayPath(scratch, in.Path)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.IsAbs(in.Path) {
+			rewriteArgs(args, in.Path, path)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, errors.Wrapf(err, "failed to create directory for %s", path)
+		}
+		slog.Info("Downloading input", "path", in.Path, "blob_id", in.BlobId)
+		if err := blobs.GetFile(ctx, in.BlobId, path); err != nil {
+			return nil, err
+		}
+	}
+	outputPaths := map[string]string{}
+	for _, out := range inv.Outputs {
+		path, err := replayPath(scratch, out.Path)
+		if err != nil {
+			return nil, err
+		}
+		if filepath.IsAbs(out.Path) {
+			rewriteArgs(args, out.Path, path)
+		}
+		outputPaths[out.Name] = path
+	}
+
+	env, err := newChildEnv(os.Environ(), inv.ClearEnv, inv.PassEnv)
+	if err != nil {
+		return nil, err
+	}
+	for _, envFile := range inv.EnvFiles {
+		if _, err := os.Stat(envFile); err != nil {
+			slog.Warn("Env file from the original run is not available locally, skipping", "path", envFile)
+			continue
+		}
+		if err := env.loadFile(envFile, envSourceFile); err != nil {
+			return nil, err
+		}
+	}
+	for i, blobId := range inv.EnvFromBlobs {
+		path := filepath.Join(scratch, fmt.Sprintf(".pvn-env-blob-%d", i))
+		if err := blobs.GetFile(ctx, blobId, path); err != nil {
+			return nil, err
+		}
+		err := env.loadFile(path, envSourceBlob)
+		_ = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// --env values are not recorded, so take them from the local environment instead
+	for _, key := range inv.EnvKeys {
+		value, ok := os.LookupEnv(key)
+		if !ok {
+			slog.Warn("Variable passed with --env in the original run is not set locally, skipping", "key", key)
+			continue
+		}
+		env.set(key, value, envSourceFlag)
+	}
+
+	replayCmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	replayCmd.Env = tracing.InjectEnv(ctx, env.Environ())
+	replayCmd.Dir = scratch
+	slog.Info("Replaying command", "args", args, "dir", scratch)
+	var res *pvn_wrapper_pb.Output
+	var cmdOutputs []result.OutputFileUpload
+	if inv.Pty {
+		res, cmdOutputs, err = result.RunCmdPty(replayCmd, inv.StripAnsi)
+	} else {
+		res, cmdOutputs, err = result.RunCmd(replayCmd)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run := &replayedRun{
+		output:  res,
+		files:   map[string][]byte{},
+		missing: map[string]bool{},
+	}
+	for _, output := range cmdOutputs {
+		switch {
+		case output.Stdout:
+			run.stdout = output.Content
+		case output.Stderr:
+			run.stderr = output.Content
+		default:
+			run.files[output.Name] = output.Content
+		}
+	}
+	for name, path := range outputPaths {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, errors.Wrapf(err, "failed to read %s", path)
+			}
+			run.missing[name] = true
+			continue
+		}
+		run.files[name] = content
+	}
+	return run, nil
+}
+
+func diffContent(name string, original, replayed []byte) (string, error) {
+	if bytes.Equal(original, replayed) {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(original)),
+		B:        difflib.SplitLines(string(replayed)),
+		FromFile: "original/" + name,
+		ToFile:   "replay/" + name,
+		Context:  3,
+	})
+}
+
+// compareRuns writes the differences between the original and replayed runs and returns whether there were any.
+// Output files written by pvn-wrapper itself, like pvn-env and pvn-events, are expected to differ and are skipped.
+func compareRuns(ctx context.Context, w io.Writer, blobs *result.BlobClient, original *originalRun, replayed *replayedRun) (bool, error) {
+	different := false
+	if original.output.ExitCode != replayed.output.ExitCode {
+		different = true
+		_, _ = fmt.Fprintf(w, "Exit code: original %d, replay %d\n", original.output.ExitCode, replayed.output.ExitCode)
+	} else {
+		_, _ = fmt.Fprintf(w, "Exit code: %d\n", replayed.output.ExitCode)
+	}
+	compare := func(name string, a artifact, replayedContent []byte) error {
+		originalContent, err := a.read(ctx, blobs)
+		if err != nil {
+			return err
+		}
+		diff, err := diffContent(name, originalContent, replayedContent)
+		if err != nil {
+			return errors.Wrapf(err, "failed to diff %s", name)
+		}
+		if diff == "" {
+			_, _ = fmt.Fprintf(w, "%s: identical\n", name)
+			return nil
+		}
+		different = true
+		_, _ = fmt.Fprintf(w, "%s: differs\n%s", name, diff)
+		return nil
+	}
+	if err := compare("stdout", original.stdout, replayed.stdout); err != nil {
+		return false, err
+	}
+	if err := compare("stderr", original.stderr, replayed.stderr); err != nil {
+		return false, err
+	}
+	for _, name := range original.fileNames {
+		if strings.HasPrefix(name, "pvn-") {
+			continue
+		}
+		if replayed.missing[name] {
+			different = true
+			_, _ = fmt.Fprintf(w, "%s: not produced by replay\n", name)
+			continue
+		}
+		if err := compare(name, original.files[name], replayed.files[name]); err != nil {
+			return false, err
+		}
+	}
+	for _, name := range replayed.fileNames() {
+		if _, ok := original.files[name]; !ok && !strings.HasPrefix(name, "pvn-") {
+			different = true
+			_, _ = fmt.Fprintf(w, "%s: only produced by replay\n", name)
+		}
+	}
+	return different, nil
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay",
+	Short: "Rerun a job from pvn-wrapper exec locally and compare its outputs against the original run.",
+	Long: `Rerun a job from pvn-wrapper exec locally and compare its outputs against the original run.
+The job must have been run with pvn-wrapper exec --record-invocation, unless --invocation-file is passed.
+
+The job's --in blobs are downloaded into a scratch directory and the original command is run there
+with the same environment allowlist. Absolute --in and --out paths are moved under the scratch directory,
+and arguments referring to them are rewritten to match.
+Values passed with --env are never uploaded, so they are taken from the local environment.
+Arguments with sensitive values are uploaded redacted, and have to be filled in with --invocation-file.
+Resource limits, --uid/--gid, and hooks are not replayed.
+
+pvn-wrapper exec --record-invocation ... > output.json
+pvn-wrapper replay --output-file output.json
+
+Results that could not be reported and are still in the spool can be replayed by job ID.
+
+pvn-wrapper replay --job-id my-job
+
+Exits with 0 if the replay matches the original run and 1 if it differs.
+With --invocation-file, there is no original run to compare against, and the replay's outputs are printed instead.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if replayFlags.jobId == "" && replayFlags.outputFile == "" && replayFlags.invocationFile == "" {
+			return errors.New("one of --job-id, --output-file, or --invocation-file is required")
+		}
+		ctx := cmd.Context()
+		blobs, err := result.NewBlobClient()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blobs.Close() }()
+
+		var original *originalRun
+		switch {
+		case replayFlags.outputFile != "":
+			output, err := readOutputFile(replayFlags.outputFile)
+			if err != nil {
+				return err
+			}
+			original = loadOriginalRun(output, nil)
+		case replayFlags.jobId != "":
+			output, pending, err := result.LoadSpooled(replayFlags.jobId)
+			if err != nil {
+				return err
+			}
+			original = loadOriginalRun(output, pending)
+		}
+
+		var invBytes []byte
+		if replayFlags.invocationFile != "" {
+			invBytes, err = os.ReadFile(replayFlags.invocationFile)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read %s", replayFlags.invocationFile)
+			}
+		} else {
+			a, ok := original.files[invocationOutputName]
+			if !ok {
+				return errors.Errorf("original run has no %s output file, it has to be run with pvn-wrapper exec --record-invocation", invocationOutputName)
+			}
+			invBytes, err = a.read(ctx, blobs)
+			if err != nil {
+				return err
+			}
+		}
+		inv, err := unmarshalInvocation(invBytes)
+		if err != nil {
+			return err
+		}
+
+		scratch, err := os.MkdirTemp("", "pvn-replay")
+		if err != nil {
+			return errors.Wrap(err, "failed to create scratch directory")
+		}
+		if replayFlags.keepScratch {
+			slog.Info("Keeping scratch directory", "dir", scratch)
+		} else {
+			defer func() { _ = os.RemoveAll(scratch) }()
+		}
+		replayed, err := replay(ctx, blobs, inv, scratch)
+		if err != nil {
+			return err
+		}
+
+		if original == nil {
+			fmt.Printf("Exit code: %d\n", replayed.output.ExitCode)
+			fmt.Printf("\n=== stdout ===\n%s", replayed.stdout)
+			fmt.Printf("\n=== stderr ===\n%s", replayed.stderr)
+			for _, name := range replayed.fileNames() {
+				fmt.Printf("\n=== file %s ===\n%s", name, replayed.files[name])
+			}
+			return nil
+		}
+		different, err := compareRuns(ctx, os.Stdout, blobs, original, replayed)
+		if err != nil {
+			return err
+		}
+		if different {
+			if !replayFlags.keepScratch {
+				_ = os.RemoveAll(scratch)
+			}
+			cmdutil.Exit(1)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+	replayCmd.Flags().StringVar(&replayFlags.jobId, "job-id", "", "ID of a job whose result is still in the spool.")
+	replayCmd.Flags().StringVar(&replayFlags.outputFile, "output-file", "", "Path to the JSON output of the original pvn-wrapper exec.")
+	replayCmd.Flags().StringVar(&replayFlags.invocationFile, "invocation-file", "", "Path to a pvn-invocation file to replay, instead of the one from the original run.")
+	replayCmd.Flags().BoolVar(&replayFlags.keepScratch, "keep-scratch", false, "Keep the scratch directory after replaying, for inspection.")
+	replayCmd.MarkFlagsMutuallyExclusive("job-id", "output-file")
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayPath(t *testing.T) {
+	scratch := filepath.Join(string(filepath.Separator)+"scratch", "replay")
+	for _, tc := range []struct {
+		path     string
+		expected string
+	}{
+		{path: "out.txt", expected: filepath.Join(scratch, "out.txt")},
+		{path: filepath.Join("dir", "out.txt"), expected: filepath.Join(scratch, "dir", "out.txt")},
+		{path: filepath.Join(string(filepath.Separator)+"tmp", "out.txt"), expected: filepath.Join(scratch, "tmp", "out.txt")},
+		{path: filepath.Join("dir", "..", "out.txt"), expected: filepath.Join(scratch, "out.txt")},
+		{path: filepath.Join("..", "..", "x")},
+		{path: ".."},
+		{path: string(filepath.Separator) + strings.Join([]string{"tmp", "..", "..", "..", "x"}, string(filepath.Separator))},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			path, err := replayPath(scratch, tc.path)
+			if tc.expected == "" {
+				require.ErrorContains(t, err, "outside the replay directory")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expected, path)
+		})
+	}
+}
+
+func TestRewriteArgs(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "whole argument",
+			args:     []string{"apply", "/tmp/plan.out"},
+			expected: []string{"apply", "/scratch/tmp/plan.out"},
+		},
+		{
+			name:     "flag value",
+			args:     []string{"apply", "--plan=/tmp/plan.out"},
+			expected: []string{"apply", "--plan=/scratch/tmp/plan.out"},
+		},
+		{
+			name:     "other paths are left alone",
+			args:     []string{"apply", "/tmp/plan.out.bak", "--out=/tmp/plan.outx"},
+			expected: []string{"apply", "/tmp/plan.out.bak", "--out=/tmp/plan.outx"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rewriteArgs(tc.args, "/tmp/plan.out", "/scratch/tmp/plan.out")
+			require.Equal(t, tc.expected, tc.args)
+		})
+	}
+}
+
+func TestLoadOriginalRun(t *testing.T) {
+	run := loadOriginalRun(&pvn_wrapper_pb.Output{
+		StdoutBlobId: "stdout-blob",
+		StderrBlobId: "stderr-blob",
+		Files: []*pvn_wrapper_pb.OutputFile{
+			{Name: "plan", ContentBlobId: "plan-blob"},
+			{Name: "summary", ContentBlobId: "summary-blob"},
+		},
+	}, []result.OutputFileUpload{
+		{Stdout: true, Path: "/spool/stdout"},
+		{Name: "summary", Path: "/spool/summary"},
+		{Name: "report", Path: "/spool/report"},
+	})
+	require.Equal(t, artifact{path: "/spool/stdout"}, run.stdout)
+	require.Equal(t, artifact{blobId: "stderr-blob"}, run.stderr)
+	require.Equal(t, []string{"plan", "summary", "report"}, run.fileNames)
+	require.Equal(t, map[string]artifact{
+		"plan":    {blobId: "plan-blob"},
+		"summary": {path: "/spool/summary"},
+		"report":  {path: "/spool/report"},
+	}, run.files)
+}
+
+func TestCompareRuns(t *testing.T) {
+	dir := t.TempDir()
+	spooled := func(name, content string) artifact {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		return artifact{path: path}
+	}
+	original := &originalRun{
+		output: &pvn_wrapper_pb.Output{ExitCode: 0},
+		stdout: spooled("stdout", "hello\n"),
+		stderr: spooled("stderr", ""),
+		files: map[string]artifact{
+			"plan":           spooled("plan", "a\nb\n"),
+			"gone":           spooled("gone", "x\n"),
+			"pvn-invocation": spooled("pvn-invocation", "{}"),
+		},
+		fileNames: []string{"plan", "gone", "pvn-invocation"},
+	}
+	for _, tc := range []struct {
+		name              string
+		replayed          *replayedRun
+		expectedDifferent bool
+		expectedOutput    string
+	}{
+		{
+			name: "identical",
+			replayed: &replayedRun{
+				output:  &pvn_wrapper_pb.Output{ExitCode: 0},
+				stdout:  []byte("hello\n"),
+				files:   map[string][]byte{"plan": []byte("a\nb\n"), "gone": []byte("x\n"), "pvn-env": []byte("{}")},
+				missing: map[string]bool{},
+			},
+			expectedOutput: "Exit code: 0\nstdout: identical\nstderr: identical\nplan: identical\ngone: identical\n",
+		},
+		{
+			name: "different",
+			replayed: &replayedRun{
+				output:  &pvn_wrapper_pb.Output{ExitCode: 1},
+				stdout:  []byte("hello\n"),
+				stderr:  []byte("oops\n"),
+				files:   map[string][]byte{"plan": []byte("a\nc\n"), "extra": []byte("y\n")},
+				missing: map[string]bool{"gone": true},
+			},
+			expectedDifferent: true,
+			expectedOutput: `Exit code: original 0, replay 1
+stdout: identical
+stderr: differs
+--- original/stderr
++++ replay/stderr
+@@ -1 +1,2 @@
++oops
+ ` + `
+plan: differs
+--- original/plan
++++ replay/plan
+@@ -1,3 +1,3 @@
+ a
+-b
++c
+ ` + `
+gone: not produced by replay
+extra: only produced by replay
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			different, err := compareRuns(context.Background(), &out, nil, original, tc.replayed)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedDifferent, different)
+			require.Equal(t, tc.expectedOutput, out.String())
+		})
+	}
+}
+
+func TestReplay(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	t.Setenv("REPLAY_GREETING", "hello")
+	scratch := t.TempDir()
+	outPath := filepath.Join(string(filepath.Separator)+"tmp", "pvn-replay-test", "greeting.txt")
+	replayed, err := replay(context.Background(), nil, &invocation{
+		Args: []string{"sh", "-c", `echo "$REPLAY_GREETING"; echo warning >&2; mkdir -p "$(dirname "$1")" && echo done > "$1"`, "sh", outPath},
+		Outputs: []invocationOutput{
+			{Name: "greeting", Path: outPath},
+			{Name: "missing", Path: "missing.txt"},
+		},
+		EnvKeys: []string{"REPLAY_GREETING"},
+	}, scratch)
+	require.NoError(t, err)
+	require.Equal(t, int32(0), replayed.output.ExitCode)
+	require.Equal(t, "hello\n", string(replayed.stdout))
+	require.Equal(t, "warning\n", string(replayed.stderr))
+	// the absolute output path is moved under the scratch directory
+	require.Equal(t, map[string][]byte{"greeting": []byte("done\n")}, replayed.files)
+	require.Equal(t, map[string]bool{"missing": true}, replayed.missing)
+	require.NoFileExists(t, outPath)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/spf13/cobra"
+)
+
+var reportPendingFlags = struct {
+	jobIds []string
+}{}
+
+var reportPendingCmd = &cobra.Command{
+	Use:   "report-pending",
+	Short: "Report job results that could not be reported to Prodvana earlier.",
+	Long: `Report job results that could not be reported to Prodvana earlier.
+
+If pvn-wrapper exec fails to upload outputs or report its result, the result is saved to --spool-dir instead,
+and still written to stdout. --spool-dir should be on a disk that survives the job, e.g. a mounted volume on
+ephemeral runners. This command uploads and reports saved results, removing them once reported.
+Reporting a job's result more than once is safe.
+
+pvn-wrapper report-pending
+pvn-wrapper report-pending --job-id my-job-id
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return result.ReportPending(cmd.Context(), reportPendingFlags.jobIds)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportPendingCmd)
+	reportPendingCmd.Flags().StringArrayVar(&reportPendingFlags.jobIds, "job-id", nil, "Only report results for these job IDs. Defaults to all pending results.")
+}
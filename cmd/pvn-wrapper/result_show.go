@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+var resultCmd = &cobra.Command{
+	Use:   "result <subcommand>",
+	Short: "Inspect results of pvn-wrapper exec, for debugging.",
+}
+
+var resultShowFlags = struct {
+	noFiles bool
+}{}
+
+func readOutputFile(path string) (*pvn_wrapper_pb.Output, error) {
+	outputBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	var output pvn_wrapper_pb.Output
+	if err := protojson.Unmarshal(outputBytes, &output); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s", path)
+	}
+	return &output, nil
+}
+
+var resultShowCmd = &cobra.Command{
+	Use:   "show <output.json>",
+	Short: "Show a saved pvn-wrapper exec result, fetching its stdout, stderr, and output files.",
+	Long: `Show a saved pvn-wrapper exec result, fetching its stdout, stderr, and output files.
+
+pvn-wrapper exec ... > output.json
+pvn-wrapper result show output.json
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := readOutputFile(args[0])
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Exit code: %d\n", output.ExitCode)
+		if output.ExecError != "" {
+			fmt.Fprintf(out, "Exec error: %s\n", output.ExecError)
+		}
+		fmt.Fprintf(out, "Version: %s\n", output.Version)
+		fmt.Fprintf(out, "Hostname: %s\n", output.Hostname)
+		fmt.Fprintf(out, "Started: %s\n", time.Unix(0, output.StartTimestampNs).UTC().Format(time.RFC3339Nano))
+		fmt.Fprintf(out, "Duration: %s\n", time.Duration(output.DurationNs))
+
+		blobs, err := newBlobClient()
+		if err != nil {
+			return err
+		}
+		defer func() { _ = blobs.Close() }()
+		show := func(title, blobId string) error {
+			fmt.Fprintf(out, "\n=== %s (%s) ===\n", title, blobId)
+			if blobId == "" {
+				fmt.Fprintln(out, "(not captured)")
+				return nil
+			}
+			return blobs.Get(cmd.Context(), blobId, out)
+		}
+		if err := show("stdout", output.StdoutBlobId); err != nil {
+			return err
+		}
+		if err := show("stderr", output.StderrBlobId); err != nil {
+			return err
+		}
+		for _, file := range output.Files {
+			if resultShowFlags.noFiles {
+				fmt.Fprintf(out, "\n=== file %s (%s) ===\n", file.Name, file.ContentBlobId)
+				continue
+			}
+			if err := show("file "+file.Name, file.ContentBlobId); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resultCmd)
+	resultCmd.AddCommand(resultShowCmd)
+	resultShowCmd.Flags().BoolVar(&resultShowFlags.noFiles, "no-files", false, "List output files without fetching their contents.")
+}
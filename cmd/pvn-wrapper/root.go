@@ -1,14 +1,23 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/prodvana/pvn-wrapper/cmd/pvn-wrapper/awsecs"
 	"github.com/prodvana/pvn-wrapper/cmd/pvn-wrapper/fly"
 	"github.com/prodvana/pvn-wrapper/cmd/pvn-wrapper/googlecloudrun"
 	"github.com/prodvana/pvn-wrapper/cmd/pvn-wrapper/pulumi"
 	"github.com/prodvana/pvn-wrapper/cmd/pvn-wrapper/terraform"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/prodvana/pvn-wrapper/metrics"
+	"github.com/prodvana/pvn-wrapper/result"
+	"github.com/prodvana/pvn-wrapper/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -18,11 +27,44 @@ var (
 	date    = "unknown"
 )
 
+var rootFlags = struct {
+	logFormat         string
+	logLevel          string
+	redactKeyPatterns []string
+}{}
+
 var rootCmd = &cobra.Command{
-	Use:              "pvn-wrapper",
-	Short:            "pvn-wrapper is used to facilitate executions of jobs in Prodvana.",
-	Long:             `pvn-wrapper is used to facilitate executions of jobs in Prodvana.`,
+	Use:   "pvn-wrapper",
+	Short: "pvn-wrapper is used to facilitate executions of jobs in Prodvana.",
+	Long: `pvn-wrapper is used to facilitate executions of jobs in Prodvana.
+
+For debugging and tests, the aws, gcloud, fly, terraform, and pulumi calls made by runtime commands can be recorded
+and replayed:
+
+PVN_WRAPPER_RECORD=dir   record every call's arguments, stdin, stdout, stderr, and exit code to golden files in dir
+PVN_WRAPPER_REPLAY=dir   serve calls from the golden files in dir instead of running them
+`,
 	TraverseChildren: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		for _, pattern := range rootFlags.redactKeyPatterns {
+			if err := cmdutil.AddSensitiveKeyPattern(pattern); err != nil {
+				return err
+			}
+		}
+		subcommand := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+		if err := cmdutil.SetupLogging(os.Stderr, rootFlags.logFormat, rootFlags.logLevel, "subcommand", subcommand, "version", version); err != nil {
+			return err
+		}
+		if err := metrics.ValidateTextfilePath(metrics.TextfilePath); err != nil {
+			return err
+		}
+		metrics.Start(subcommand)
+		cmd.SetContext(tracing.Start(cmd.Context(), cmd.CommandPath(), version))
+		return nil
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cmdutil.RunExitHooks(0)
+	},
 }
 
 func init() {
@@ -31,13 +73,28 @@ func init() {
 	rootCmd.AddCommand(pulumi.RootCmd)
 	rootCmd.AddCommand(googlecloudrun.RootCmd)
 	rootCmd.AddCommand(fly.RootCmd)
+	rootCmd.PersistentFlags().StringVar(&rootFlags.logFormat, "log-format", cmdutil.LogFormatText, "Format of pvn-wrapper's own logs, one of text, json.")
+	rootCmd.PersistentFlags().StringVar(&rootFlags.logLevel, "log-level", "info", "Minimum level of pvn-wrapper's own logs, one of debug, info, warn, error.")
+	rootCmd.PersistentFlags().StringArrayVar(&rootFlags.redactKeyPatterns, "redact-key-pattern", nil, "Regular expression for additional flag, env variable, and JSON/TOML field names whose values are redacted from logs. Can be repeated.")
+	rootCmd.PersistentFlags().StringVar(&tracing.Endpoint, "otel-endpoint", "", "OTLP/HTTP endpoint to export traces to, e.g. http://localhost:4318. Defaults to the standard OTEL_EXPORTER_OTLP_* environment variables.")
+	rootCmd.PersistentFlags().StringVar(&metrics.TextfilePath, "metrics-textfile", "", "Path to write Prometheus metrics to for the node_exporter textfile collector. Must end in .prom.")
+	rootCmd.PersistentFlags().StringVar(&metrics.PushgatewayURL, "metrics-pushgateway", "", "URL of a Prometheus Pushgateway to push metrics to at the end of the run.")
+	rootCmd.PersistentFlags().DurationVar(&cmdutil.CommandTimeout, "command-timeout", 0, "Maximum time each aws, gcloud, fly, terraform, or pulumi call made by runtime commands may take before it is interrupted. 0 means no limit.")
+	rootCmd.PersistentFlags().StringVar(&result.SpoolDir, "spool-dir", result.SpoolDir, "Directory to save job results to when they cannot be reported to Prodvana, for report-pending. Must persist across runs.")
 	rootCmd.Version = version
 	rootCmd.SetVersionTemplate(fmt.Sprintf("{{ .Version }} (%s %s)\n", commit, date))
+	cmdutil.OnExit(func(exitCode int) {
+		metrics.Finish(exitCode)
+		tracing.Shutdown()
+	})
 }
 
 func main() {
 	log.SetFlags(log.Lmicroseconds | log.Lshortfile)
-	if err := rootCmd.Execute(); err != nil {
-		log.Fatal(err)
+	// canceled on Ctrl-C or SIGTERM, so that commands can clean up, e.g. stop what they started
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		cmdutil.Fatal(err)
 	}
 }
@@ -3,12 +3,12 @@ package terraform
 import (
 	"bytes"
 	"context"
-	go_errors "errors"
 	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
 	"github.com/spf13/cobra"
 )
 
@@ -40,39 +40,49 @@ pvn-wrapper terraform apply -- --lock=false plan.tfplan
 pvn-wrapper will always pass --no-color.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
-		applyArgs := []string{"apply"}
-		applyArgs = append(applyArgs, args...)
-		applyArgs = append(applyArgs,
-			"-no-color",
-		)
-		execCmd := exec.CommandContext(ctx, terraformPath, applyArgs...)
-		var stderr bytes.Buffer
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = &stderr
-		execErr := execCmd.Run()
-		// write out stderr before doing any processing to be transparent
-		_, err := os.Stderr.Write(stderr.Bytes())
+		exitCode, err := runApply(cmd.Context(), cmdutil.NewRunner(), args)
 		if err != nil {
-			return errors.Wrap(err, "failed to write to stderr")
+			return err
 		}
-		if execErr != nil {
-			stderrString := stderr.String()
-			if strings.Contains(stderrString, "Saved plan is stale") || strings.Contains(stderrString, "Error acquiring the state lock") {
-				os.Exit(applyFlags.retryableExitCode)
-			}
-			// other errors, try to match original exit code
-			var exitErr *exec.ExitError
-			if go_errors.As(execErr, &exitErr) {
-				os.Exit(exitErr.ExitCode())
-			} else {
-				return errors.Wrap(execErr, "apply command failed unexpectedly")
-			}
+		if exitCode != 0 {
+			cmdutil.Exit(exitCode)
 		}
 		return nil
 	},
 }
 
+// runApply runs terraform apply and returns the exit code pvn-wrapper should exit with.
+func runApply(ctx context.Context, runner cmdutil.Runner, args []string) (int, error) {
+	applyArgs := []string{"apply"}
+	applyArgs = append(applyArgs, args...)
+	applyArgs = append(applyArgs,
+		"-no-color",
+	)
+	execCmd := exec.Command(terraformPath, applyArgs...)
+	var stderr bytes.Buffer
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = &stderr
+	execErr := runner.Run(ctx, execCmd)
+	// write out stderr before doing any processing to be transparent
+	_, err := os.Stderr.Write(stderr.Bytes())
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to write to stderr")
+	}
+	if execErr != nil {
+		stderrString := stderr.String()
+		if strings.Contains(stderrString, "Saved plan is stale") || strings.Contains(stderrString, "Error acquiring the state lock") {
+			return applyFlags.retryableExitCode, nil
+		}
+		// other errors, try to match original exit code
+		exitCode, ok := cmdutil.ExitCode(execErr)
+		if !ok {
+			return 0, errors.Wrap(execErr, "apply command failed unexpectedly")
+		}
+		return exitCode, nil
+	}
+	return 0, nil
+}
+
 func init() {
 	RootCmd.AddCommand(applyCmd)
 
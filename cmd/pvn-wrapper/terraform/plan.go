@@ -2,7 +2,6 @@ package terraform
 
 import (
 	"context"
-	go_errors "errors"
 	"os"
 	"os/exec"
 
@@ -37,47 +36,54 @@ pvn-wrapper terraform plan -- --refresh=false
 pvn-wrapper will always pass --detailed-exitcode, --out, and --no-color.
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		ctx := context.Background()
-		planArgs := []string{"plan"}
-		planArgs = append(planArgs, args...)
-		planArgs = append(planArgs,
-			"-detailed-exitcode",
-			"-no-color",
-			"-out",
-			planFlags.planOut,
-		)
-		execCmd := exec.CommandContext(ctx, terraformPath, planArgs...)
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
-		var exitCode int
-		err := execCmd.Run()
+		exitCode, err := runPlan(cmd.Context(), cmdutil.NewRunner(), args)
 		if err != nil {
-			var exitErr *exec.ExitError
-			if go_errors.As(err, &exitErr) {
-				exitCode = exitErr.ExitCode()
-			} else {
-				return errors.Wrap(err, "plan command failed unexpectedly")
-			}
+			return err
 		}
-		if exitCode == 0 || exitCode == 2 {
-			showCommand := exec.CommandContext(ctx, terraformPath, "show", "-no-color", planFlags.planOut)
-			planExplanation, err := os.Create(planFlags.planExplanationOut)
-			if err != nil {
-				return errors.Wrapf(err, "failed to open %s", planFlags.planExplanationOut)
-			}
-			defer func() { _ = planExplanation.Close() }()
-			showCommand.Stderr = os.Stderr
-			showCommand.Stdout = planExplanation
-			err = showCommand.Run()
-			if err != nil {
-				return errors.Wrap(err, "show command failed")
-			}
-		}
-		os.Exit(exitCode)
+		cmdutil.Exit(exitCode)
 		return nil
 	},
 }
 
+// runPlan runs terraform plan, writing the plan explanation if there is a plan, and returns its exit code.
+func runPlan(ctx context.Context, runner cmdutil.Runner, args []string) (int, error) {
+	planArgs := []string{"plan"}
+	planArgs = append(planArgs, args...)
+	planArgs = append(planArgs,
+		"-detailed-exitcode",
+		"-no-color",
+		"-out",
+		planFlags.planOut,
+	)
+	execCmd := exec.Command(terraformPath, planArgs...)
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = os.Stderr
+	var exitCode int
+	err := runner.Run(ctx, execCmd)
+	if err != nil {
+		var ok bool
+		exitCode, ok = cmdutil.ExitCode(err)
+		if !ok {
+			return 0, errors.Wrap(err, "plan command failed unexpectedly")
+		}
+	}
+	if exitCode == 0 || exitCode == 2 {
+		showCommand := exec.Command(terraformPath, "show", "-no-color", planFlags.planOut)
+		planExplanation, err := os.Create(planFlags.planExplanationOut)
+		if err != nil {
+			return 0, errors.Wrapf(err, "failed to open %s", planFlags.planExplanationOut)
+		}
+		defer func() { _ = planExplanation.Close() }()
+		showCommand.Stderr = os.Stderr
+		showCommand.Stdout = planExplanation
+		err = runner.Run(ctx, showCommand)
+		if err != nil {
+			return 0, errors.Wrap(err, "show command failed")
+		}
+	}
+	return exitCode, nil
+}
+
 func init() {
 	RootCmd.AddCommand(planCmd)
 
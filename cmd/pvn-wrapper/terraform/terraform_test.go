@@ -0,0 +1,96 @@
+package terraform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunApply(t *testing.T) {
+	applyArgs := []string{"apply", "plan.tfplan", "-no-color"}
+	for _, tc := range []struct {
+		name             string
+		call             cmdutil.FakeCall
+		expectedExitCode int
+	}{
+		{
+			name: "success",
+			call: cmdutil.FakeCall{Args: applyArgs},
+		},
+		{
+			name:             "stale plan",
+			call:             cmdutil.FakeCall{Args: applyArgs, Stderr: "Error: Saved plan is stale", ExitCode: 1},
+			expectedExitCode: 42,
+		},
+		{
+			name:             "lock",
+			call:             cmdutil.FakeCall{Args: applyArgs, Stderr: "Error: Error acquiring the state lock", ExitCode: 1},
+			expectedExitCode: 42,
+		},
+		{
+			name:             "other error",
+			call:             cmdutil.FakeCall{Args: applyArgs, Stderr: "Error: invalid provider", ExitCode: 3},
+			expectedExitCode: 3,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			applyFlags.retryableExitCode = 42
+			runner := cmdutil.NewFakeRunner(tc.call)
+			exitCode, err := runApply(context.Background(), runner, []string{"plan.tfplan"})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedExitCode, exitCode)
+			require.Empty(t, runner.Unused())
+		})
+	}
+}
+
+func TestRunPlan(t *testing.T) {
+	dir := t.TempDir()
+	planFlags.planOut = filepath.Join(dir, "plan.tfplan")
+	planFlags.planExplanationOut = filepath.Join(dir, "plan.txt")
+	planArgs := []string{"plan", "-refresh=false", "-detailed-exitcode", "-no-color", "-out", planFlags.planOut}
+	showCall := cmdutil.FakeCall{Args: []string{"show", "-no-color", planFlags.planOut}, Stdout: "+ resource"}
+	for _, tc := range []struct {
+		name                string
+		calls               []cmdutil.FakeCall
+		expectedExitCode    int
+		expectedExplanation string
+	}{
+		{
+			name:                "no changes",
+			calls:               []cmdutil.FakeCall{{Args: planArgs}, showCall},
+			expectedExplanation: "+ resource",
+		},
+		{
+			name:                "changes",
+			calls:               []cmdutil.FakeCall{{Args: planArgs, ExitCode: 2}, showCall},
+			expectedExitCode:    2,
+			expectedExplanation: "+ resource",
+		},
+		{
+			name:             "error",
+			calls:            []cmdutil.FakeCall{{Args: planArgs, ExitCode: 1}},
+			expectedExitCode: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_ = os.Remove(planFlags.planExplanationOut)
+			runner := cmdutil.NewFakeRunner(tc.calls...)
+			exitCode, err := runPlan(context.Background(), runner, []string{"-refresh=false"})
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedExitCode, exitCode)
+			require.Empty(t, runner.Unused())
+			explanation, err := os.ReadFile(planFlags.planExplanationOut)
+			if tc.expectedExplanation == "" {
+				require.True(t, os.IsNotExist(err))
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedExplanation, string(explanation))
+			}
+		})
+	}
+}
@@ -1,34 +1,201 @@
 package cmdutil
 
 import (
+	"bytes"
+	"context"
 	go_errors "errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func RunCmd(cmd *exec.Cmd) error {
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	log.Printf("Running command: %s", cmd.String())
-	if err := cmd.Run(); err != nil {
-		return errors.Wrapf(err, "Command failed:\n%s", cmd.String())
+// Runner runs external commands like aws, gcloud, and fly.
+// Runtimes run commands through a Runner instead of calling exec directly so that they can be tested with a FakeRunner.
+// Commands are stopped when ctx is done or CommandTimeout passes, whichever is first.
+type Runner interface {
+	// Run runs cmd, streaming its output to cmd.Stdout and cmd.Stderr, or to os.Stdout and os.Stderr if unset.
+	Run(ctx context.Context, cmd *exec.Cmd) error
+	// Output runs cmd and returns its stdout. If cmd fails, its stderr is included in the error.
+	Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error)
+}
+
+// CommandTimeout is the maximum time a single command run by a Runner may take. 0 means no limit.
+var CommandTimeout time.Duration
+
+// KillGracePeriod is how long a command has to exit after being interrupted before it is killed.
+var KillGracePeriod = 5 * time.Second
+
+func withCommandTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return WithTimeout(ctx, CommandTimeout)
+}
+
+// WithTimeout is like context.WithTimeout, except that a timeout of 0 means no timeout.
+func WithTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// TimeoutError is returned by Runners when a command is stopped because its deadline passed,
+// either from CommandTimeout or from the context it was run with.
+type TimeoutError struct {
+	// how long the command ran before it was stopped
+	Elapsed time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Elapsed.Round(time.Millisecond))
+}
+
+// IsTimeout returns whether err is from a command that timed out.
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return go_errors.As(err, &timeoutErr)
+}
+
+// ExitError is returned by Runners when a command exits with a non-zero exit code.
+type ExitError struct {
+	ExitCode int
+	Stderr   []byte
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.ExitCode)
+}
+
+// ExitCode returns the exit code of the command that caused err, if err is from a command that exited.
+func ExitCode(err error) (int, bool) {
+	var exitErr *ExitError
+	if go_errors.As(err, &exitErr) {
+		return exitErr.ExitCode, true
+	}
+	return 0, false
+}
+
+func commandFailed(cmd *exec.Cmd, err *ExitError, withStderr bool) error {
+	if withStderr {
+		return errors.Wrapf(err, "Command failed:\n%s\n%s", RedactCommand(cmd), string(Redact(err.Stderr)))
+	}
+	return errors.Wrapf(err, "Command failed:\n%s", RedactCommand(cmd))
+}
+
+// ExecRunner runs commands on the host.
+type ExecRunner struct{}
+
+// NewRunner returns the Runner that commands should use outside of tests.
+// If PVN_WRAPPER_REPLAY is set, commands are served from recordings instead of being run.
+// If PVN_WRAPPER_RECORD is set, commands are run and recorded.
+func NewRunner() Runner {
+	if dir := os.Getenv(ReplayEnvVar); dir != "" {
+		runner, err := NewReplayRunner(dir)
+		Must(err)
+		return runner
+	}
+	if dir := os.Getenv(RecordEnvVar); dir != "" {
+		runner, err := NewRecordingRunner(ExecRunner{}, dir)
+		Must(err)
+		return runner
+	}
+	return ExecRunner{}
+}
+
+func startCmdSpan(ctx context.Context, cmd *exec.Cmd) trace.Span {
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		filepath.Base(cmd.Path),
+		trace.WithAttributes(attribute.StringSlice("args", append([]string{cmd.Args[0]}, RedactArgs(cmd.Args[1:])...))),
+	)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = tracing.InjectEnv(ctx, env)
+	return span
+}
+
+// runContext runs cmd until it exits or ctx is done. If ctx is done first, cmd is interrupted,
+// then killed if it has not exited after KillGracePeriod.
+func runContext(ctx context.Context, cmd *exec.Cmd) error {
+	ctx, cancel := withCommandTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	// bound how long Wait blocks on output pipes held open by the command's children after it exits
+	cmd.WaitDelay = KillGracePeriod
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	// interrupts are not supported on windows, kill right away there
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(KillGracePeriod):
+		slog.Warn("Command did not exit after being interrupted, killing it", "command", RedactCommand(cmd))
+		_ = cmd.Process.Kill()
+		<-done
+	}
+	if go_errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &TimeoutError{Elapsed: time.Since(start)}
+	}
+	return errors.Wrap(ctx.Err(), "command canceled")
+}
+
+func (ExecRunner) Run(ctx context.Context, cmd *exec.Cmd) (err error) {
+	span := startCmdSpan(ctx, cmd)
+	defer func() { tracing.EndSpan(span, err) }()
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	slog.Info("Running command", "command", RedactCommand(cmd))
+	if err := runContext(ctx, cmd); err != nil {
+		var exitErr *exec.ExitError
+		if go_errors.As(err, &exitErr) {
+			return commandFailed(cmd, &ExitError{ExitCode: exitErr.ExitCode()}, false)
+		}
+		return errors.Wrapf(err, "Command failed:\n%s", RedactCommand(cmd))
 	}
 	return nil
 }
 
-func RunCmdOutput(cmd *exec.Cmd) ([]byte, error) {
-	log.Printf("Running command: %s", cmd.String())
-	output, err := cmd.Output()
-	log.Printf("Command output (%s):\n%s", cmd.String(), output)
+func (ExecRunner) Output(ctx context.Context, cmd *exec.Cmd) (_ []byte, err error) {
+	span := startCmdSpan(ctx, cmd)
+	defer func() { tracing.EndSpan(span, err) }()
+	slog.Info("Running command", "command", RedactCommand(cmd))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = runContext(ctx, cmd)
+	output := stdout.Bytes()
+	slog.Info("Command output", "command", RedactCommand(cmd), "output", string(Redact(output)))
 	if err != nil {
 		var exitErr *exec.ExitError
 		if go_errors.As(err, &exitErr) {
-			return nil, errors.Wrapf(err, "Command failed:\n%s\n%s", cmd.String(), string(exitErr.Stderr))
+			return nil, commandFailed(cmd, &ExitError{ExitCode: exitErr.ExitCode(), Stderr: stderr.Bytes()}, true)
+		}
+		if IsTimeout(err) {
+			return nil, errors.Wrapf(err, "Command failed:\n%s\n%s", RedactCommand(cmd), string(Redact(stderr.Bytes())))
 		}
-		return nil, errors.Wrapf(err, "Command failed for unknown reasons:\n%s", cmd.String())
+		return nil, errors.Wrapf(err, "Command failed for unknown reasons:\n%s", RedactCommand(cmd))
 	}
 	return output, nil
 }
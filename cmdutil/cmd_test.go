@@ -0,0 +1,88 @@
+package cmdutil
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecRunnerTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands use sh")
+	}
+	prevTimeout, prevGracePeriod := CommandTimeout, KillGracePeriod
+	t.Cleanup(func() {
+		CommandTimeout, KillGracePeriod = prevTimeout, prevGracePeriod
+	})
+	CommandTimeout = 100 * time.Millisecond
+	KillGracePeriod = 200 * time.Millisecond
+
+	for _, tc := range []struct {
+		name   string
+		script string
+	}{
+		{name: "interrupted", script: "exec sleep 10"},
+		{name: "ignores interrupt", script: "trap '' INT; exec sleep 10"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			_, err := ExecRunner{}.Output(context.Background(), exec.Command("sh", "-c", tc.script))
+			require.True(t, IsTimeout(err), "expected timeout, got %v", err)
+			_, isExit := ExitCode(err)
+			require.False(t, isExit)
+			require.Less(t, time.Since(start), 5*time.Second)
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		CommandTimeout = 0
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := ExecRunner{}.Run(ctx, exec.Command("sh", "-c", "exec sleep 10"))
+		require.ErrorIs(t, err, context.Canceled)
+		require.False(t, IsTimeout(err))
+	})
+
+	t.Run("context deadline", func(t *testing.T) {
+		CommandTimeout = 0
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		err := ExecRunner{}.Run(ctx, exec.Command("sh", "-c", "exec sleep 10"))
+		require.True(t, IsTimeout(err), "expected timeout, got %v", err)
+	})
+}
+
+func TestExecRunnerRedactsStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands use sh")
+	}
+	t.Run("failed", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", `echo 'invalid credentials' >&2; echo API_TOKEN=$TOKEN >&2; exit 1`, "--password=hunter2")
+		cmd.Env = append(os.Environ(), "TOKEN=abc")
+		_, err := ExecRunner{}.Output(context.Background(), cmd)
+		require.ErrorContains(t, err, "invalid credentials\nAPI_TOKEN=<redacted>\n")
+		require.NotContains(t, err.Error(), "abc")
+		require.NotContains(t, err.Error(), "hunter2")
+		var exitErr *ExitError
+		require.ErrorAs(t, err, &exitErr)
+		// callers can still inspect the raw stderr
+		require.Contains(t, string(exitErr.Stderr), "API_TOKEN=abc")
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		prevTimeout := CommandTimeout
+		t.Cleanup(func() { CommandTimeout = prevTimeout })
+		CommandTimeout = 100 * time.Millisecond
+		cmd := exec.Command("sh", "-c", `echo API_TOKEN=$TOKEN >&2; exec sleep 10`)
+		cmd.Env = append(os.Environ(), "TOKEN=abc")
+		_, err := ExecRunner{}.Output(context.Background(), cmd)
+		require.True(t, IsTimeout(err), "expected timeout, got %v", err)
+		require.ErrorContains(t, err, "API_TOKEN=<redacted>\n")
+		require.NotContains(t, err.Error(), "abc")
+	})
+}
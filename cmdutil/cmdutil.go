@@ -1,11 +1,9 @@
 package cmdutil
 
-import (
-	"log"
-)
+import "fmt"
 
 func Must(err error) {
 	if err != nil {
-		log.Fatal(err)
+		fatal(fmt.Sprint(err))
 	}
 }
@@ -0,0 +1,70 @@
+package cmdutil
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var exitHooks = struct {
+	mu    sync.Mutex
+	hooks []func(exitCode int)
+	ran   bool
+}{}
+
+// OnExit registers a hook to run when pvn-wrapper exits, e.g. to flush telemetry.
+func OnExit(hook func(exitCode int)) {
+	exitHooks.mu.Lock()
+	defer exitHooks.mu.Unlock()
+	exitHooks.hooks = append(exitHooks.hooks, hook)
+}
+
+// RunExitHooks runs the hooks registered with OnExit. Only the first call has an effect.
+func RunExitHooks(exitCode int) {
+	exitHooks.mu.Lock()
+	defer exitHooks.mu.Unlock()
+	if exitHooks.ran {
+		return
+	}
+	exitHooks.ran = true
+	for _, hook := range exitHooks.hooks {
+		hook(exitCode)
+	}
+}
+
+// Exit runs exit hooks, then exits. Use instead of os.Exit.
+func Exit(exitCode int) {
+	RunExitHooks(exitCode)
+	os.Exit(exitCode)
+}
+
+// Fatal is like log.Fatal, but runs exit hooks first. Exit hooks see -1, the exit code of failures of pvn-wrapper itself.
+// The error is logged at ERROR, or written straight to stderr if the log level filters out errors.
+func Fatal(v ...any) {
+	fatal(fmt.Sprint(v...))
+}
+
+// Fatalf is like log.Fatalf, but runs exit hooks first, see Fatal.
+func Fatalf(format string, v ...any) {
+	fatal(fmt.Sprintf(format, v...))
+}
+
+// fatal logs msg as if from the caller of the exported function that called fatal, then exits.
+func fatal(msg string) {
+	RunExitHooks(-1)
+	ctx := context.Background()
+	handler := slog.Default().Handler()
+	if handler.Enabled(ctx, slog.LevelError) {
+		var pcs [1]uintptr
+		// skip runtime.Callers, fatal, and the exported function that called fatal
+		runtime.Callers(3, pcs[:])
+		_ = handler.Handle(ctx, slog.NewRecord(time.Now(), slog.LevelError, msg, pcs[0]))
+	} else {
+		_, _ = fmt.Fprintln(os.Stderr, msg)
+	}
+	os.Exit(1)
+}
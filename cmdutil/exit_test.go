@@ -0,0 +1,62 @@
+package cmdutil
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// runFatalChild runs the test named test in a child process with PVN_WRAPPER_TEST_FATAL set, and returns its
+// stdout and stderr, checking that it exited with 1.
+func runFatalChild(t *testing.T, test string) (string, string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$")
+	cmd.Env = append(os.Environ(), "PVN_WRAPPER_TEST_FATAL=1")
+	stdout, err := cmd.Output()
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 1, exitErr.ExitCode())
+	return string(stdout), string(exitErr.Stderr)
+}
+
+func TestFatalRunsExitHooks(t *testing.T) {
+	if os.Getenv("PVN_WRAPPER_TEST_FATAL") == "1" {
+		OnExit(func(exitCode int) {
+			fmt.Printf("exit hook ran with %d\n", exitCode)
+		})
+		Must(errors.New("connection refused"))
+		return
+	}
+	stdout, stderr := runFatalChild(t, "TestFatalRunsExitHooks")
+	require.Contains(t, stdout, "exit hook ran with -1\n")
+	require.Contains(t, stderr, "connection refused")
+}
+
+func TestFatalLogsAtError(t *testing.T) {
+	for _, level := range []string{"info", "error"} {
+		t.Run(level, func(t *testing.T) {
+			if os.Getenv("PVN_WRAPPER_TEST_FATAL") == "1" {
+				require.NoError(t, SetupLogging(os.Stderr, LogFormatText, level))
+				Fatalf("--in must be in the format %s", "input-file-path=input-blob-id")
+				return
+			}
+			_, stderr := runFatalChild(t, "TestFatalLogsAtError/"+level)
+			require.Contains(t, stderr, "level=ERROR")
+			require.Contains(t, stderr, "exit_test.go")
+			require.Contains(t, stderr, `msg="--in must be in the format input-file-path=input-blob-id"`)
+		})
+	}
+	t.Run("filtered", func(t *testing.T) {
+		if os.Getenv("PVN_WRAPPER_TEST_FATAL") == "1" {
+			// a level above ERROR filters out every log line, the error must still be printed
+			require.NoError(t, SetupLogging(os.Stderr, LogFormatText, "error+4"))
+			Fatal("connection refused")
+			return
+		}
+		_, stderr := runFatalChild(t, "TestFatalLogsAtError/filtered")
+		require.Equal(t, "connection refused\n", stderr)
+	})
+}
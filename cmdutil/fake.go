@@ -0,0 +1,137 @@
+package cmdutil
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// FakeCall is a scripted response for FakeRunner.
+type FakeCall struct {
+	// Arguments the command must be run with, excluding the binary itself.
+	// An argument ending in * matches any argument with that prefix, e.g. file://* for temp files.
+	Args []string
+	// Content the command's stdin must have, empty for commands without stdin.
+	Stdin    string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// matches returns whether a command with args and stdin matches the call.
+// Recorded calls have sensitive values redacted, so the redacted args and stdin match too.
+func (c FakeCall) matches(args []string, stdin string) bool {
+	if len(c.Args) != len(args) {
+		return false
+	}
+	if c.Stdin != stdin && c.Stdin != string(Redact([]byte(stdin))) {
+		return false
+	}
+	redactedArgs := RedactArgs(args)
+	for i, want := range c.Args {
+		if prefix, ok := strings.CutSuffix(want, "*"); ok {
+			if !strings.HasPrefix(args[i], prefix) && !strings.HasPrefix(redactedArgs[i], prefix) {
+				return false
+			}
+		} else if want != args[i] && want != redactedArgs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// FakeRunner is a Runner that returns scripted responses instead of running commands.
+// Calls are matched by arguments rather than order, so commands may be run concurrently. Each call is used at most once,
+// and if several calls match, the first unused one is used. Commands that match no remaining call fail.
+type FakeRunner struct {
+	mu    sync.Mutex
+	calls []FakeCall
+	used  []bool
+	// arguments of every command run, excluding the binary itself
+	ran [][]string
+}
+
+func NewFakeRunner(calls ...FakeCall) *FakeRunner {
+	return &FakeRunner{
+		calls: calls,
+		used:  make([]bool, len(calls)),
+	}
+}
+
+func (f *FakeRunner) next(ctx context.Context, cmd *exec.Cmd) (FakeCall, error) {
+	if err := ctx.Err(); err != nil {
+		return FakeCall{}, errors.Wrap(err, "command canceled")
+	}
+	stdin, err := captureStdin(cmd)
+	if err != nil {
+		return FakeCall{}, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	args := cmd.Args[1:]
+	f.ran = append(f.ran, args)
+	for i, call := range f.calls {
+		if !f.used[i] && call.matches(args, string(stdin)) {
+			f.used[i] = true
+			return call, nil
+		}
+	}
+	return FakeCall{}, errors.Errorf("unexpected command: %s", RedactCommand(cmd))
+}
+
+// Ran returns the arguments of every command run so far, excluding the binary itself.
+func (f *FakeRunner) Ran() [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([][]string{}, f.ran...)
+}
+
+// Unused returns the scripted calls that no command has matched yet.
+func (f *FakeRunner) Unused() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var unused []FakeCall
+	for i, call := range f.calls {
+		if !f.used[i] {
+			unused = append(unused, call)
+		}
+	}
+	return unused
+}
+
+func (f *FakeRunner) Run(ctx context.Context, cmd *exec.Cmd) error {
+	call, err := f.next(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	for _, out := range []struct {
+		w       io.Writer
+		content string
+	}{{cmd.Stdout, call.Stdout}, {cmd.Stderr, call.Stderr}} {
+		if out.w == nil {
+			continue
+		}
+		if _, err := io.WriteString(out.w, out.content); err != nil {
+			return errors.Wrap(err, "failed to write output")
+		}
+	}
+	if call.ExitCode != 0 {
+		return commandFailed(cmd, &ExitError{ExitCode: call.ExitCode}, false)
+	}
+	return nil
+}
+
+func (f *FakeRunner) Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	call, err := f.next(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if call.ExitCode != 0 {
+		return nil, commandFailed(cmd, &ExitError{ExitCode: call.ExitCode, Stderr: []byte(call.Stderr)}, true)
+	}
+	return []byte(call.Stdout), nil
+}
@@ -0,0 +1,64 @@
+package cmdutil
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeRunner(t *testing.T) {
+	ctx := context.Background()
+	runner := NewFakeRunner(
+		FakeCall{Args: []string{"describe", "--input", "file://*"}, Stdout: "first"},
+		FakeCall{Args: []string{"describe", "--input", "file://*"}, Stdout: "second"},
+		FakeCall{Args: []string{"deploy"}, Stdout: "deploying", Stderr: "denied", ExitCode: 3},
+	)
+
+	output, err := runner.Output(ctx, exec.Command("tool", "describe", "--input", "file:///tmp/a.json"))
+	require.NoError(t, err)
+	require.Equal(t, "first", string(output))
+	output, err = runner.Output(ctx, exec.Command("tool", "describe", "--input", "file:///tmp/b.json"))
+	require.NoError(t, err)
+	require.Equal(t, "second", string(output))
+	_, err = runner.Output(ctx, exec.Command("tool", "describe", "--input", "file:///tmp/c.json"))
+	require.ErrorContains(t, err, "unexpected command")
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("tool", "deploy")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = runner.Run(ctx, cmd)
+	exitCode, ok := ExitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 3, exitCode)
+	require.Equal(t, "deploying", stdout.String())
+	require.Equal(t, "denied", stderr.String())
+
+	require.Empty(t, runner.Unused())
+	require.Len(t, runner.Ran(), 4)
+}
+
+func TestFakeRunnerStdin(t *testing.T) {
+	ctx := context.Background()
+	runner := NewFakeRunner(
+		FakeCall{Args: []string{"apply"}, Stdin: "yes\n", Stdout: "applied"},
+		FakeCall{Args: []string{"apply"}, Stdout: "no input"},
+	)
+	cmd := exec.Command("tool", "apply")
+	cmd.Stdin = strings.NewReader("no\n")
+	_, err := runner.Output(ctx, cmd)
+	require.ErrorContains(t, err, "unexpected command")
+
+	cmd = exec.Command("tool", "apply")
+	cmd.Stdin = strings.NewReader("yes\n")
+	output, err := runner.Output(ctx, cmd)
+	require.NoError(t, err)
+	require.Equal(t, "applied", string(output))
+	output, err = runner.Output(ctx, exec.Command("tool", "apply"))
+	require.NoError(t, err)
+	require.Equal(t, "no input", string(output))
+}
@@ -0,0 +1,43 @@
+package cmdutil
+
+import (
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	LogFormatText = "text"
+	LogFormatJson = "json"
+)
+
+// SetupLogging configures the default slog logger. The standard log package writes to it as well.
+// attrs are attached to every log line.
+func SetupLogging(w io.Writer, format, level string, attrs ...any) error {
+	var slogLevel slog.Level
+	if err := slogLevel.UnmarshalText([]byte(level)); err != nil {
+		return errors.Wrapf(err, "invalid log level %q", level)
+	}
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     slogLevel,
+	}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case LogFormatText:
+		handler = slog.NewTextHandler(w, opts)
+	case LogFormatJson:
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		return errors.Errorf("invalid log format %q, must be one of %s, %s", format, LogFormatText, LogFormatJson)
+	}
+	slog.SetDefault(slog.New(handler).With(attrs...))
+	return nil
+}
+
+// AddLogAttrs attaches attrs to every subsequent log line.
+func AddLogAttrs(attrs ...any) {
+	slog.SetDefault(slog.Default().With(attrs...))
+}
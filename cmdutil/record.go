@@ -0,0 +1,184 @@
+package cmdutil
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// RecordEnvVar names a directory to record every command run through NewRunner's Runner into.
+	RecordEnvVar = "PVN_WRAPPER_RECORD"
+	// ReplayEnvVar names a directory of recorded commands to serve back instead of running commands.
+	ReplayEnvVar = "PVN_WRAPPER_REPLAY"
+)
+
+// recordedCall is the golden file format for a single command.
+// Arguments referring to temp files are recorded as wildcards, see FakeCall.Args, so that they match on replay.
+// Sensitive values in arguments, stdin, stdout, and stderr are redacted, the same way as in logs.
+type recordedCall struct {
+	Args     []string `json:"args"`
+	Stdin    string   `json:"stdin,omitempty"`
+	Stdout   string   `json:"stdout"`
+	Stderr   string   `json:"stderr"`
+	ExitCode int      `json:"exitCode"`
+}
+
+// generalizeArg replaces temp file paths, which change on every run, with a wildcard.
+func generalizeArg(arg string) string {
+	if i := strings.Index(arg, os.TempDir()); i >= 0 {
+		return arg[:i] + "*"
+	}
+	return arg
+}
+
+// RecordingRunner runs commands with another Runner and writes each one to a golden file in a directory.
+// Files are named <sequence>-<binary>.json, continuing the sequence of files already in the directory,
+// so one directory can hold a whole session of pvn-wrapper commands.
+type RecordingRunner struct {
+	runner Runner
+	dir    string
+	mu     sync.Mutex
+	seq    int
+}
+
+func NewRecordingRunner(runner Runner, dir string) (*RecordingRunner, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create record dir %s", dir)
+	}
+	existing, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list recorded commands")
+	}
+	return &RecordingRunner{
+		runner: runner,
+		dir:    dir,
+		seq:    len(existing),
+	}, nil
+}
+
+func (r *RecordingRunner) record(cmd *exec.Cmd, stdin []byte, stdout, stderr []byte, err error) {
+	call := recordedCall{
+		Stdin:  string(Redact(stdin)),
+		Stdout: string(Redact(stdout)),
+		Stderr: string(Redact(stderr)),
+	}
+	for _, arg := range RedactArgs(cmd.Args[1:]) {
+		call.Args = append(call.Args, generalizeArg(arg))
+	}
+	if err != nil {
+		exitCode, ok := ExitCode(err)
+		if !ok {
+			// the command never ran, there is nothing to replay
+			return
+		}
+		call.ExitCode = exitCode
+	}
+	callBytes, marshalErr := json.MarshalIndent(call, "", "  ")
+	if marshalErr != nil {
+		slog.Warn("Failed to record command", "command", RedactCommand(cmd), "error", marshalErr)
+		return
+	}
+	r.mu.Lock()
+	name := fmt.Sprintf("%04d-%s.json", r.seq, filepath.Base(cmd.Path))
+	r.seq++
+	r.mu.Unlock()
+	if writeErr := os.WriteFile(filepath.Join(r.dir, name), callBytes, 0o644); writeErr != nil {
+		slog.Warn("Failed to record command", "command", RedactCommand(cmd), "error", writeErr)
+	}
+}
+
+// captureStdin reads cmd's stdin so it can be recorded, then replaces it with a reader of the same content.
+func captureStdin(cmd *exec.Cmd) ([]byte, error) {
+	if cmd.Stdin == nil {
+		return nil, nil
+	}
+	stdin, err := io.ReadAll(cmd.Stdin)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read stdin")
+	}
+	cmd.Stdin = bytes.NewReader(stdin)
+	return stdin, nil
+}
+
+func (r *RecordingRunner) Run(ctx context.Context, cmd *exec.Cmd) error {
+	stdin, err := captureStdin(cmd)
+	if err != nil {
+		return err
+	}
+	var stdout, stderr bytes.Buffer
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, &stdout)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, &stderr)
+	err = r.runner.Run(ctx, cmd)
+	r.record(cmd, stdin, stdout.Bytes(), stderr.Bytes(), err)
+	return err
+}
+
+func (r *RecordingRunner) Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	stdin, err := captureStdin(cmd)
+	if err != nil {
+		return nil, err
+	}
+	// capture stderr ourselves so that it is recorded even when the command succeeds
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = r.runner.Run(ctx, cmd)
+	r.record(cmd, stdin, stdout.Bytes(), stderr.Bytes(), err)
+	if err != nil {
+		if exitCode, ok := ExitCode(err); ok {
+			return nil, commandFailed(cmd, &ExitError{ExitCode: exitCode, Stderr: stderr.Bytes()}, true)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+// NewReplayRunner returns a FakeRunner that serves the commands recorded in dir by a RecordingRunner.
+// Commands must match the recorded arguments and stdin.
+func NewReplayRunner(dir string) (*FakeRunner, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list recorded commands")
+	}
+	if len(paths) == 0 {
+		return nil, errors.Errorf("no recorded commands in %s", dir)
+	}
+	sort.Strings(paths)
+	calls := make([]FakeCall, 0, len(paths))
+	for _, path := range paths {
+		callBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", path)
+		}
+		var call recordedCall
+		if err := json.Unmarshal(callBytes, &call); err != nil {
+			return nil, errors.Wrapf(err, "failed to unmarshal %s", path)
+		}
+		calls = append(calls, FakeCall{
+			Args:     call.Args,
+			Stdin:    call.Stdin,
+			Stdout:   call.Stdout,
+			Stderr:   call.Stderr,
+			ExitCode: call.ExitCode,
+		})
+	}
+	return NewFakeRunner(calls...), nil
+}
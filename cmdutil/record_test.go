@@ -0,0 +1,111 @@
+package cmdutil
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	ctx := context.Background()
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands use sh")
+	}
+	dir := t.TempDir()
+	tempFile := filepath.Join(t.TempDir(), "input.json")
+	require.NoError(t, os.WriteFile(tempFile, []byte("{}"), 0o600))
+
+	recorder, err := NewRecordingRunner(ExecRunner{}, dir)
+	require.NoError(t, err)
+	output, err := recorder.Output(ctx, exec.Command("sh", "-c", "cat; echo out; echo err >&2", "file://"+tempFile))
+	require.NoError(t, err)
+	require.Equal(t, "out\n", string(output))
+	cmd := exec.Command("sh", "-c", "echo failing >&2; exit 3")
+	cmd.Stdin = strings.NewReader("input")
+	_, err = recorder.Output(ctx, cmd)
+	require.ErrorContains(t, err, "failing")
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	require.Equal(t, []string{filepath.Join(dir, "0000-sh.json"), filepath.Join(dir, "0001-sh.json")}, files)
+	golden, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(golden), `"file://*"`)
+	require.Contains(t, string(golden), `"stderr": "err\n"`)
+
+	replayer, err := NewReplayRunner(dir)
+	require.NoError(t, err)
+	// temp files differ between runs, recorded paths match any path
+	output, err = replayer.Output(ctx, exec.Command("sh", "-c", "cat; echo out; echo err >&2", "file:///elsewhere/input.json"))
+	require.NoError(t, err)
+	require.Equal(t, "out\n", string(output))
+	// stdin must match too
+	cmd = exec.Command("sh", "-c", "echo failing >&2; exit 3")
+	cmd.Stdin = strings.NewReader("different")
+	_, err = replayer.Output(ctx, cmd)
+	require.ErrorContains(t, err, "unexpected command")
+	cmd = exec.Command("sh", "-c", "echo failing >&2; exit 3")
+	cmd.Stdin = strings.NewReader("input")
+	_, err = replayer.Output(ctx, cmd)
+	exitCode, ok := ExitCode(err)
+	require.True(t, ok)
+	require.Equal(t, 3, exitCode)
+	require.ErrorContains(t, err, "failing")
+	require.Empty(t, replayer.Unused())
+
+	// recording more commands continues the sequence
+	recorder, err = NewRecordingRunner(ExecRunner{}, dir)
+	require.NoError(t, err)
+	require.NoError(t, recorder.Run(ctx, exec.Command("true")))
+	require.FileExists(t, filepath.Join(dir, "0002-true.json"))
+}
+
+func TestRecordRedacts(t *testing.T) {
+	ctx := context.Background()
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands use sh")
+	}
+	dir := t.TempDir()
+	// the secrets come from the environment, so that the script itself has none
+	script := `cat >/dev/null; echo "{\"name\": \"DB_PASSWORD\", \"value\": \"$PASSWORD\"}"; echo "$ERR" >&2`
+	newCmd := func() *exec.Cmd {
+		cmd := exec.Command("sh", "-c", script, "--access-token=abc")
+		cmd.Env = append(os.Environ(), "PASSWORD=hunter2", "ERR=API_TOKEN=abc")
+		cmd.Stdin = strings.NewReader("SECRET_KEY=abc\nREGION=us-east-1\n")
+		return cmd
+	}
+	recorder, err := NewRecordingRunner(ExecRunner{}, dir)
+	require.NoError(t, err)
+	output, err := recorder.Output(ctx, newCmd())
+	require.NoError(t, err)
+	// only the recording is redacted
+	require.Contains(t, string(output), "hunter2")
+
+	golden, err := os.ReadFile(filepath.Join(dir, "0000-sh.json"))
+	require.NoError(t, err)
+	require.NotContains(t, string(golden), "hunter2")
+	require.NotContains(t, string(golden), "abc")
+	var call recordedCall
+	require.NoError(t, json.Unmarshal(golden, &call))
+	require.Equal(t, recordedCall{
+		Args:   []string{"-c", script, "--access-token=<redacted>"},
+		Stdin:  "SECRET_KEY=<redacted>\nREGION=us-east-1\n",
+		Stdout: "{\n  \"name\": \"DB_PASSWORD\",\n  \"value\": \"<redacted>\"\n}",
+		Stderr: "API_TOKEN=<redacted>\n",
+	}, call)
+
+	// the same command with the real values matches the redacted recording
+	replayer, err := NewReplayRunner(dir)
+	require.NoError(t, err)
+	output, err = replayer.Output(ctx, newCmd())
+	require.NoError(t, err)
+	require.Contains(t, string(output), `"value": "<redacted>"`)
+	require.Empty(t, replayer.Unused())
+}
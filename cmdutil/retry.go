@@ -0,0 +1,202 @@
+package cmdutil
+
+import (
+	"bytes"
+	"context"
+	go_errors "errors"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prodvana/pvn-wrapper/metrics"
+)
+
+// RetryReason is why a failed command is worth retrying.
+type RetryReason string
+
+const (
+	NotRetryable       RetryReason = ""
+	RetryThrottled     RetryReason = "throttled"
+	RetryServerError   RetryReason = "server-error"
+	RetryConnectionErr RetryReason = "connection"
+)
+
+// ErrorClassifier decides from a failed command's stderr whether it should be retried.
+type ErrorClassifier func(stderr []byte) RetryReason
+
+type retryRule struct {
+	reason  RetryReason
+	pattern *regexp.Regexp
+}
+
+func rule(reason RetryReason, pattern string) retryRule {
+	return retryRule{reason: reason, pattern: regexp.MustCompile(pattern)}
+}
+
+func classifierFromRules(rules []retryRule) ErrorClassifier {
+	return func(stderr []byte) RetryReason {
+		for _, r := range rules {
+			if r.pattern.Match(stderr) {
+				return r.reason
+			}
+		}
+		return NotRetryable
+	}
+}
+
+// ClassifyAwsError classifies errors printed by the aws CLI, e.g.
+// "An error occurred (ThrottlingException) when calling the DescribeServices operation: Rate exceeded".
+var ClassifyAwsError = classifierFromRules([]retryRule{
+	rule(RetryThrottled, `\((ThrottlingException|Throttling|ThrottledException|TooManyRequestsException|RequestLimitExceeded|RequestThrottled|RequestThrottledException|SlowDown)\)|Rate exceeded`),
+	rule(RetryServerError, `\((ServerException|ServiceUnavailable|ServiceUnavailableException|InternalFailure|InternalError|InternalServerError|InternalServiceError|5\d\d)\)`),
+	rule(RetryConnectionErr, `Could not connect to the endpoint URL|Connection was closed before we received a valid response|Read timeout on endpoint URL|Connect timeout on endpoint URL|[Cc]onnection reset by peer`),
+})
+
+// ClassifyGcloudError classifies errors printed by gcloud, e.g.
+// "ERROR: (gcloud.run.services.describe) HTTPError 503: Service Unavailable".
+var ClassifyGcloudError = classifierFromRules([]retryRule{
+	rule(RetryThrottled, `HTTPError 429|RESOURCE_EXHAUSTED|Quota exceeded|Rate Limit Exceeded`),
+	rule(RetryServerError, `HTTPError 50[0234]|\b50[234] (Bad Gateway|Service Unavailable|Gateway Timeout)|\bUNAVAILABLE\b`),
+	rule(RetryConnectionErr, `[Cc]onnection reset by peer|ConnectionResetError|Connection aborted|Remote end closed connection without response|TransportError|ServerNotFoundError|Unable to find the server at`),
+})
+
+// ClassifyFlyError classifies errors printed by the fly CLI, e.g.
+// "Error: failed to get app: server returned a non-200 status code: 503".
+var ClassifyFlyError = classifierFromRules([]retryRule{
+	rule(RetryThrottled, `(?i)rate limit|too many requests|status code: 429`),
+	rule(RetryServerError, `(?i)status code: 5\d\d|50[234] (bad gateway|service unavailable|gateway timeout)|internal server error`),
+	rule(RetryConnectionErr, `(?i)connection reset by peer|connection refused|i/o timeout|tls handshake timeout|unexpected EOF`),
+})
+
+// RetryPolicy controls how many times and how quickly RetryingRunner retries.
+type RetryPolicy struct {
+	// total number of attempts, including the first
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: time.Second,
+	MaxBackoff:     30 * time.Second,
+}
+
+// backoff returns how long to wait before the retry after the given attempt, starting from 1,
+// with full jitter so that concurrent callers spread out.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	maxWait := p.InitialBackoff << (attempt - 1)
+	if maxWait > p.MaxBackoff || maxWait <= 0 {
+		maxWait = p.MaxBackoff
+	}
+	if maxWait <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(maxWait) + 1))
+}
+
+// RetryingRunner retries commands that fail in ways its classifier considers transient.
+// Output is retried for any retryable failure. Run is only retried when throttled, since it is used for
+// commands that make changes, and a server or connection error does not tell whether the change was applied.
+type RetryingRunner struct {
+	runner     Runner
+	classifier ErrorClassifier
+	policy     RetryPolicy
+}
+
+func NewRetryingRunner(runner Runner, classifier ErrorClassifier, policy RetryPolicy) *RetryingRunner {
+	return &RetryingRunner{
+		runner:     runner,
+		classifier: classifier,
+		policy:     policy,
+	}
+}
+
+// cloneCmd returns a copy of cmd that has not been started, since an exec.Cmd can only be run once.
+func cloneCmd(cmd *exec.Cmd, stdin []byte) *exec.Cmd {
+	clone := &exec.Cmd{
+		Path:        cmd.Path,
+		Args:        cmd.Args,
+		Env:         cmd.Env,
+		Dir:         cmd.Dir,
+		Stdout:      cmd.Stdout,
+		Stderr:      cmd.Stderr,
+		SysProcAttr: cmd.SysProcAttr,
+		Err:         cmd.Err,
+	}
+	if stdin != nil {
+		clone.Stdin = bytes.NewReader(stdin)
+	}
+	return clone
+}
+
+func (r *RetryingRunner) retry(ctx context.Context, cmd *exec.Cmd, shouldRetry func(RetryReason) bool, run func(*exec.Cmd) ([]byte, error)) error {
+	stdin, err := captureStdin(cmd)
+	if err != nil {
+		return err
+	}
+	for attempt := 1; ; attempt++ {
+		attemptCmd := cloneCmd(cmd, stdin)
+		stderr, err := run(attemptCmd)
+		if err == nil || attempt >= r.policy.MaxAttempts {
+			return err
+		}
+		var exitErr *ExitError
+		if !go_errors.As(err, &exitErr) {
+			return err
+		}
+		reason := r.classifier(stderr)
+		if reason == NotRetryable || !shouldRetry(reason) {
+			return err
+		}
+		wait := r.policy.backoff(attempt)
+		slog.Warn("Retrying command", "command", RedactCommand(cmd), "reason", string(reason), "attempt", attempt, "backoff", wait)
+		metrics.AddRetries(1)
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "command canceled while waiting to retry")
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (r *RetryingRunner) Run(ctx context.Context, cmd *exec.Cmd) error {
+	if cmd.Stdout == nil {
+		cmd.Stdout = os.Stdout
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	return r.retry(ctx, cmd, func(reason RetryReason) bool {
+		return reason == RetryThrottled
+	}, func(attemptCmd *exec.Cmd) ([]byte, error) {
+		var stderr bytes.Buffer
+		attemptCmd.Stderr = io.MultiWriter(attemptCmd.Stderr, &stderr)
+		err := r.runner.Run(ctx, attemptCmd)
+		return stderr.Bytes(), err
+	})
+}
+
+func (r *RetryingRunner) Output(ctx context.Context, cmd *exec.Cmd) ([]byte, error) {
+	var output []byte
+	err := r.retry(ctx, cmd, func(RetryReason) bool {
+		return true
+	}, func(attemptCmd *exec.Cmd) ([]byte, error) {
+		var err error
+		output, err = r.runner.Output(ctx, attemptCmd)
+		var exitErr *ExitError
+		if go_errors.As(err, &exitErr) {
+			return exitErr.Stderr, err
+		}
+		return nil, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
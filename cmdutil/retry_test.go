@@ -0,0 +1,183 @@
+package cmdutil
+
+import (
+	"context"
+	"io"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifiers(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		classifier ErrorClassifier
+		stderr     string
+		expected   RetryReason
+	}{
+		{
+			name:       "aws throttling",
+			classifier: ClassifyAwsError,
+			stderr:     "An error occurred (ThrottlingException) when calling the DescribeServices operation (reached max retries: 2): Rate exceeded",
+			expected:   RetryThrottled,
+		},
+		{
+			name:       "aws service unavailable",
+			classifier: ClassifyAwsError,
+			stderr:     "An error occurred (ServiceUnavailableException) when calling the DescribeServices operation: Service Unavailable",
+			expected:   RetryServerError,
+		},
+		{
+			name:       "aws bare status code",
+			classifier: ClassifyAwsError,
+			stderr:     "An error occurred (502) when calling the GetResources operation: Bad Gateway",
+			expected:   RetryServerError,
+		},
+		{
+			name:       "aws connection",
+			classifier: ClassifyAwsError,
+			stderr:     `Could not connect to the endpoint URL: "https://ecs.us-west-2.amazonaws.com/"`,
+			expected:   RetryConnectionErr,
+		},
+		{
+			name:       "aws access denied",
+			classifier: ClassifyAwsError,
+			stderr:     "An error occurred (AccessDeniedException) when calling the DescribeServices operation: not authorized",
+			expected:   NotRetryable,
+		},
+		{
+			name:       "aws client error",
+			classifier: ClassifyAwsError,
+			stderr:     "An error occurred (ClientException) when calling the RegisterTaskDefinition operation: Invalid revision number",
+			expected:   NotRetryable,
+		},
+		{
+			name:       "gcloud quota",
+			classifier: ClassifyGcloudError,
+			stderr:     "ERROR: (gcloud.run.services.describe) HTTPError 429: Quota exceeded for quota metric 'Read requests'",
+			expected:   RetryThrottled,
+		},
+		{
+			name:       "gcloud service unavailable",
+			classifier: ClassifyGcloudError,
+			stderr:     "ERROR: (gcloud.run.services.describe) HTTPError 503: Service Unavailable",
+			expected:   RetryServerError,
+		},
+		{
+			name:       "gcloud connection reset",
+			classifier: ClassifyGcloudError,
+			stderr:     "ERROR: gcloud crashed (ConnectionError): ('Connection aborted.', ConnectionResetError(104, 'Connection reset by peer'))",
+			expected:   RetryConnectionErr,
+		},
+		{
+			name:       "gcloud not found",
+			classifier: ClassifyGcloudError,
+			stderr:     "ERROR: (gcloud.run.services.describe) Cannot find service [my-service]",
+			expected:   NotRetryable,
+		},
+		{
+			name:       "gcloud permission denied",
+			classifier: ClassifyGcloudError,
+			stderr:     "ERROR: (gcloud.run.services.replace) PERMISSION_DENIED: Permission 'run.services.get' denied",
+			expected:   NotRetryable,
+		},
+		{
+			name:       "fly rate limited",
+			classifier: ClassifyFlyError,
+			stderr:     "Error: You have been rate limited, please try again later",
+			expected:   RetryThrottled,
+		},
+		{
+			name:       "fly server error",
+			classifier: ClassifyFlyError,
+			stderr:     "Error: failed to get app: server returned a non-200 status code: 503",
+			expected:   RetryServerError,
+		},
+		{
+			name:       "fly connection",
+			classifier: ClassifyFlyError,
+			stderr:     `Error: Post "https://api.fly.io/graphql": read tcp 10.0.0.2:51234->77.83.143.220:443: read: connection reset by peer`,
+			expected:   RetryConnectionErr,
+		},
+		{
+			name:       "fly not found",
+			classifier: ClassifyFlyError,
+			stderr:     "Error: Could not find App \"my-app\"",
+			expected:   NotRetryable,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.expected, tc.classifier([]byte(tc.stderr)))
+		})
+	}
+}
+
+func TestRetryingRunner(t *testing.T) {
+	ctx := context.Background()
+	policy := RetryPolicy{MaxAttempts: 3}
+	throttled := FakeCall{Args: []string{"describe"}, Stderr: "(ThrottlingException) Rate exceeded", ExitCode: 254}
+	unavailable := FakeCall{Args: []string{"describe"}, Stderr: "(ServiceUnavailable)", ExitCode: 254}
+	for _, tc := range []struct {
+		name        string
+		run         bool
+		calls       []FakeCall
+		expectedErr string
+		expectedRan int
+	}{
+		{
+			name:        "output retried until success",
+			calls:       []FakeCall{throttled, unavailable, {Args: []string{"describe"}, Stdout: "ok"}},
+			expectedRan: 3,
+		},
+		{
+			name:        "output gives up after max attempts",
+			calls:       []FakeCall{throttled, throttled, throttled},
+			expectedErr: "Rate exceeded",
+			expectedRan: 3,
+		},
+		{
+			name:        "output not retried for other errors",
+			calls:       []FakeCall{{Args: []string{"describe"}, Stderr: "(AccessDeniedException)", ExitCode: 254}},
+			expectedErr: "AccessDeniedException",
+			expectedRan: 1,
+		},
+		{
+			name:        "run retried when throttled",
+			run:         true,
+			calls:       []FakeCall{throttled, {Args: []string{"describe"}}},
+			expectedRan: 2,
+		},
+		{
+			name:        "run not retried for server errors",
+			run:         true,
+			calls:       []FakeCall{unavailable},
+			expectedErr: "exit status 254",
+			expectedRan: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := NewFakeRunner(tc.calls...)
+			runner := NewRetryingRunner(fake, ClassifyAwsError, policy)
+			cmd := exec.Command("aws", "describe")
+			var err error
+			if tc.run {
+				cmd.Stdout = io.Discard
+				cmd.Stderr = io.Discard
+				err = runner.Run(ctx, cmd)
+			} else {
+				var output []byte
+				output, err = runner.Output(ctx, cmd)
+				if err == nil {
+					require.Equal(t, "ok", string(output))
+				}
+			}
+			if tc.expectedErr != "" {
+				require.ErrorContains(t, err, tc.expectedErr)
+			} else {
+				require.NoError(t, err)
+			}
+			require.Len(t, fake.Ran(), tc.expectedRan)
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+
+	ExitClassSuccess = "success"
+	ExitClassFailure = "failure"
+	ExitClassError   = "error"
+
+	pushTimeout = 10 * time.Second
+)
+
+var (
+	// TextfilePath is where metrics are written for the node_exporter textfile collector.
+	// Counters in an existing file are accumulated, so the file reflects every run on this machine.
+	TextfilePath string
+	// PushgatewayURL is the base URL of a Pushgateway-compatible endpoint to push the metrics of each run to.
+	PushgatewayURL string
+)
+
+type metricType string
+
+const (
+	counter metricType = "counter"
+	gauge   metricType = "gauge"
+)
+
+type family struct {
+	name string
+	typ  metricType
+	help string
+}
+
+var (
+	runsTotal        = family{"pvn_wrapper_runs_total", counter, "Number of pvn-wrapper runs."}
+	runDurationSum   = family{"pvn_wrapper_run_duration_seconds_sum", counter, "Total duration of pvn-wrapper runs."}
+	runDurationCount = family{"pvn_wrapper_run_duration_seconds_count", counter, "Number of pvn-wrapper runs with a recorded duration."}
+	bytesTotal       = family{"pvn_wrapper_transferred_bytes_total", counter, "Bytes transferred to and from Prodvana."}
+	retriesTotal     = family{"pvn_wrapper_retries_total", counter, "Number of retried calls."}
+	lastRunDuration  = family{"pvn_wrapper_last_run_duration_seconds", gauge, "Duration of the most recent pvn-wrapper run."}
+	lastRunTimestamp = family{"pvn_wrapper_last_run_timestamp_seconds", gauge, "Time the most recent pvn-wrapper run finished."}
+	lastRunExitCode  = family{"pvn_wrapper_last_run_exit_code", gauge, "Exit code of the most recent pvn-wrapper run."}
+	families         = []family{runsTotal, runDurationSum, runDurationCount, bytesTotal, retriesTotal, lastRunDuration, lastRunTimestamp, lastRunExitCode}
+	familiesByName   = map[string]family{}
+	summaryNames     = map[string]string{
+		// _sum and _count are rendered as part of the same summary family
+		runDurationSum.name:   "pvn_wrapper_run_duration_seconds",
+		runDurationCount.name: "pvn_wrapper_run_duration_seconds",
+	}
+)
+
+func init() {
+	for _, f := range families {
+		familiesByName[f.name] = f
+	}
+}
+
+var state = struct {
+	mu         sync.Mutex
+	subcommand string
+	start      time.Time
+	bytes      map[string]int64
+	retries    int64
+	finished   bool
+}{
+	bytes: map[string]int64{},
+}
+
+// ValidateTextfilePath checks that path is picked up by the node_exporter textfile collector,
+// which only reads files ending in .prom.
+func ValidateTextfilePath(path string) error {
+	if path != "" && !strings.HasSuffix(path, ".prom") {
+		return errors.Errorf("metrics textfile %s must end in .prom", path)
+	}
+	return nil
+}
+
+// Start begins recording metrics for a run of subcommand.
+func Start(subcommand string) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.subcommand = subcommand
+	state.start = time.Now()
+}
+
+// AddBytes records n bytes transferred to or from Prodvana.
+func AddBytes(direction string, n int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.bytes[direction] += int64(n)
+}
+
+// AddRetries records n retried calls.
+func AddRetries(n int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	state.retries += int64(n)
+}
+
+// ExitClass buckets an exit code. Negative exit codes mean pvn-wrapper or the wrapped command failed to run at all.
+func ExitClass(exitCode int) string {
+	switch {
+	case exitCode == 0:
+		return ExitClassSuccess
+	case exitCode < 0:
+		return ExitClassError
+	default:
+		return ExitClassFailure
+	}
+}
+
+// series is a map of "name{labels}" to value.
+type series map[string]float64
+
+func seriesKey(name string, labels ...string) string {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return fmt.Sprintf("%s{%s}", name, strings.Join(pairs, ","))
+}
+
+func seriesName(key string) string {
+	name, _, _ := strings.Cut(key, "{")
+	return name
+}
+
+func (s series) render() []byte {
+	keys := make([]string, 0, len(s))
+	for k := range s {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var buf bytes.Buffer
+	headers := map[string]bool{}
+	for _, k := range keys {
+		name := seriesName(k)
+		f := familiesByName[name]
+		header := name
+		typ := string(f.typ)
+		if summaryName, ok := summaryNames[name]; ok {
+			header = summaryName
+			typ = "summary"
+		}
+		if !headers[header] {
+			headers[header] = true
+			fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", header, f.help, header, typ)
+		}
+		fmt.Fprintf(&buf, "%s %s\n", k, strconv.FormatFloat(s[k], 'g', -1, 64))
+	}
+	return buf.Bytes()
+}
+
+// parseSeries parses metrics previously written by render. Unknown metrics are dropped.
+func parseSeries(content []byte) series {
+	s := series{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			continue
+		}
+		key := line[:idx]
+		if _, ok := familiesByName[seriesName(key)]; !ok {
+			continue
+		}
+		value, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			continue
+		}
+		s[key] = value
+	}
+	return s
+}
+
+// merge adds counters from run onto previous and overwrites gauges.
+func merge(previous, run series) series {
+	merged := series{}
+	for k, v := range previous {
+		merged[k] = v
+	}
+	for k, v := range run {
+		if familiesByName[seriesName(k)].typ == counter {
+			merged[k] += v
+		} else {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+func (s series) set(f family, value float64, labels ...string) {
+	s[seriesKey(f.name, labels...)] = value
+}
+
+func runSeries(subcommand string, exitCode int, duration time.Duration, bytesByDirection map[string]int64, retries int64, now time.Time) series {
+	s := series{}
+	sub := []string{"subcommand", subcommand}
+	s.set(runsTotal, 1, "subcommand", subcommand, "exit_class", ExitClass(exitCode))
+	s.set(runDurationSum, duration.Seconds(), sub...)
+	s.set(runDurationCount, 1, sub...)
+	for _, direction := range []string{DirectionUpload, DirectionDownload} {
+		s.set(bytesTotal, float64(bytesByDirection[direction]), "subcommand", subcommand, "direction", direction)
+	}
+	s.set(retriesTotal, float64(retries), sub...)
+	s.set(lastRunDuration, duration.Seconds(), sub...)
+	s.set(lastRunTimestamp, float64(now.Unix()), sub...)
+	s.set(lastRunExitCode, float64(exitCode), sub...)
+	return s
+}
+
+func writeTextfile(path string, run series) error {
+	previous, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "failed to read %s", path)
+	}
+	content := merge(parseSeries(previous), run).render()
+	// write atomically so that the collector never reads a partial file
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), ".pvn-wrapper-metrics")
+	if err != nil {
+		return errors.Wrap(err, "failed to make tempfile")
+	}
+	defer func() { _ = os.Remove(tmpFile.Name()) }()
+	if _, err := tmpFile.Write(content); err != nil {
+		_ = tmpFile.Close()
+		return errors.Wrap(err, "failed to write to tempfile")
+	}
+	if err := tmpFile.Close(); err != nil {
+		return errors.Wrap(err, "failed to close tempfile")
+	}
+	if err := os.Chmod(tmpFile.Name(), 0o644); err != nil {
+		return errors.Wrap(err, "failed to chmod tempfile")
+	}
+	return errors.Wrapf(os.Rename(tmpFile.Name(), path), "failed to write %s", path)
+}
+
+func push(pushgatewayURL, subcommand string, run series) error {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	pushURL := fmt.Sprintf(
+		"%s/metrics/job/pvn-wrapper/subcommand/%s/instance/%s",
+		strings.TrimSuffix(pushgatewayURL, "/"),
+		url.PathEscape(subcommand),
+		url.PathEscape(instance),
+	)
+	req, err := http.NewRequest(http.MethodPut, pushURL, bytes.NewReader(run.render()))
+	if err != nil {
+		return errors.Wrap(err, "failed to make push request")
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	resp, err := (&http.Client{Timeout: pushTimeout}).Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to push metrics to %s", pushURL)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("failed to push metrics to %s: %s", pushURL, resp.Status)
+	}
+	return nil
+}
+
+// Finish records the end of the run and writes metrics to the configured destinations.
+// Only the first call has an effect. Failures are logged and otherwise ignored.
+func Finish(exitCode int) {
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.finished || state.subcommand == "" {
+		return
+	}
+	state.finished = true
+	if TextfilePath == "" && PushgatewayURL == "" {
+		return
+	}
+	now := time.Now()
+	run := runSeries(state.subcommand, exitCode, now.Sub(state.start), state.bytes, state.retries, now)
+	if TextfilePath != "" {
+		if err := writeTextfile(TextfilePath, run); err != nil {
+			slog.Warn("Failed to write metrics", "path", TextfilePath, "error", err)
+		}
+	}
+	if PushgatewayURL != "" {
+		if err := push(PushgatewayURL, state.subcommand, run); err != nil {
+			slog.Warn("Failed to push metrics", "url", PushgatewayURL, "error", err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteTextfileAccumulates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pvn-wrapper.prom")
+	now := time.Unix(1700000000, 0)
+	bytesByDirection := map[string]int64{DirectionUpload: 100}
+
+	require.NoError(t, writeTextfile(path, runSeries("exec", 0, 2*time.Second, bytesByDirection, 1, now)))
+	require.NoError(t, writeTextfile(path, runSeries("exec", 3, 4*time.Second, bytesByDirection, 0, now.Add(time.Minute))))
+	require.NoError(t, writeTextfile(path, runSeries("aws-ecs fetch", 0, time.Second, nil, 0, now)))
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	s := parseSeries(content)
+	require.Equal(t, 1.0, s[`pvn_wrapper_runs_total{subcommand="exec",exit_class="success"}`])
+	require.Equal(t, 1.0, s[`pvn_wrapper_runs_total{subcommand="exec",exit_class="failure"}`])
+	require.Equal(t, 1.0, s[`pvn_wrapper_runs_total{subcommand="aws-ecs fetch",exit_class="success"}`])
+	require.Equal(t, 6.0, s[`pvn_wrapper_run_duration_seconds_sum{subcommand="exec"}`])
+	require.Equal(t, 2.0, s[`pvn_wrapper_run_duration_seconds_count{subcommand="exec"}`])
+	require.Equal(t, 200.0, s[`pvn_wrapper_transferred_bytes_total{subcommand="exec",direction="upload"}`])
+	require.Equal(t, 1.0, s[`pvn_wrapper_retries_total{subcommand="exec"}`])
+	// gauges reflect the last run
+	require.Equal(t, 4.0, s[`pvn_wrapper_last_run_duration_seconds{subcommand="exec"}`])
+	require.Equal(t, 3.0, s[`pvn_wrapper_last_run_exit_code{subcommand="exec"}`])
+
+	require.Equal(t, 1, strings.Count(string(content), "# TYPE pvn_wrapper_run_duration_seconds summary"))
+}
+
+func TestPush(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPut, r.Method)
+		gotPath = r.URL.EscapedPath()
+		body, _ := io.ReadAll(r.Body)
+		gotBody = string(body)
+	}))
+	defer srv.Close()
+
+	require.NoError(t, push(srv.URL+"/", "aws-ecs apply", runSeries("aws-ecs apply", -1, time.Second, nil, 0, time.Now())))
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, "/metrics/job/pvn-wrapper/subcommand/aws-ecs%20apply/instance/"+hostname, gotPath)
+	require.Contains(t, gotBody, `pvn_wrapper_runs_total{subcommand="aws-ecs apply",exit_class="error"} 1`)
+}
+
+func TestValidateTextfilePath(t *testing.T) {
+	require.NoError(t, ValidateTextfilePath(""))
+	require.NoError(t, ValidateTextfilePath("/var/lib/node_exporter/pvn-wrapper.prom"))
+	require.EqualError(t, ValidateTextfilePath("/var/lib/node_exporter/pvn-wrapper.txt"), "metrics textfile /var/lib/node_exporter/pvn-wrapper.txt must end in .prom")
+}
@@ -0,0 +1,45 @@
+package result
+
+import (
+	"context"
+	"io"
+
+	blobs_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/blobs"
+	"google.golang.org/grpc"
+)
+
+// BlobClient reads and writes Prodvana blobs the same way RunWrapper does, for debugging tools.
+type BlobClient struct {
+	conn   *grpc.ClientConn
+	client blobs_pb.BlobsManagerClient
+}
+
+func NewBlobClient() (*BlobClient, error) {
+	conn, err := dialProdvana()
+	if err != nil {
+		return nil, err
+	}
+	return &BlobClient{
+		conn:   conn,
+		client: blobs_pb.NewBlobsManagerClient(conn),
+	}, nil
+}
+
+func (c *BlobClient) Close() error {
+	return c.conn.Close()
+}
+
+// Get writes the contents of blob id to w.
+func (c *BlobClient) Get(ctx context.Context, id string, w io.Writer) error {
+	return downloadBlobTo(ctx, c.client, id, w)
+}
+
+// GetFile downloads blob id to path.
+func (c *BlobClient) GetFile(ctx context.Context, id, path string) error {
+	return downloadBlob(ctx, c.client, InputFile{Path: path, BlobId: id})
+}
+
+// Put uploads the file at path and returns its blob ID.
+func (c *BlobClient) Put(ctx context.Context, path string) (string, error) {
+	return uploadOutput(ctx, c.client, OutputFileUpload{Path: path})
+}
@@ -0,0 +1,40 @@
+package result
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobClient(t *testing.T) {
+	ctx := context.Background()
+	fake := startFakeProdvana(t)
+	blobs, err := NewBlobClient()
+	require.NoError(t, err)
+	defer func() { _ = blobs.Close() }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "input.txt")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0o600))
+	id, err := blobs.Put(ctx, path)
+	require.NoError(t, err)
+	require.Equal(t, "hello", fake.blob(t, id))
+
+	var content bytes.Buffer
+	require.NoError(t, blobs.Get(ctx, id, &content))
+	require.Equal(t, "hello", content.String())
+
+	downloaded := filepath.Join(dir, "downloaded.txt")
+	require.NoError(t, blobs.GetFile(ctx, id, downloaded))
+	downloadedContent, err := os.ReadFile(downloaded)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(downloadedContent))
+
+	require.ErrorContains(t, blobs.Get(ctx, "missing", &content), "failed to download blob missing")
+	_, err = blobs.Put(ctx, filepath.Join(dir, "missing.txt"))
+	require.True(t, os.IsNotExist(err))
+}
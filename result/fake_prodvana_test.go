@@ -0,0 +1,136 @@
+package result
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	blobs_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/blobs"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+)
+
+// fakeProdvana serves the blobs and job APIs from memory.
+type fakeProdvana struct {
+	blobs_pb.UnimplementedBlobsManagerServer
+	pvn_wrapper_pb.UnimplementedJobManagerServer
+
+	mu      sync.Mutex
+	blobs   map[string][]byte
+	results map[string]*pvn_wrapper_pb.Output
+	// errors returned by the next uploads and reports, in order
+	uploadErrs []error
+	reportErrs []error
+}
+
+// startFakeProdvana starts a fakeProdvana and makes dialProdvana connect to it for the duration of the test.
+func startFakeProdvana(t *testing.T) *fakeProdvana {
+	fake := &fakeProdvana{
+		blobs:   map[string][]byte{},
+		results: map[string]*pvn_wrapper_pb.Output{},
+	}
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	blobs_pb.RegisterBlobsManagerServer(server, fake)
+	pvn_wrapper_pb.RegisterJobManagerServer(server, fake)
+	go func() { _ = server.Serve(listener) }()
+	prevDial := dialProdvana
+	t.Cleanup(func() {
+		dialProdvana = prevDial
+		server.Stop()
+	})
+	dialProdvana = func() (*grpc.ClientConn, error) {
+		return grpc.DialContext(
+			context.Background(),
+			"bufnet",
+			grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+				return listener.DialContext(ctx)
+			}),
+			grpc.WithTransportCredentials(insecure.NewCredentials()),
+		)
+	}
+	return fake
+}
+
+func popErr(errs *[]error) error {
+	if len(*errs) == 0 {
+		return nil
+	}
+	err := (*errs)[0]
+	*errs = (*errs)[1:]
+	return err
+}
+
+func (f *fakeProdvana) GetCasBlob(req *blobs_pb.GetCasBlobReq, strm blobs_pb.BlobsManager_GetCasBlobServer) error {
+	f.mu.Lock()
+	content, ok := f.blobs[req.Id]
+	f.mu.Unlock()
+	if !ok {
+		return status.Errorf(codes.NotFound, "blob %s not found", req.Id)
+	}
+	return strm.Send(&blobs_pb.GetCasBlobResp{Bytes: content})
+}
+
+func (f *fakeProdvana) UploadCasBlob(strm blobs_pb.BlobsManager_UploadCasBlobServer) error {
+	var content bytes.Buffer
+	for {
+		req, err := strm.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		content.Write(req.Bytes)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := popErr(&f.uploadErrs); err != nil {
+		return err
+	}
+	id := fmt.Sprintf("blob-%d", len(f.blobs)+1)
+	f.blobs[id] = content.Bytes()
+	return strm.SendAndClose(&blobs_pb.UploadCasBlobResp{Id: id})
+}
+
+func (f *fakeProdvana) ReportJobResult(ctx context.Context, req *pvn_wrapper_pb.ReportJobResultReq) (*pvn_wrapper_pb.ReportJobResultResp, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := popErr(&f.reportErrs); err != nil {
+		return nil, err
+	}
+	if _, ok := f.results[req.JobId]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "job %s already has a result", req.JobId)
+	}
+	f.results[req.JobId] = req.Output
+	return &pvn_wrapper_pb.ReportJobResultResp{}, nil
+}
+
+// blob returns the content of a blob that was uploaded.
+func (f *fakeProdvana) blob(t *testing.T, id string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.blobs[id]
+	require.True(t, ok, "blob %s was not uploaded", id)
+	return string(content)
+}
+
+// result returns the result reported for a job, or nil.
+func (f *fakeProdvana) result(jobId string) *pvn_wrapper_pb.Output {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if result, ok := f.results[jobId]; ok {
+		return proto.Clone(result).(*pvn_wrapper_pb.Output)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package result
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	runtimes_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	markerPrefix        = "::"
+	outputMarkerCommand = "pvn-output"
+	eventMarkerCommand  = "pvn-event"
+
+	// Name of the output file that debug events emitted via ::pvn-event:: markers are uploaded as.
+	EventsOutputName = "pvn-events"
+	// Prefix of the output files pvn-wrapper reports itself, e.g. pvn-events. ::pvn-output:: markers cannot use it.
+	ReservedOutputPrefix = "pvn-"
+)
+
+type marker struct {
+	command string
+	params  map[string]string
+	value   string
+}
+
+// parseMarker parses a workflow-command-style line, e.g.
+//
+//	::pvn-output name=image_digest::sha256:...
+//	::pvn-event::message
+//
+// Returns false if the line is not a marker recognized by pvn-wrapper.
+func parseMarker(line string) (marker, bool) {
+	if !strings.HasPrefix(line, markerPrefix) {
+		return marker{}, false
+	}
+	header, value, found := strings.Cut(line[len(markerPrefix):], markerPrefix)
+	if !found {
+		return marker{}, false
+	}
+	command, rawParams, _ := strings.Cut(header, " ")
+	if command != outputMarkerCommand && command != eventMarkerCommand {
+		return marker{}, false
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(rawParams, ",") {
+		if param == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(param, "=")
+		params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	if command == outputMarkerCommand && params["name"] == "" {
+		return marker{}, false
+	}
+	return marker{
+		command: command,
+		params:  params,
+		value:   value,
+	}, true
+}
+
+// markerWriter is an io.Writer that strips ::pvn-output:: and ::pvn-event:: markers
+// from a stream, recording them as they are written so events get accurate timestamps.
+// Everything else is passed through to the underlying buffer unchanged.
+type markerWriter struct {
+	mu      sync.Mutex
+	out     *bytes.Buffer
+	partial []byte
+	outputs map[string]string
+	order   []string
+	events  []*runtimes_pb.DebugEvent
+	now     func() time.Time
+}
+
+func newMarkerWriter(out *bytes.Buffer) *markerWriter {
+	return &markerWriter{
+		out:     out,
+		outputs: map[string]string{},
+		now:     time.Now,
+	}
+}
+
+func (w *markerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.partial = append(w.partial, p...)
+	for {
+		idx := bytes.IndexByte(w.partial, '\n')
+		if idx < 0 {
+			break
+		}
+		w.processLine(w.partial[:idx+1])
+		w.partial = w.partial[idx+1:]
+	}
+	return len(p), nil
+}
+
+// Flush processes any trailing line that was not terminated by a newline.
+func (w *markerWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) > 0 {
+		w.processLine(w.partial)
+		w.partial = nil
+	}
+}
+
+func (w *markerWriter) processLine(line []byte) {
+	m, ok := parseMarker(strings.TrimRight(string(line), "\r\n"))
+	if !ok {
+		_, _ = w.out.Write(line)
+		return
+	}
+	switch m.command {
+	case outputMarkerCommand:
+		name := m.params["name"]
+		if strings.HasPrefix(name, ReservedOutputPrefix) {
+			slog.Warn("Ignoring output marker with a reserved name, leaving it in stdout", "name", name, "reserved_prefix", ReservedOutputPrefix)
+			_, _ = w.out.Write(line)
+			return
+		}
+		if _, exists := w.outputs[name]; !exists {
+			w.order = append(w.order, name)
+		}
+		// last one wins
+		w.outputs[name] = m.value
+	case eventMarkerCommand:
+		w.events = append(w.events, &runtimes_pb.DebugEvent{
+			Timestamp: timestamppb.New(w.now()),
+			Message:   m.value,
+		})
+	}
+}
+
+// OutputFiles returns the named outputs and debug events recorded so far, as files to upload.
+// Debug events are uploaded as a single file named EventsOutputName, one JSON-encoded event per line.
+func (w *markerWriter) OutputFiles() ([]OutputFileUpload, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var files []OutputFileUpload
+	for _, name := range w.order {
+		files = append(files, OutputFileUpload{
+			Name:    name,
+			Content: []byte(w.outputs[name]),
+		})
+	}
+	if len(w.events) > 0 {
+		var content bytes.Buffer
+		for _, event := range w.events {
+			encoded, err := protojson.Marshal(event)
+			if err != nil {
+				return nil, err
+			}
+			content.Write(encoded)
+			content.WriteByte('\n')
+		}
+		files = append(files, OutputFileUpload{
+			Name:    EventsOutputName,
+			Content: content.Bytes(),
+		})
+	}
+	return files, nil
+}
@@ -0,0 +1,48 @@
+package result
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	runtimes_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/runtimes"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+func TestMarkerWriter(t *testing.T) {
+	stdout := new(bytes.Buffer)
+	w := newMarkerWriter(stdout)
+	ts := time.Unix(1700000000, 0)
+	w.now = func() time.Time { return ts }
+
+	// split writes across line boundaries to make sure partial lines are handled
+	for _, chunk := range []string{
+		"building\n::pvn-out",
+		"put name=image_digest::sha256:abc\n",
+		"::pvn-event::pushed image\r\n",
+		"::unknown::left alone\n",
+		"::pvn-output name=image_digest::sha256:def\n",
+		"::pvn-output::missing name\n",
+		"::pvn-output name=pvn-events::reserved\n",
+		"done",
+	} {
+		_, err := w.Write([]byte(chunk))
+		require.NoError(t, err)
+	}
+	w.Flush()
+
+	require.Equal(t, "building\n::unknown::left alone\n::pvn-output::missing name\n::pvn-output name=pvn-events::reserved\ndone", stdout.String())
+
+	files, err := w.OutputFiles()
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	require.Equal(t, "image_digest", files[0].Name)
+	require.Equal(t, "sha256:def", string(files[0].Content))
+	require.Equal(t, EventsOutputName, files[1].Name)
+
+	var event runtimes_pb.DebugEvent
+	require.NoError(t, protojson.Unmarshal(bytes.TrimSpace(files[1].Content), &event))
+	require.Equal(t, "pushed image", event.Message)
+	require.Equal(t, ts, event.Timestamp.AsTime().Local())
+}
@@ -0,0 +1,90 @@
+package result
+
+import (
+	"bytes"
+	go_errors "errors"
+	"io"
+	"os/exec"
+	"regexp"
+	"syscall"
+
+	"github.com/creack/pty"
+	"github.com/pkg/errors"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+)
+
+// Size of the pseudo-terminal commands run under. Wide enough that most tools do not wrap their output.
+var ptySize = &pty.Winsize{Rows: 50, Cols: 200}
+
+// Matches CSI sequences (colors, cursor movement), OSC sequences (titles, hyperlinks), and other two-byte escapes.
+var ansiEscapeRegexp = regexp.MustCompile(`\x1b\[[0-?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(?:\x07|\x1b\\)|\x1b[@-Z\\-_]`)
+
+// StripAnsi removes ANSI escape sequences from b.
+func StripAnsi(b []byte) []byte {
+	return ansiEscapeRegexp.ReplaceAll(b, nil)
+}
+
+// RunCmdPty is like RunCmd, but runs cmd under a pseudo-terminal, for tools that behave differently
+// when not attached to a terminal.
+// A terminal has a single output stream, so everything cmd prints is captured as stdout and stderr is empty.
+// If stripAnsi is set, ANSI escape sequences are removed from the captured stdout.
+func RunCmdPty(cmd *exec.Cmd, stripAnsi bool) (*pvn_wrapper_pb.Output, []OutputFileUpload, error) {
+	stdout := new(bytes.Buffer)
+	markers := newMarkerWriter(stdout)
+
+	var result pvn_wrapper_pb.Output
+
+	ptmx, err := pty.StartWithSize(cmd, ptySize)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to start command under a pseudo-terminal")
+	}
+	_, copyErr := io.Copy(markers, ptmx)
+	_ = ptmx.Close()
+	// Once the command and all its children exit, reads from the pty fail with EIO instead of returning EOF.
+	if copyErr != nil && !go_errors.Is(copyErr, syscall.EIO) {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return nil, nil, errors.Wrap(copyErr, "failed to read from pseudo-terminal")
+	}
+
+	err = cmd.Wait()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if go_errors.As(err, &exitErr) {
+			result.ExitCode = ptyExitCode(exitErr)
+		} else {
+			return nil, nil, err
+		}
+	}
+
+	markers.Flush()
+	markerOutputs, err := markers.OutputFiles()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode output markers")
+	}
+
+	stdoutBytes := stdout.Bytes()
+	if stripAnsi {
+		stdoutBytes = StripAnsi(stdoutBytes)
+	}
+
+	return &result, append([]OutputFileUpload{
+		{
+			Stdout:  true,
+			Content: stdoutBytes,
+		},
+		{
+			Stderr:  true,
+			Content: nil,
+		},
+	}, markerOutputs...), nil
+}
+
+// ptyExitCode returns the exit code of a command run by RunCmdPty that failed. A command killed by a signal has no
+// exit code, so 128+signal is reported instead, like shells do. RunCmd keeps reporting -1 for signaled commands.
+func ptyExitCode(exitErr *exec.ExitError) int32 {
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return int32(128 + status.Signal())
+	}
+	return int32(exitErr.ExitCode())
+}
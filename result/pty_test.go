@@ -0,0 +1,45 @@
+package result
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunCmdPty(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pseudo-terminals are not supported on windows")
+	}
+	script := `if [ -t 1 ]; then printf '\033[31mtty\033[0m\n'; else echo notty; fi; echo oops >&2; exit 3`
+	for _, stripAnsi := range []bool{false, true} {
+		res, outputs, err := RunCmdPty(exec.Command("sh", "-c", script), stripAnsi)
+		require.NoError(t, err)
+		require.Equal(t, int32(3), res.ExitCode)
+		require.True(t, outputs[0].Stdout)
+		stdout := strings.ReplaceAll(string(outputs[0].Content), "\r\n", "\n")
+		if stripAnsi {
+			require.Equal(t, "tty\noops\n", stdout)
+		} else {
+			require.Equal(t, "\x1b[31mtty\x1b[0m\noops\n", stdout)
+		}
+		require.True(t, outputs[1].Stderr)
+		require.Empty(t, outputs[1].Content)
+	}
+}
+
+func TestRunCmdSignaled(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not supported on windows")
+	}
+	script := `kill -TERM $$`
+	// without a pty, signaled commands report -1 as they always have
+	res, _, err := RunCmd(exec.Command("sh", "-c", script))
+	require.NoError(t, err)
+	require.Equal(t, int32(-1), res.ExitCode)
+	res, _, err = RunCmdPty(exec.Command("sh", "-c", script), false)
+	require.NoError(t, err)
+	require.Equal(t, int32(128+15), res.ExitCode)
+}
@@ -7,7 +7,7 @@ import (
 	go_errors "errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"time"
@@ -16,6 +16,11 @@ import (
 	"github.com/prodvana/prodvana-public/go/prodvana-sdk/client"
 	blobs_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/blobs"
 	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"github.com/prodvana/pvn-wrapper/metrics"
+	"github.com/prodvana/pvn-wrapper/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"google.golang.org/protobuf/encoding/protojson"
 )
@@ -88,6 +93,7 @@ func uploadOutput(ctx context.Context, blobsClient blobs_pb.BlobsManagerClient,
 		return "", err
 	}
 	process := func(b []byte) error {
+		metrics.AddBytes(metrics.DirectionUpload, len(b))
 		return strm.Send(&blobs_pb.UploadCasBlobReq{
 			Bytes: b,
 		})
@@ -107,46 +113,69 @@ func uploadOutput(ctx context.Context, blobsClient blobs_pb.BlobsManagerClient,
 	return resp.Id, nil
 }
 
-func downloadBlob(ctx context.Context, blobsClient blobs_pb.BlobsManagerClient, file InputFile) error {
+func downloadBlobTo(ctx context.Context, blobsClient blobs_pb.BlobsManagerClient, blobId string, w io.Writer) error {
 	strm, err := blobsClient.GetCasBlob(ctx, &blobs_pb.GetCasBlobReq{
-		Id: file.BlobId,
+		Id: blobId,
 	})
 	if err != nil {
-		return errors.Wrapf(err, "failed to initiate download of blob %s", file.BlobId)
+		return errors.Wrapf(err, "failed to initiate download of blob %s", blobId)
 	}
 	defer func() { _ = strm.CloseSend() }()
-	f, err := os.Create(file.Path)
-	if err != nil {
-		return errors.Wrapf(err, "failed to open %s", file.Path)
-	}
-	defer func() { _ = f.Close() }()
 	for {
 		resp, err := strm.Recv()
 		if err != nil {
 			if go_errors.Is(err, io.EOF) {
 				break
 			}
-			return errors.Wrapf(err, "failed to download blob %s", file.BlobId)
+			return errors.Wrapf(err, "failed to download blob %s", blobId)
 		}
-		_, err = f.Write(resp.Bytes)
+		metrics.AddBytes(metrics.DirectionDownload, len(resp.Bytes))
+		_, err = w.Write(resp.Bytes)
 		if err != nil {
-			return errors.Wrapf(err, "failed to write to %s", file.Path)
+			return errors.Wrapf(err, "failed to write blob %s", blobId)
 		}
 	}
 	return nil
 }
 
+func downloadBlob(ctx context.Context, blobsClient blobs_pb.BlobsManagerClient, file InputFile) error {
+	f, err := os.Create(file.Path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", file.Path)
+	}
+	defer func() { _ = f.Close() }()
+	return downloadBlobTo(ctx, blobsClient, file.BlobId, f)
+}
+
+// dialProdvana connects to Prodvana. It is a variable so that tests can connect to a fake server instead.
+var dialProdvana = func() (*grpc.ClientConn, error) {
+	return client.MakeProdvanaConnection(client.DefaultConnectionOptions())
+}
+
+// writeResult writes result to stdout as JSON, which is how whatever ran pvn-wrapper gets it.
+func writeResult(result *pvn_wrapper_pb.Output) {
+	output, err := protojson.Marshal(result)
+	if err != nil {
+		// If something went wrong during encode/write to stdout, indicate that in stderr and exit non-zero.
+		cmdutil.Fatal(err)
+	}
+	_, err = os.Stdout.Write(output)
+	if err != nil {
+		cmdutil.Fatal(err)
+	}
+}
+
 // Handle the "main" function of wrapper commands.
 // This function never returns.
 func RunWrapper(inputFiles []InputFile, successExitCodes []int32, run func(context.Context) (*pvn_wrapper_pb.Output, []OutputFileUpload, error)) {
-	ctx := context.Background()
+	ctx := tracing.RootContext()
 	var conn *grpc.ClientConn
 	getProdvanaConnection := func() *grpc.ClientConn {
 		var err error
-		conn, err = client.MakeProdvanaConnection(client.DefaultConnectionOptions())
+		conn, err = dialProdvana()
 		if err != nil {
 			// TODO(naphat) should we return json in the event of infra errors too?
-			log.Fatal(err)
+			cmdutil.Fatal(err)
 		}
 		return conn
 	}
@@ -169,14 +198,24 @@ func RunWrapper(inputFiles []InputFile, successExitCodes []int32, run func(conte
 			_ = conn.Close()
 		}
 	}()
-	for _, input := range inputFiles {
-		if err := downloadBlob(ctx, getBlobsClient(), input); err != nil {
-			log.Fatal(err)
+	if len(inputFiles) > 0 {
+		downloadCtx, span := tracing.Tracer().Start(ctx, "download-inputs", trace.WithAttributes(attribute.Int("files", len(inputFiles))))
+		for _, input := range inputFiles {
+			if err := downloadBlob(downloadCtx, getBlobsClient(), input); err != nil {
+				tracing.EndSpan(span, err)
+				cmdutil.Fatal(err)
+			}
 		}
+		span.End()
 	}
 	startTs := time.Now()
-	result, outputFiles, err := run(ctx)
+	runCtx, runSpan := tracing.Tracer().Start(ctx, "run")
+	result, outputFiles, err := run(runCtx)
 	duration := time.Since(startTs)
+	if err == nil {
+		runSpan.SetAttributes(attribute.Int("exit_code", int(result.ExitCode)))
+	}
+	tracing.EndSpan(runSpan, err)
 	if err != nil {
 		result = &pvn_wrapper_pb.Output{}
 		result.ExecError = err.Error()
@@ -196,11 +235,19 @@ func RunWrapper(inputFiles []InputFile, successExitCodes []int32, run func(conte
 			break
 		}
 	}
+	jobId := os.Getenv("PVN_JOB_ID")
 	if len(outputFiles) > 0 {
 		defer func() { _ = conn.Close() }()
-		for _, file := range outputFiles {
-			id, uploadErr := uploadOutput(ctx, getBlobsClient(), file)
+		uploadCtx, uploadSpan := tracing.Tracer().Start(ctx, "upload-outputs", trace.WithAttributes(attribute.Int("files", len(outputFiles))))
+		for i, file := range outputFiles {
+			id, uploadErr := uploadOutput(uploadCtx, getBlobsClient(), file)
 			if uploadErr != nil {
+				if !os.IsNotExist(uploadErr) {
+					tracing.EndSpan(uploadSpan, uploadErr)
+				}
+				if jobId != "" && !os.IsNotExist(uploadErr) {
+					spoolAndExit(jobId, result, outputFiles[i:], errors.Wrap(uploadErr, "failed to upload output files"))
+				}
 				if !os.IsNotExist(uploadErr) || isSuccessful {
 					// for IsNotExist errors in the event the program did not exit successfully, do not hard error on missing output file.
 					// TODO(naphat) should we return json in the event of infra errors too?
@@ -218,14 +265,14 @@ func RunWrapper(inputFiles []InputFile, successExitCodes []int32, run func(conte
 						if file.Path != "" {
 							bytes, err = os.ReadFile(file.Path)
 							if err != nil {
-								log.Printf("Failed to read file %s: %v", file.Path, err)
+								slog.Warn("Failed to read output file", "path", file.Path, "error", err)
 							}
 						} else {
 							bytes = file.Content
 						}
 						_, err = os.Stdout.Write(bytes)
 						if err != nil {
-							log.Printf("Failed to write output %s for debugging: %+v", file.Name, err)
+							slog.Warn("Failed to write output for debugging", "name", file.Name, "error", err)
 						}
 					}
 					fileName := file.Path
@@ -235,60 +282,45 @@ func RunWrapper(inputFiles []InputFile, successExitCodes []int32, run func(conte
 					if file.Stdout {
 						fileName = "stdout"
 					}
-					log.Fatalf("failed to upload file %s: %+v\n", fileName, uploadErr)
+					cmdutil.Fatalf("failed to upload file %s: %+v\n", fileName, uploadErr)
 				}
 				continue
 			}
-			if file.Stdout {
-				if result.StdoutBlobId != "" {
-					log.Fatal("internal error: multiple stdout provided")
-				}
-				result.StdoutBlobId = id
-			} else if file.Stderr {
-				if result.StderrBlobId != "" {
-					log.Fatal("internal error: multiple stderr provided")
-				}
-				result.StderrBlobId = id
-			} else {
-				result.Files = append(result.Files, &pvn_wrapper_pb.OutputFile{
-					Name:          file.Name,
-					ContentBlobId: id,
-				})
+			if err := attachOutput(result, file, id); err != nil {
+				cmdutil.Fatal(err)
 			}
 		}
+		uploadSpan.End()
 	}
 
-	jobId := os.Getenv("PVN_JOB_ID")
 	if jobId != "" {
-		_, err := getJobClient().ReportJobResult(ctx, &pvn_wrapper_pb.ReportJobResultReq{
+		reportCtx, reportSpan := tracing.Tracer().Start(ctx, "report-result", trace.WithAttributes(attribute.String("job_id", jobId)))
+		_, err := getJobClient().ReportJobResult(reportCtx, &pvn_wrapper_pb.ReportJobResultReq{
 			JobId:  jobId,
 			Output: result,
 		})
+		tracing.EndSpan(reportSpan, err)
 		if err != nil {
-			log.Fatal(err)
+			spoolAndExit(jobId, result, nil, errors.Wrap(err, "failed to report job result"))
 		}
 	}
 
-	output, err := protojson.Marshal(result)
-	if err != nil {
-		// If something went wrong during encode/write to stdout, indicate that in stderr and exit non-zero.
-		log.Fatal(err)
-	}
-	_, err = os.Stdout.Write(output)
-	if err != nil {
-		log.Fatal(err)
-	}
+	writeResult(result)
 
 	// If the wrapped process fails, make sure this process has a non-zero exit code.
 	// This is to maintain compatibility with existing task execution infrastructure.
 	// Once we enforce the use of this wrapper, we can safely exit 0 here.
-	os.Exit(int(result.ExitCode))
+	cmdutil.Exit(int(result.ExitCode))
 }
 
+// RunCmd runs cmd, capturing stdout and stderr as output files.
+// ::pvn-output:: and ::pvn-event:: markers printed to stdout are stripped from the captured stdout
+// and returned as additional output files instead.
 func RunCmd(cmd *exec.Cmd) (*pvn_wrapper_pb.Output, []OutputFileUpload, error) {
 	stdout := new(bytes.Buffer)
 	stderr := new(bytes.Buffer)
-	cmd.Stdout = stdout
+	markers := newMarkerWriter(stdout)
+	cmd.Stdout = markers
 	cmd.Stderr = stderr
 
 	var result pvn_wrapper_pb.Output
@@ -304,7 +336,13 @@ func RunCmd(cmd *exec.Cmd) (*pvn_wrapper_pb.Output, []OutputFileUpload, error) {
 		}
 	}
 
-	return &result, []OutputFileUpload{
+	markers.Flush()
+	markerOutputs, err := markers.OutputFiles()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to encode output markers")
+	}
+
+	return &result, append([]OutputFileUpload{
 		{
 			Stdout:  true,
 			Content: stdout.Bytes(),
@@ -313,5 +351,5 @@ func RunCmd(cmd *exec.Cmd) (*pvn_wrapper_pb.Output, []OutputFileUpload, error) {
 			Stderr:  true,
 			Content: stderr.Bytes(),
 		},
-	}, nil
+	}, markerOutputs...), nil
 }
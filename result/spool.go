@@ -0,0 +1,289 @@
+package result
+
+import (
+	"context"
+	"encoding/json"
+	go_errors "errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	blobs_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/blobs"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/prodvana/pvn-wrapper/cmdutil"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const spoolManifestName = "manifest.json"
+
+// SpoolDir is where job results are persisted when they cannot be reported to Prodvana.
+// Each job gets its own subdirectory, named after the job ID.
+// It defaults to a directory in the user's home rather than os.TempDir(), which is often cleared on reboot.
+var SpoolDir = defaultSpoolDir()
+
+func defaultSpoolDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		// spooling fails with an error asking for --spool-dir
+		return ""
+	}
+	return filepath.Join(home, ".pvn-wrapper", "spool")
+}
+
+type spooledUpload struct {
+	Name   string `json:"name"`
+	Stdout bool   `json:"stdout"`
+	Stderr bool   `json:"stderr"`
+	// path relative to the job's spool directory
+	Path string `json:"path"`
+}
+
+type spoolManifest struct {
+	JobId          string          `json:"jobId"`
+	Output         json.RawMessage `json:"output"`
+	PendingUploads []spooledUpload `json:"pendingUploads"`
+}
+
+// attachOutput records an uploaded file in result.
+func attachOutput(result *pvn_wrapper_pb.Output, file OutputFileUpload, id string) error {
+	if file.Stdout {
+		if result.StdoutBlobId != "" {
+			return errors.New("internal error: multiple stdout provided")
+		}
+		result.StdoutBlobId = id
+	} else if file.Stderr {
+		if result.StderrBlobId != "" {
+			return errors.New("internal error: multiple stderr provided")
+		}
+		result.StderrBlobId = id
+	} else {
+		result.Files = append(result.Files, &pvn_wrapper_pb.OutputFile{
+			Name:          file.Name,
+			ContentBlobId: id,
+		})
+	}
+	return nil
+}
+
+// checkJobId makes sure jobId names a directory directly in the spool dir, since spooled jobs are removed by job ID.
+func checkJobId(jobId string) error {
+	if jobId == "" || jobId == "." || jobId == ".." || filepath.Base(jobId) != jobId {
+		return errors.Errorf("invalid job ID %q", jobId)
+	}
+	return nil
+}
+
+func spoolJobDir(jobId string) string {
+	return filepath.Join(SpoolDir, jobId)
+}
+
+// spoolResult persists result and the files that still need to be uploaded, so that they can be reported later
+// with ReportPending. Spooling the same job again replaces the previous entry.
+// Files that do not exist are skipped, matching how RunWrapper treats missing output files.
+func spoolResult(jobId string, result *pvn_wrapper_pb.Output, pending []OutputFileUpload) (string, error) {
+	if err := checkJobId(jobId); err != nil {
+		return "", err
+	}
+	if SpoolDir == "" {
+		return "", errors.New("no spool dir, the home directory is unknown. Pass --spool-dir")
+	}
+	if err := os.MkdirAll(SpoolDir, 0o700); err != nil {
+		return "", errors.Wrapf(err, "failed to create spool dir %s", SpoolDir)
+	}
+	// write to a temp dir and rename it into place at the end, so that a partially written entry is never replayed
+	tmpDir, err := os.MkdirTemp(SpoolDir, ".tmp-"+jobId)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create spool entry")
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	outputJson, err := protojson.Marshal(result)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal output")
+	}
+	manifest := spoolManifest{
+		JobId:  jobId,
+		Output: outputJson,
+	}
+	for i, file := range pending {
+		content := file.Content
+		if file.Path != "" {
+			content, err = os.ReadFile(file.Path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return "", errors.Wrapf(err, "failed to read %s", file.Path)
+			}
+		}
+		name := fmt.Sprintf("upload-%d", i)
+		if err := os.WriteFile(filepath.Join(tmpDir, name), content, 0o600); err != nil {
+			return "", errors.Wrapf(err, "failed to spool %s", name)
+		}
+		manifest.PendingUploads = append(manifest.PendingUploads, spooledUpload{
+			Name:   file.Name,
+			Stdout: file.Stdout,
+			Stderr: file.Stderr,
+			Path:   name,
+		})
+	}
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to marshal spool manifest")
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, spoolManifestName), manifestBytes, 0o600); err != nil {
+		return "", errors.Wrap(err, "failed to write spool manifest")
+	}
+	jobDir := spoolJobDir(jobId)
+	if err := os.RemoveAll(jobDir); err != nil {
+		return "", errors.Wrapf(err, "failed to remove previous spool entry %s", jobDir)
+	}
+	if err := os.Rename(tmpDir, jobDir); err != nil {
+		return "", errors.Wrapf(err, "failed to move spool entry into place")
+	}
+	return jobDir, nil
+}
+
+// spoolAndExit persists a result that failed to be reported, then still writes it to stdout and exits with 1.
+func spoolAndExit(jobId string, result *pvn_wrapper_pb.Output, pending []OutputFileUpload, reportErr error) {
+	jobDir, err := spoolResult(jobId, result, pending)
+	if err != nil {
+		slog.Error("Failed to report result, and failed to spool it", "job_id", jobId, "error", fmt.Sprintf("%+v", reportErr), "spool_error", err)
+	} else {
+		slog.Error("Failed to report result, it was spooled, run pvn-wrapper report-pending to report it", "job_id", jobId, "spool_dir", jobDir, "error", fmt.Sprintf("%+v", reportErr))
+	}
+	writeResult(result)
+	cmdutil.Exit(1)
+}
+
+func readSpoolManifest(jobDir string) (*spoolManifest, *pvn_wrapper_pb.Output, error) {
+	manifestBytes, err := os.ReadFile(filepath.Join(jobDir, spoolManifestName))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to read spool manifest")
+	}
+	var manifest spoolManifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal spool manifest")
+	}
+	var result pvn_wrapper_pb.Output
+	if err := protojson.Unmarshal(manifest.Output, &result); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal spooled output")
+	}
+	return &manifest, &result, nil
+}
+
+func reportSpooled(
+	ctx context.Context,
+	blobsClient blobs_pb.BlobsManagerClient,
+	jobClient pvn_wrapper_pb.JobManagerClient,
+	jobDir string,
+) error {
+	manifest, result, err := readSpoolManifest(jobDir)
+	if err != nil {
+		return err
+	}
+	for i, upload := range manifest.PendingUploads {
+		file := OutputFileUpload{
+			Name:   upload.Name,
+			Stdout: upload.Stdout,
+			Stderr: upload.Stderr,
+			Path:   filepath.Join(jobDir, upload.Path),
+		}
+		id, err := uploadOutput(ctx, blobsClient, file)
+		if err != nil {
+			// persist progress so that successful uploads are not repeated on the next attempt
+			if _, spoolErr := spoolResult(manifest.JobId, result, spooledFiles(jobDir, manifest.PendingUploads[i:])); spoolErr != nil {
+				slog.Warn("Failed to update spool entry", "job_id", manifest.JobId, "error", spoolErr)
+			}
+			return errors.Wrapf(err, "failed to upload %s", upload.Path)
+		}
+		if err := attachOutput(result, file, id); err != nil {
+			return err
+		}
+	}
+	_, err = jobClient.ReportJobResult(ctx, &pvn_wrapper_pb.ReportJobResultReq{
+		JobId:  manifest.JobId,
+		Output: result,
+	})
+	if err != nil && status.Code(err) != codes.AlreadyExists {
+		if len(manifest.PendingUploads) > 0 {
+			if _, spoolErr := spoolResult(manifest.JobId, result, nil); spoolErr != nil {
+				slog.Warn("Failed to update spool entry", "job_id", manifest.JobId, "error", spoolErr)
+			}
+		}
+		return errors.Wrapf(err, "failed to report result for job %s", manifest.JobId)
+	}
+	return os.RemoveAll(jobDir)
+}
+
+func spooledFiles(jobDir string, uploads []spooledUpload) []OutputFileUpload {
+	files := make([]OutputFileUpload, 0, len(uploads))
+	for _, upload := range uploads {
+		files = append(files, OutputFileUpload{
+			Name:   upload.Name,
+			Stdout: upload.Stdout,
+			Stderr: upload.Stderr,
+			Path:   filepath.Join(jobDir, upload.Path),
+		})
+	}
+	return files
+}
+
+// ReportPending reports results spooled by RunWrapper to Prodvana, removing them from the spool once reported.
+// If jobIds is empty, every spooled result is reported.
+// Reporting is idempotent on job ID: a result that Prodvana already has is treated as reported.
+func ReportPending(ctx context.Context, jobIds []string) error {
+	if len(jobIds) == 0 {
+		entries, err := os.ReadDir(SpoolDir)
+		if err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "failed to read spool dir %s", SpoolDir)
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && entry.Name()[0] != '.' {
+				jobIds = append(jobIds, entry.Name())
+			}
+		}
+	}
+	for _, jobId := range jobIds {
+		if err := checkJobId(jobId); err != nil {
+			return err
+		}
+	}
+	if len(jobIds) == 0 {
+		slog.Info("No pending results", "spool_dir", SpoolDir)
+		return nil
+	}
+	conn, err := dialProdvana()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+	blobsClient := blobs_pb.NewBlobsManagerClient(conn)
+	jobClient := pvn_wrapper_pb.NewJobManagerClient(conn)
+	var errs []error
+	for _, jobId := range jobIds {
+		slog.Info("Reporting result", "job_id", jobId)
+		if err := reportSpooled(ctx, blobsClient, jobClient, spoolJobDir(jobId)); err != nil {
+			slog.Error("Failed to report result", "job_id", jobId, "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		slog.Info("Reported result", "job_id", jobId)
+	}
+	return go_errors.Join(errs...)
+}
+
+// LoadSpooled returns the result spooled for jobId, along with the files that were not uploaded yet.
+// Pending files are only readable until the result is reported.
+func LoadSpooled(jobId string) (*pvn_wrapper_pb.Output, []OutputFileUpload, error) {
+	jobDir := spoolJobDir(jobId)
+	manifest, result, err := readSpoolManifest(jobDir)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "no spooled result for job %s", jobId)
+	}
+	return result, spooledFiles(jobDir, manifest.PendingUploads), nil
+}
@@ -0,0 +1,186 @@
+package result
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	blobs_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/blobs"
+	pvn_wrapper_pb "github.com/prodvana/prodvana-public/go/prodvana-sdk/proto/prodvana/pvn_wrapper"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestSpoolResult(t *testing.T) {
+	SpoolDir = t.TempDir()
+	outFile := filepath.Join(t.TempDir(), "out")
+	require.NoError(t, os.WriteFile(outFile, []byte("plan"), 0o600))
+
+	result := &pvn_wrapper_pb.Output{
+		ExitCode:     2,
+		StdoutBlobId: "stdout-blob",
+	}
+	pending := []OutputFileUpload{
+		{Stderr: true, Content: []byte("oops")},
+		{Name: "plan", Path: outFile},
+		{Name: "missing", Path: filepath.Join(t.TempDir(), "missing")},
+	}
+	jobDir, err := spoolResult("job-1", result, pending)
+	require.NoError(t, err)
+	require.Equal(t, filepath.Join(SpoolDir, "job-1"), jobDir)
+
+	// spooling again replaces the previous entry
+	jobDir, err = spoolResult("job-1", result, pending)
+	require.NoError(t, err)
+	entries, err := os.ReadDir(SpoolDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	manifest, spooled, err := readSpoolManifest(jobDir)
+	require.NoError(t, err)
+	require.Equal(t, "job-1", manifest.JobId)
+	require.True(t, proto.Equal(result, spooled))
+	files := spooledFiles(jobDir, manifest.PendingUploads)
+	require.Len(t, files, 2)
+	require.True(t, files[0].Stderr)
+	require.Equal(t, "plan", files[1].Name)
+	for i, want := range []string{"oops", "plan"} {
+		content, err := os.ReadFile(files[i].Path)
+		require.NoError(t, err)
+		require.Equal(t, want, string(content))
+	}
+
+	for _, jobId := range []string{"", ".", "..", "../job-1", "job/1"} {
+		_, err = spoolResult(jobId, result, nil)
+		require.ErrorContains(t, err, "invalid job ID")
+	}
+}
+
+func TestDefaultSpoolDir(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("USERPROFILE", home)
+	require.Equal(t, filepath.Join(home, ".pvn-wrapper", "spool"), defaultSpoolDir())
+
+	prevSpoolDir := SpoolDir
+	t.Cleanup(func() { SpoolDir = prevSpoolDir })
+	SpoolDir = ""
+	_, err := spoolResult("job-1", &pvn_wrapper_pb.Output{}, nil)
+	require.ErrorContains(t, err, "--spool-dir")
+}
+
+func spoolTestResult(t *testing.T) *pvn_wrapper_pb.Output {
+	SpoolDir = t.TempDir()
+	result := &pvn_wrapper_pb.Output{ExitCode: 2, StdoutBlobId: "stdout-blob"}
+	_, err := spoolResult("job-1", result, []OutputFileUpload{
+		{Stderr: true, Content: []byte("oops")},
+		{Name: "plan", Content: []byte("plan")},
+	})
+	require.NoError(t, err)
+	return result
+}
+
+func TestReportSpooled(t *testing.T) {
+	ctx := context.Background()
+	prevSpoolDir := SpoolDir
+	t.Cleanup(func() { SpoolDir = prevSpoolDir })
+	fake := startFakeProdvana(t)
+	conn, err := dialProdvana()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	blobsClient := blobs_pb.NewBlobsManagerClient(conn)
+	jobClient := pvn_wrapper_pb.NewJobManagerClient(conn)
+
+	t.Run("reported", func(t *testing.T) {
+		spoolTestResult(t)
+		jobDir := spoolJobDir("job-1")
+		require.NoError(t, reportSpooled(ctx, blobsClient, jobClient, jobDir))
+		reported := fake.result("job-1")
+		require.NotNil(t, reported)
+		require.Equal(t, int32(2), reported.ExitCode)
+		require.Equal(t, "stdout-blob", reported.StdoutBlobId)
+		require.Equal(t, "oops", fake.blob(t, reported.StderrBlobId))
+		require.Len(t, reported.Files, 1)
+		require.Equal(t, "plan", reported.Files[0].Name)
+		require.Equal(t, "plan", fake.blob(t, reported.Files[0].ContentBlobId))
+		require.NoDirExists(t, jobDir)
+	})
+
+	t.Run("already reported", func(t *testing.T) {
+		spoolTestResult(t)
+		require.NoError(t, reportSpooled(ctx, blobsClient, jobClient, spoolJobDir("job-1")))
+		require.NoDirExists(t, spoolJobDir("job-1"))
+	})
+
+	t.Run("failed upload keeps progress", func(t *testing.T) {
+		fake.results = map[string]*pvn_wrapper_pb.Output{}
+		spoolTestResult(t)
+		fake.uploadErrs = []error{nil, status.Error(codes.Unavailable, "down")}
+		err := reportSpooled(ctx, blobsClient, jobClient, spoolJobDir("job-1"))
+		require.ErrorContains(t, err, "down")
+		require.Nil(t, fake.result("job-1"))
+		// the stderr upload succeeded, so only the plan is left
+		manifest, result, err := readSpoolManifest(spoolJobDir("job-1"))
+		require.NoError(t, err)
+		require.Len(t, manifest.PendingUploads, 1)
+		require.Equal(t, "plan", manifest.PendingUploads[0].Name)
+		require.Equal(t, "oops", fake.blob(t, result.StderrBlobId))
+
+		require.NoError(t, reportSpooled(ctx, blobsClient, jobClient, spoolJobDir("job-1")))
+		reported := fake.result("job-1")
+		require.Equal(t, result.StderrBlobId, reported.StderrBlobId)
+		require.Equal(t, "plan", fake.blob(t, reported.Files[0].ContentBlobId))
+	})
+
+	t.Run("failed report keeps uploads", func(t *testing.T) {
+		fake.results = map[string]*pvn_wrapper_pb.Output{}
+		spoolTestResult(t)
+		fake.reportErrs = []error{status.Error(codes.Unavailable, "down")}
+		err := reportSpooled(ctx, blobsClient, jobClient, spoolJobDir("job-1"))
+		require.ErrorContains(t, err, "failed to report result for job job-1")
+		manifest, result, err := readSpoolManifest(spoolJobDir("job-1"))
+		require.NoError(t, err)
+		require.Empty(t, manifest.PendingUploads)
+		require.NotEmpty(t, result.StderrBlobId)
+		require.Len(t, result.Files, 1)
+	})
+}
+
+func TestReportPending(t *testing.T) {
+	ctx := context.Background()
+	prevSpoolDir := SpoolDir
+	t.Cleanup(func() { SpoolDir = prevSpoolDir })
+	fake := startFakeProdvana(t)
+
+	SpoolDir = filepath.Join(t.TempDir(), "missing")
+	require.NoError(t, ReportPending(ctx, nil))
+
+	spoolTestResult(t)
+	_, err := spoolResult("job-2", &pvn_wrapper_pb.Output{ExitCode: 1}, nil)
+	require.NoError(t, err)
+	// a partially written entry is skipped
+	require.NoError(t, os.Mkdir(filepath.Join(SpoolDir, ".tmp-job-3"), 0o700))
+
+	require.ErrorContains(t, ReportPending(ctx, []string{"job-2", "job-4"}), "job-4")
+	require.NotNil(t, fake.result("job-2"))
+	require.Nil(t, fake.result("job-1"))
+
+	require.NoError(t, ReportPending(ctx, nil))
+	require.NotNil(t, fake.result("job-1"))
+	entries, err := os.ReadDir(SpoolDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	require.Equal(t, ".tmp-job-3", entries[0].Name())
+
+	// job IDs outside the spool dir are rejected before anything is removed
+	outside := filepath.Join(filepath.Dir(SpoolDir), "outside")
+	require.NoError(t, os.Mkdir(outside, 0o700))
+	for _, jobId := range []string{"../outside", "..", "."} {
+		require.ErrorContains(t, ReportPending(ctx, []string{jobId}), "invalid job ID")
+	}
+	require.DirExists(t, outside)
+	require.DirExists(t, SpoolDir)
+}
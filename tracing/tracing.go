@@ -0,0 +1,155 @@
+package tracing
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName   = "github.com/prodvana/pvn-wrapper"
+	serviceName  = "pvn-wrapper"
+	flushTimeout = 5 * time.Second
+)
+
+// Endpoint is the OTLP/HTTP endpoint to export spans to, e.g. http://localhost:4318.
+// If empty, the standard OTEL_EXPORTER_OTLP_* environment variables are used.
+// If neither is set, spans are not exported, but trace context is still propagated to child processes.
+var Endpoint string
+
+var (
+	propagator = propagation.TraceContext{}
+	provider   *sdktrace.TracerProvider
+	rootCtx    = context.Background()
+	rootSpan   trace.Span
+)
+
+// envCarrier adapts a list of KEY=VALUE environment variables to a propagation.TextMapCarrier,
+// using the uppercase TRACEPARENT/TRACESTATE convention for environment variables.
+type envCarrier map[string]string
+
+func newEnvCarrier(env []string) envCarrier {
+	carrier := envCarrier{}
+	for _, pair := range env {
+		k, v, found := strings.Cut(pair, "=")
+		if found {
+			carrier[k] = v
+		}
+	}
+	return carrier
+}
+
+func (c envCarrier) Get(key string) string {
+	return c[strings.ToUpper(key)]
+}
+
+func (c envCarrier) Set(key, value string) {
+	c[strings.ToUpper(key)] = value
+}
+
+func (c envCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func exportEnabled() bool {
+	return Endpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// Start sets up tracing for this invocation of pvn-wrapper and starts its root span, in a context derived from parent.
+// If pvn-wrapper was itself started with a TRACEPARENT, the root span is a child of it.
+// Shutdown must be called before exiting to flush spans.
+func Start(parent context.Context, name, version string) context.Context {
+	ctx := propagator.Extract(parent, newEnvCarrier(os.Environ()))
+	if exportEnabled() {
+		var opts []otlptracehttp.Option
+		if Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(Endpoint))
+		}
+		exporter, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			slog.Warn("Failed to set up trace exporter, traces will not be exported", "error", err)
+		} else {
+			provider = sdktrace.NewTracerProvider(
+				sdktrace.WithBatcher(exporter),
+				sdktrace.WithResource(resource.NewSchemaless(
+					attribute.String("service.name", serviceName),
+					attribute.String("service.version", version),
+				)),
+			)
+			otel.SetTracerProvider(provider)
+		}
+	}
+	rootCtx, rootSpan = Tracer().Start(ctx, name)
+	return rootCtx
+}
+
+// Shutdown ends the root span and flushes all spans. Safe to call multiple times.
+func Shutdown() {
+	if rootSpan != nil {
+		rootSpan.End()
+		rootSpan = nil
+	}
+	if provider != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), flushTimeout)
+		defer cancel()
+		if err := provider.Shutdown(ctx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+		provider = nil
+	}
+}
+
+// RootContext returns the context of the root span started by Start, for code paths that do not have a context.
+func RootContext() context.Context {
+	return rootCtx
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// EndSpan records err, if any, on span, then ends it.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// InjectEnv returns env with TRACEPARENT (and TRACESTATE, if any) set to the span in ctx,
+// so that child processes can continue the trace.
+func InjectEnv(ctx context.Context, env []string) []string {
+	carrier := envCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return env
+	}
+	injected := make([]string, 0, len(env)+len(carrier))
+	for _, pair := range env {
+		k, _, _ := strings.Cut(pair, "=")
+		if _, ok := carrier[k]; ok {
+			continue
+		}
+		injected = append(injected, pair)
+	}
+	for k, v := range carrier {
+		injected = append(injected, k+"="+v)
+	}
+	return injected
+}
@@ -0,0 +1,72 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	collector_trace_pb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector is a stand-in for an OTLP/HTTP collector that records the names of exported spans.
+type collector struct {
+	mu    sync.Mutex
+	spans []string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil || r.URL.Path != "/v1/traces" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var req collector_trace_pb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans = append(c.spans, span.Name)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	resp, _ := proto.Marshal(&collector_trace_pb.ExportTraceServiceResponse{})
+	_, _ = w.Write(resp)
+}
+
+func TestExportAndPropagate(t *testing.T) {
+	c := &collector{}
+	srv := httptest.NewServer(c)
+	defer srv.Close()
+
+	const parentTraceId = "4bf92f3577b34da6a3ce929d0eba9b7f"
+	t.Setenv("TRACEPARENT", "00-"+parentTraceId+"-00f067aa0ba902b7-01")
+	Endpoint = srv.URL
+	defer func() { Endpoint = "" }()
+
+	ctx := Start(context.Background(), "pvn-wrapper test", "dev")
+	childCtx, span := Tracer().Start(ctx, "child")
+	env := InjectEnv(childCtx, []string{"FOO=bar", "TRACEPARENT=stale"})
+	span.End()
+	Shutdown()
+
+	require.Len(t, env, 2)
+	require.Equal(t, "FOO=bar", env[0])
+	require.True(t, strings.HasPrefix(env[1], "TRACEPARENT=00-"+parentTraceId+"-"), env[1])
+	require.Equal(t, "00-"+parentTraceId+"-"+span.SpanContext().SpanID().String()+"-01", strings.TrimPrefix(env[1], "TRACEPARENT="))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	require.ElementsMatch(t, []string{"pvn-wrapper test", "child"}, c.spans)
+}